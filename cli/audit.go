@@ -0,0 +1,78 @@
+package cli
+
+import (
+	"babybluefs/store"
+	"github.com/fatih/color"
+	"strings"
+	"time"
+)
+
+func parseAuditTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", s, time.Local)
+}
+
+// Audit verifies the audit log of a store or shows its records, optionally filtered by path and time range
+func Audit(args []string) {
+	if len(args) < 2 {
+		color.Green("usage: audit [verify store|show store[/path] [from [to]]]")
+		return
+	}
+
+	name, ph, _ := strings.Cut(args[1], "/")
+	c, err := readConfig(name)
+	if err != nil {
+		return
+	}
+	log, folder, err := store.OpenAuditLog(c)
+	if err != nil {
+		color.Red("cannot open audit log of %s: %v", name, err)
+		return
+	}
+	defer log.Close()
+
+	switch args[0] {
+	case "verify":
+		cnt, err := store.VerifyAudit(log, folder)
+		if err != nil {
+			color.Red("audit log of %s is not valid after %d records: %v", name, cnt, err)
+			return
+		}
+		color.Green("audit log of %s is valid: %d records", name, cnt)
+	case "show":
+		filter := store.AuditFilter{Path: ph}
+		if len(args) > 2 {
+			if filter.From, err = parseAuditTime(args[2]); err != nil {
+				color.Red("invalid time %s: %v", args[2], err)
+				return
+			}
+		}
+		if len(args) > 3 {
+			if filter.To, err = parseAuditTime(args[3]); err != nil {
+				color.Red("invalid time %s: %v", args[3], err)
+				return
+			}
+		}
+		records, err := store.ReadAudit(log, folder, filter)
+		if err != nil {
+			color.Red("cannot read audit log of %s: %v", name, err)
+			return
+		}
+		for _, r := range records {
+			target := r.Path
+			if r.Dest != "" {
+				target = r.Path + " -> " + r.Dest
+			}
+			if r.Error != "" {
+				color.Red("%s\t%s\t%s\t%s\t%s", r.Time.Local().Format(time.RFC3339), r.Actor, r.Op, target, r.Error)
+			} else {
+				color.Green("%s\t%s\t%s\t%s\t%d\t%x", r.Time.Local().Format(time.RFC3339), r.Actor, r.Op, target,
+					r.Size, r.CRC64)
+			}
+		}
+	default:
+		color.Red("unknown audit command %s", args[0])
+	}
+}
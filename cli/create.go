@@ -115,6 +115,16 @@ func Create(transport string) {
 		}
 	}
 
+	// credentials are kept in the vault, so that the configuration can be shared
+	file := filepath.Join(home, name)
+	if c.Name != "" {
+		file = filepath.Join(home, fmt.Sprintf("%s.yaml", c.Name))
+	}
+	if err = sealSecrets(&c, file); err != nil {
+		color.Red("cannot move the credentials of %s to the vault: %v", file, err)
+		os.Exit(1)
+	}
+
 	color.Green("configuration %s created", c.Name)
 }
 
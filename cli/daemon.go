@@ -0,0 +1,113 @@
+package cli
+
+import (
+	"babybluefs/index"
+	"babybluefs/mesh"
+	"babybluefs/store"
+	"flag"
+	"github.com/fatih/color"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// daemonPeriod is the time between two syncs of the mesh in daemon mode
+const daemonPeriod = time.Minute
+
+// localIndex is an index of the local folder of a mesh
+type localIndex interface {
+	Covers(folder string) bool
+	Build(f store.FS, folder string) error
+	Save() error
+	Follow(f store.FS, changes <-chan string)
+}
+
+// indexLocal builds the index and the full-text index of the local folder of a mesh and keeps them current
+// with the changes of the syncs. It returns the monitor to pass to mesh.Sync, or nil when the folder
+// cannot be indexed
+func indexLocal(m *mesh.Mesh, folder string) chan string {
+	folder, _ = filepath.Abs(folder)
+	name := localIndexName(folder)
+	var idxs []localIndex
+	if idx, err := openIndex(name); err == nil {
+		idxs = append(idxs, idx)
+	} else {
+		logrus.Warnf("cannot open the index of %s: %v", folder, err)
+	}
+	if ft, err := openTextIndex(name); err == nil {
+		idxs = append(idxs, ft)
+	} else {
+		logrus.Warnf("cannot open the full-text index of %s: %v", folder, err)
+	}
+
+	var followers []chan string
+	for _, idx := range idxs {
+		if !idx.Covers("") {
+			err := idx.Build(m.Local, "")
+			if err == nil {
+				err = idx.Save()
+			}
+			if err != nil {
+				logrus.Warnf("cannot index %s: %v", folder, err)
+				continue
+			}
+		}
+		changes := make(chan string)
+		go idx.Follow(m.Local, changes)
+		followers = append(followers, changes)
+	}
+	if len(followers) == 0 {
+		return nil
+	}
+
+	mon := make(chan string)
+	go func() {
+		for name := range index.SyncEvents(mon) {
+			for _, changes := range followers {
+				changes <- name
+			}
+		}
+	}()
+	return mon
+}
+
+// Daemon keeps a mesh in sync with a local folder. With --index the indexes of the folder are kept current
+// too. When an address is provided, the metrics are exposed in the Prometheus format on the /metrics endpoint
+func Daemon(args []string) {
+	fs := flag.NewFlagSet("daemon", flag.ContinueOnError)
+	withIndex := fs.Bool("index", false, "builds the indexes of the folder and keeps them current")
+	if err := fs.Parse(args[2:]); err != nil {
+		return
+	}
+
+	m := openMesh(args[0], args[1], true)
+	var mon chan string
+	if *withIndex {
+		mon = indexLocal(m, args[1])
+	}
+
+	if fs.NArg() > 0 {
+		addr := fs.Arg(0)
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", store.DefaultMetrics)
+		go func() {
+			err := http.ListenAndServe(addr, mux)
+			if err != nil {
+				color.Red("cannot expose metrics on %s: %v", addr, err)
+				os.Exit(1)
+			}
+		}()
+		color.Green("metrics available on http://%s/metrics", addr)
+	}
+
+	color.Green("daemon started on mesh %s with folder %s", args[0], args[1])
+	for {
+		err := mesh.Sync(m, "", time.Time{}, mon)
+		if err != nil {
+			logrus.Warnf("sync of %s completed with errors: %v", args[0], err)
+		}
+		time.Sleep(daemonPeriod)
+	}
+}
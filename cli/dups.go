@@ -0,0 +1,68 @@
+package cli
+
+import (
+	"babybluefs/store"
+	"flag"
+	"github.com/fatih/color"
+	"path"
+	"path/filepath"
+)
+
+// Dups reports the files with the same content in one or more stores and, with an action, deletes or
+// links the extra copies within each store
+func Dups(args []string) {
+	var minSize, action string
+	fs := flag.NewFlagSet("dups", flag.ContinueOnError)
+	fs.StringVar(&minSize, "min-size", "1", "ignores the files smaller than a size, e.g. 1M")
+	fs.StringVar(&action, "action", "", "delete or link the extra copies within each store")
+	if err := fs.Parse(args); err != nil {
+		return
+	}
+	if fs.NArg() == 0 {
+		color.Green("missing target")
+		return
+	}
+	min, err := store.ParseSize(minSize)
+	if err != nil {
+		color.Red("invalid size %s: %v", minSize, err)
+		return
+	}
+	if action != "" && action != string(store.DuplicateDelete) && action != string(store.DuplicateLink) {
+		color.Red("unknown action %s", action)
+		return
+	}
+
+	var folders []store.StoreFolder
+	for _, target := range fs.Args() {
+		f, name, ph, err := GetFS(target)
+		if err != nil {
+			return
+		}
+		if isLocalPath(target) {
+			// local paths have no store name, their folder tells them apart
+			name = filepath.Dir(filepath.Clean(target))
+		}
+		folders = append(folders, store.StoreFolder{Name: name, F: f, Folder: ph})
+	}
+
+	report, err := store.FindDuplicates(folders, min)
+	if err != nil {
+		color.Red("some files have not been compared: %v", err)
+	}
+	for _, s := range report.Sets {
+		color.Green("%d copies of %d bytes, %d bytes wasted, sha256 %s", len(s.Files), s.Size, s.Wasted(), s.Hash)
+		for _, file := range s.Files {
+			color.Green("\t%s", path.Join(file.Store, file.Path))
+		}
+	}
+	color.Green("%d files compared, %d duplicate sets, %d bytes wasted", report.Files, len(report.Sets),
+		report.Wasted)
+
+	if action != "" {
+		changed, err := store.ResolveDuplicates(folders, report, store.DuplicateAction(action))
+		if err != nil {
+			color.Red("cannot %s some copies: %v", action, err)
+		}
+		color.Green("%d copies resolved with %s", changed, action)
+	}
+}
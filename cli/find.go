@@ -0,0 +1,129 @@
+package cli
+
+import (
+	"babybluefs/index"
+	"babybluefs/store"
+	"crypto/sha1"
+	"encoding/hex"
+	"flag"
+	"github.com/fatih/color"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// openIndex opens the index of the store name, kept in the index folder of the home
+func openIndex(name string) (*index.Index, error) {
+	return index.Open(store.NewLocalMount(filepath.Join(GetHome(), "index")), name)
+}
+
+// localIndexName is the name of the index of a local folder, after its absolute path
+func localIndexName(folder string) string {
+	h := sha1.Sum([]byte(folder))
+	return "local-" + hex.EncodeToString(h[:8])
+}
+
+// indexTarget returns the store and the folder of target, with the name of its index and the prefix of
+// the paths to show. A local folder has its own index, which the daemon of a mesh on the folder keeps
+// current when started with --index
+func indexTarget(target string) (f store.FS, name, prefix, ph string, err error) {
+	if !isLocalPath(target) {
+		f, name, ph, err = GetFS(target)
+		return f, name, name, ph, err
+	}
+	root, err := filepath.Abs(target)
+	if err != nil {
+		color.Red("invalid path %s: %v", target, err)
+		return nil, "", "", "", err
+	}
+	if l, err := os.Stat(root); err == nil && !l.IsDir() {
+		root, ph = filepath.Dir(root), filepath.Base(root)
+	}
+	return store.NewLocalMount(root), localIndexName(root), root, ph, nil
+}
+
+// Find queries the index of a store. The index is built when it does not cover the target yet and when
+// refresh is set. Later changes of the store are not seen without refresh, except on a local folder whose
+// mesh daemon runs with --index
+func Find(args []string) {
+	if len(args) < 1 {
+		color.Green("missing target")
+		return
+	}
+
+	var q index.Query
+	var larger, smaller, after, before, meta string
+	var refresh bool
+	fs := flag.NewFlagSet("find", flag.ContinueOnError)
+	fs.StringVar(&q.Name, "name", "", "glob pattern on the file name, e.g. '*.pdf'")
+	fs.StringVar(&q.ModifiedBy, "modified-by", "", "user that last modified the file")
+	fs.StringVar(&q.Group, "group", "", "group of the file")
+	fs.StringVar(&q.Mime, "mime", "", "MIME type, e.g. application/pdf or image/")
+	fs.StringVar(&q.Hash, "hash", "", "hash of the content")
+	fs.StringVar(&larger, "larger", "", "minimum size, e.g. 10M")
+	fs.StringVar(&smaller, "smaller", "", "maximum size, e.g. 1G")
+	fs.StringVar(&after, "after", "", "modified after a date or time")
+	fs.StringVar(&before, "before", "", "modified before a date or time")
+	fs.StringVar(&meta, "meta", "", "meta key, optionally with the value it contains, e.g. bbfs.worm or key=value")
+	fs.BoolVar(&refresh, "refresh", false, "walks the store to update the index")
+	if err := fs.Parse(args[1:]); err != nil {
+		return
+	}
+
+	var err error
+	if larger != "" {
+		if q.Larger, err = store.ParseSize(larger); err != nil {
+			color.Red("invalid size %s: %v", larger, err)
+			return
+		}
+	}
+	if smaller != "" {
+		if q.Smaller, err = store.ParseSize(smaller); err != nil {
+			color.Red("invalid size %s: %v", smaller, err)
+			return
+		}
+	}
+	if after != "" {
+		if q.After, err = parseAuditTime(after); err != nil {
+			color.Red("invalid time %s: %v", after, err)
+			return
+		}
+	}
+	if before != "" {
+		if q.Before, err = parseAuditTime(before); err != nil {
+			color.Red("invalid time %s: %v", before, err)
+			return
+		}
+	}
+	if meta != "" {
+		k, v, _ := strings.Cut(meta, "=")
+		q.Meta = map[string]string{k: v}
+	}
+
+	f, name, prefix, ph, err := indexTarget(args[0])
+	if err != nil {
+		return
+	}
+	idx, err := openIndex(name)
+	if err != nil {
+		color.Red("cannot open the index of %s: %v", args[0], err)
+		return
+	}
+	if refresh || !idx.Covers(ph) {
+		if err = idx.Build(f, ph); err == nil {
+			err = idx.Save()
+		}
+		if err != nil {
+			color.Red("cannot index %s: %v", args[0], err)
+			return
+		}
+	}
+
+	q.Folder = ph
+	for _, e := range idx.Find(q) {
+		color.Green("%s\t%d\t%s\t%s\t%s", path.Join(prefix, e.Path), e.Size, e.ModTime.Local().Format(time.RFC3339),
+			e.ModifiedBy, e.Mime)
+	}
+}
@@ -0,0 +1,36 @@
+package cli
+
+import (
+	"babybluefs/store"
+	"github.com/fatih/color"
+)
+
+// Fsck checks the meta sidecars of a store and, with repair, deletes the orphaned ones
+func Fsck(args []string) {
+	if len(args) < 1 {
+		color.Green("missing target")
+		return
+	}
+
+	f, _, ph, err := GetFS(args[0])
+	if err != nil {
+		return
+	}
+	repair := len(args) > 1 && args[1] == "repair"
+
+	report, err := store.Fsck(f, ph, repair)
+	for _, n := range report.Zombies {
+		color.Red("meta without file: %s", n)
+	}
+	for _, n := range report.Tombstones {
+		color.Red("meta left by an interrupted remove: %s", n)
+	}
+	for _, n := range report.Corrupted {
+		color.Red("corrupted meta: %s", n)
+	}
+	if err != nil {
+		color.Red("cannot repair %s: %v", args[0], err)
+	}
+	color.Green("%d inconsistencies found, %d repaired",
+		len(report.Zombies)+len(report.Tombstones)+len(report.Corrupted), report.Repaired)
+}
@@ -10,12 +10,16 @@ import (
 	"time"
 )
 
+// Mesh adds the stores names to the mesh target. The keys of new groups are generated and kept in the vault,
+// so that the mesh configuration only has vault references
 func Mesh(target string, names []string) {
-	var groups map[store.Group]bool
-	var mc mesh.Config
+	groups := map[store.Group]bool{}
 
 	f := store.NewLocalMount(GetHome())
-	mc, _ = mesh.ReadConfig(f, fmt.Sprintf("%s.yaml", target))
+	mc, _ := mesh.ReadConfig(f, fmt.Sprintf("%s.yaml", target))
+	if mc.Groups == nil {
+		mc.Groups = map[store.Group]string{}
+	}
 
 	for _, n := range names {
 		var c store.Config
@@ -29,11 +33,25 @@ func Mesh(target string, names []string) {
 		mc.Remotes = append(mc.Remotes, c)
 	}
 
+	v, err := getVault(true)
+	if err != nil {
+		color.Red("cannot open vault: %v", err)
+		os.Exit(1)
+	}
 	for group := range groups {
-		mc.Groups[group] = store.GenerateRandomString(32)
+		if _, ok := mc.Groups[group]; ok {
+			continue
+		}
+		id := fmt.Sprintf("mesh-%s-%s", target, group)
+		v.Set(id, store.GenerateRandomString(32))
+		mc.Groups[group] = store.VaultPrefix + id
+	}
+	if err = v.Save(f, vaultFile); err != nil {
+		color.Red("cannot save vault: %v", err)
+		os.Exit(1)
 	}
 
-	err := mesh.WriteConfig(f, target, mc)
+	err = mesh.WriteConfig(f, fmt.Sprintf("%s.yaml", target), mc)
 	if err != nil {
 		color.Red("cannot create mesh config in %s: %v", target, err)
 		os.Exit(1)
@@ -42,7 +60,9 @@ func Mesh(target string, names []string) {
 	color.Green("new mesh config %s", target)
 }
 
-func Sync(meshName, folder string) {
+// openMesh loads the mesh meshName and binds it to the local folder. When metrics is true,
+// the activity of the remotes is recorded in store.DefaultMetrics
+func openMesh(meshName, folder string, metrics bool) *mesh.Mesh {
 	f := store.NewLocalMount(GetHome())
 
 	var mc mesh.Config
@@ -51,6 +71,16 @@ func Sync(meshName, folder string) {
 		color.Red("cannot read mesh config %s: %v", meshName, err)
 		os.Exit(1)
 	}
+	err = resolveSecrets(&mc)
+	if err != nil {
+		color.Red("cannot resolve secrets for mesh %s: %v", meshName, err)
+		os.Exit(1)
+	}
+	if metrics {
+		for i := range mc.Remotes {
+			mc.Remotes[i].Metrics = true
+		}
+	}
 	var m mesh.Mesh
 	err = mesh.FromConfig(mc, &m, false)
 	if err != nil {
@@ -60,13 +90,22 @@ func Sync(meshName, folder string) {
 
 	folder, _ = filepath.Abs(folder)
 	stat, err := os.Stat(folder)
-	if !stat.IsDir() {
+	if err != nil || !stat.IsDir() {
 		color.Red("%s must be a folder", folder)
 		os.Exit(1)
 	}
 
 	m.Local = store.NewLocalMount(folder)
-	err = mesh.Sync(&m, "", time.Time{}, nil)
+	return &m
+}
+
+func Sync(meshName, folder string) {
+	m := openMesh(meshName, folder, false)
+	err := mesh.Sync(m, "", time.Time{}, nil)
+	if err != nil {
+		color.Red("sync of %s completed with errors: %v", meshName, err)
+		return
+	}
 
 	color.Green("sync completed %s", meshName)
 }
@@ -1,8 +1,10 @@
 package cli
 
 import (
+	"babybluefs/store"
 	"flag"
 	"fmt"
+	"github.com/fatih/color"
 	"github.com/sirupsen/logrus"
 	"os"
 )
@@ -20,9 +22,25 @@ func usage() {
 		"\tedit store                              edit an existing store configuration\n"+
 		"\tmesh name [storage...]                  create a mesh with provided storage list\n"+
 		"\tsync mesh                               align all the storage points in the mesh\n"+
+		"\tdaemon mesh folder [--index] [addr]     sync the mesh periodically, optionally keep the indexes of folder and expose metrics on addr\n"+
+		"\taudit [verify|show] store[/path]        verify the audit log or show it, optionally from and to a time\n"+
+		"\tversions store/path                     list the versions of a file\n"+
+		"\trestore store/path@version              restore a version of a file\n"+
+		"\ttrash [ls|restore|empty] store [id|age] list, restore or empty the trash of a store\n"+
+		"\tscrub store                             repair the lost shards of an erasure coded store\n"+
+		"\tfsck store[/path] [repair]              check and repair the meta sidecars of a store\n"+
+		"\tverify store[/path] [--record]          check the files against their hashes and record the missing ones\n"+
+		"\tfind store[/path] [options]             query the index of a store, e.g. --name *.pdf --modified-by alice --larger 10M\n"+
+		"\tsearch store[/path] words...            search the text of the documents of a store\n"+
+		"\tgrep store[/path] regexp                show the lines of the documents that match regexp\n"+
+		"\t                                        find, search and grep see the changes of a store with --refresh\n"+
+		"\tdups [--action delete|link] store[/path]... report the duplicated files, optionally deleting or linking the extra copies\n"+
+		"\tvault [ls|set id [value]|rm id]         manage secrets referenced as vault:id in configurations\n"+
+		"\t--bwlimit rate[:write]                  limits the total bandwidth, e.g. 1M or 2M:512K\n"+
 		"\t-v                                      shows verbose log\n"+
 		"\t-vv                                     shows a very verbose log\n\n"+
-		"Configuration will be stored in %s. Define SF_HOME variable for a different location\n\n", home)
+		"Configuration will be stored in %s. Define SF_HOME variable for a different location\n"+
+		"The vault is unlocked with SF_VAULT_PASSPHRASE or the key file in SF_VAULT_KEYFILE\n\n", home)
 }
 
 func setLogLevel(verbose, verbose2 bool) {
@@ -37,15 +55,28 @@ func setLogLevel(verbose, verbose2 bool) {
 }
 
 var argsMinLen = map[string]int{
-	"ls":     1,
-	"pull":   3,
-	"push":   3,
-	"create": 2,
-	"edit":   2,
-	"mesh":   2,
-	"shell":  1,
-	"mkdir":  2,
-	"rm":     2,
+	"ls":       1,
+	"pull":     3,
+	"push":     3,
+	"create":   2,
+	"edit":     2,
+	"mesh":     2,
+	"shell":    1,
+	"mkdir":    2,
+	"rm":       2,
+	"vault":    2,
+	"daemon":   3,
+	"audit":    3,
+	"versions": 2,
+	"restore":  2,
+	"trash":    3,
+	"scrub":    2,
+	"fsck":     2,
+	"verify":   2,
+	"find":     2,
+	"search":   3,
+	"grep":     3,
+	"dups":     2,
 }
 
 func checkArgs(args []string) {
@@ -62,12 +93,15 @@ func checkArgs(args []string) {
 func Process() {
 	var verbose bool
 	var verbose2 bool
+	var bwlimit string
 
 	flag.Usage = usage
 	flag.BoolVar(&verbose, "v", false,
 		"shows verbose log")
 	flag.BoolVar(&verbose2, "vv", false,
 		"shows very verbose log")
+	flag.StringVar(&bwlimit, "bwlimit", "",
+		"limits the bandwidth in bytes per second, e.g. 1M or 2M:512K for read and write")
 
 	flag.Parse()
 	nArg := flag.NArg()
@@ -81,6 +115,14 @@ func Process() {
 
 	checkArgs(commands)
 	setLogLevel(verbose, verbose2)
+	if bwlimit != "" {
+		l, err := store.ParseBWLimit(bwlimit)
+		if err != nil {
+			color.Red("invalid bwlimit %s: %v", bwlimit, err)
+			os.Exit(1)
+		}
+		store.SetGlobalBWLimit(l.Read, l.Write)
+	}
 
 	switch commands[0] {
 	case "ls":
@@ -99,5 +141,31 @@ func Process() {
 		Shell()
 	case "mkdir":
 		Mkdir(commands[1:])
+	case "vault":
+		Vault(commands[1:])
+	case "audit":
+		Audit(commands[1:])
+	case "versions":
+		Versions(commands[1:])
+	case "restore":
+		Restore(commands[1:])
+	case "trash":
+		Trash(commands[1:])
+	case "scrub":
+		Scrub(commands[1:])
+	case "fsck":
+		Fsck(commands[1:])
+	case "verify":
+		Verify(commands[1:])
+	case "find":
+		Find(commands[1:])
+	case "search":
+		Search(commands[1:])
+	case "grep":
+		Grep(commands[1:])
+	case "dups":
+		Dups(commands[1:])
+	case "daemon":
+		Daemon(commands[1:])
 	}
 }
@@ -0,0 +1,37 @@
+package cli
+
+import (
+	"babybluefs/store"
+	"github.com/fatih/color"
+)
+
+// Scrub verifies the shards of an erasure coded store and re-creates the damaged ones
+func Scrub(args []string) {
+	if len(args) < 1 {
+		color.Green("missing target")
+		return
+	}
+
+	f, _, ph, err := GetFS(args[0])
+	if err != nil {
+		return
+	}
+	e, ok := store.Find[*store.ErasureCoded](f)
+	if !ok {
+		color.Red("%s is not erasure coded", args[0])
+		return
+	}
+
+	report, err := e.Scrub(ph)
+	if err != nil {
+		color.Red("cannot scrub %s: %v", args[0], err)
+		return
+	}
+	for _, n := range report.Repaired {
+		color.Green("repaired %s", n)
+	}
+	for n, err := range report.Failed {
+		color.Red("cannot repair %s: %v", n, err)
+	}
+	color.Green("%d files checked, %d repaired, %d failed", report.Checked, len(report.Repaired), len(report.Failed))
+}
@@ -0,0 +1,91 @@
+package cli
+
+import (
+	"babybluefs/index"
+	"babybluefs/store"
+	"flag"
+	"github.com/fatih/color"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// openTextIndex opens the full-text index of the store name, kept in the index folder of the home
+func openTextIndex(name string) (*index.FullText, error) {
+	return index.OpenFullText(store.NewLocalMount(filepath.Join(GetHome(), "index")), name)
+}
+
+// openFullText opens the full-text index of the store in target and builds it when it does not cover
+// the target yet or when refresh is set. It returns the index, the store, the prefix of the paths to show
+// and the folder
+func openFullText(target string, refresh bool) (*index.FullText, store.FS, string, string, bool) {
+	f, name, prefix, ph, err := indexTarget(target)
+	if err != nil {
+		return nil, nil, "", "", false
+	}
+	ft, err := openTextIndex(name)
+	if err != nil {
+		color.Red("cannot open the full-text index of %s: %v", target, err)
+		return nil, nil, "", "", false
+	}
+	if refresh || !ft.Covers(ph) {
+		if err = ft.Build(f, ph); err == nil {
+			err = ft.Save()
+		}
+		if err != nil {
+			color.Red("cannot index %s: %v", target, err)
+			return nil, nil, "", "", false
+		}
+	}
+	return ft, f, prefix, ph, true
+}
+
+// parseTextArgs reads the arguments of search and grep: the target, the refresh flag and the query
+func parseTextArgs(command, query string, args []string) (target string, rest []string, refresh bool, ok bool) {
+	fs := flag.NewFlagSet(command, flag.ContinueOnError)
+	fs.BoolVar(&refresh, "refresh", false, "walks the store to update the index")
+	if len(args) < 2 {
+		color.Green("usage: %s store[/path] [--refresh] %s", command, query)
+		return "", nil, false, false
+	}
+	if err := fs.Parse(args[1:]); err != nil || fs.NArg() == 0 {
+		return "", nil, false, false
+	}
+	return args[0], fs.Args(), refresh, true
+}
+
+// Search looks for the documents of a store that contain all the provided words
+func Search(args []string) {
+	target, words, refresh, ok := parseTextArgs("search", "words...", args)
+	if !ok {
+		return
+	}
+	ft, f, prefix, ph, ok := openFullText(target, refresh)
+	if !ok {
+		return
+	}
+	for _, h := range ft.Search(f, ph, strings.Join(words, " ")) {
+		color.Green("%s\t%s", path.Join(prefix, h.Path), h.Snippet)
+	}
+}
+
+// Grep shows the lines of the documents of a store that match a regular expression
+func Grep(args []string) {
+	target, exprs, refresh, ok := parseTextArgs("grep", "regexp", args)
+	if !ok {
+		return
+	}
+	re, err := regexp.Compile(strings.Join(exprs, " "))
+	if err != nil {
+		color.Red("invalid expression %s: %v", strings.Join(exprs, " "), err)
+		return
+	}
+	ft, f, prefix, ph, ok := openFullText(target, refresh)
+	if !ok {
+		return
+	}
+	for _, h := range ft.Grep(f, ph, re) {
+		color.Green("%s:%d\t%s", path.Join(prefix, h.Path), h.Line, h.Snippet)
+	}
+}
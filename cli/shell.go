@@ -43,6 +43,21 @@ var completer = readline.NewPrefixCompleter(
 	readline.PcItem("cat", readline.PcItemDynamic(completePath1)),
 	readline.PcItem("mkdir", readline.PcItemDynamic(completePath1)),
 	readline.PcItem("edit", readline.PcItemDynamic(completeStoreList)),
+	readline.PcItem("audit", readline.PcItem("verify", readline.PcItemDynamic(completeStoreList)),
+		readline.PcItem("show", readline.PcItemDynamic(completePath2))),
+	readline.PcItem("versions", readline.PcItemDynamic(completePath1)),
+	readline.PcItem("restore", readline.PcItemDynamic(completePath1)),
+	readline.PcItem("trash", readline.PcItem("ls", readline.PcItemDynamic(completeStoreList)),
+		readline.PcItem("restore", readline.PcItemDynamic(completeStoreList)),
+		readline.PcItem("empty", readline.PcItemDynamic(completeStoreList))),
+	readline.PcItem("scrub", readline.PcItemDynamic(completePath1)),
+	readline.PcItem("fsck", readline.PcItemDynamic(completePath1)),
+	readline.PcItem("verify", readline.PcItemDynamic(completePath1)),
+	readline.PcItem("find", readline.PcItemDynamic(completePath1)),
+	readline.PcItem("search", readline.PcItemDynamic(completePath1)),
+	readline.PcItem("grep", readline.PcItemDynamic(completePath1)),
+	readline.PcItem("dups", readline.PcItemDynamic(completePath1)),
+	readline.PcItem("vault", readline.PcItem("ls"), readline.PcItem("set"), readline.PcItem("rm")),
 )
 
 func shouldExit(line string, err error) bool {
@@ -63,7 +78,20 @@ func shellUsage() {
 			"\tcreate [s3|azure|sftp|ftp|sharepoint]   create a new store configuration\n" +
 			"\tedit store                              edit an existing store configuration\n" +
 			"\tmesh name [storage...]                  create a mesh with provided storage list\n" +
-			"\tsync mesh                               align all the storage points in the mesh\n")
+			"\tsync mesh                               align all the storage points in the mesh\n" +
+			"\taudit [verify|show] store[/path]        verify the audit log or show it, optionally from and to a time\n" +
+			"\tversions store/path                     list the versions of a file\n" +
+			"\trestore store/path@version              restore a version of a file\n" +
+			"\ttrash [ls|restore|empty] store [id|age] list, restore or empty the trash of a store\n" +
+			"\tscrub store                             repair the lost shards of an erasure coded store\n" +
+			"\tfsck store[/path] [repair]              check and repair the meta sidecars of a store\n" +
+			"\tverify store[/path] [--record]          check the files against their hashes and record the missing ones\n" +
+			"\tfind store[/path] [options]             query the index of a store, e.g. --name *.pdf --modified-by alice --larger 10M\n" +
+			"\tsearch store[/path] words...            search the text of the documents of a store\n" +
+			"\tgrep store[/path] regexp                show the lines of the documents that match regexp\n" +
+			"\t                                        find, search and grep see the changes of a store with --refresh\n" +
+			"\tdups [--action delete|link] store[/path]... report the duplicated files, optionally deleting or linking the extra copies\n" +
+			"\tvault [ls|set id [value]|rm id]         manage secrets referenced as vault:id\n")
 
 }
 
@@ -106,6 +134,30 @@ func Shell() {
 			Edit(args[1])
 		case "mkdir":
 			Mkdir(args[1:])
+		case "vault":
+			Vault(args[1:])
+		case "audit":
+			Audit(args[1:])
+		case "versions":
+			Versions(args[1:])
+		case "restore":
+			Restore(args[1:])
+		case "trash":
+			Trash(args[1:])
+		case "scrub":
+			Scrub(args[1:])
+		case "fsck":
+			Fsck(args[1:])
+		case "verify":
+			Verify(args[1:])
+		case "find":
+			Find(args[1:])
+		case "search":
+			Search(args[1:])
+		case "grep":
+			Grep(args[1:])
+		case "dups":
+			Dups(args[1:])
 		case "exit":
 			exit = true
 		default:
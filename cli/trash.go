@@ -0,0 +1,68 @@
+package cli
+
+import (
+	"babybluefs/store"
+	"github.com/fatih/color"
+	"time"
+)
+
+// Trash lists, restores and empties the trash of a store
+func Trash(args []string) {
+	if len(args) < 2 {
+		color.Green("usage: trash [ls store|restore store id [dest]|empty store [age]]")
+		return
+	}
+
+	f, _, _, err := GetFS(args[1])
+	if err != nil {
+		return
+	}
+	t, ok := store.Find[*store.Trash](f)
+	if !ok {
+		color.Red("trash is not enabled on %s", args[1])
+		return
+	}
+
+	switch args[0] {
+	case "ls":
+		items, err := t.ListTrash()
+		if err != nil {
+			color.Red("cannot list trash of %s: %v", args[1], err)
+			return
+		}
+		color.Green("Id\tDeleted\tBy\tSize\tOrigin\n")
+		for _, i := range items {
+			color.Green("%s\t%s\t%s\t%d\t%s\n", i.ID, i.Deleted.Local().Format(time.RFC3339), i.DeletedBy,
+				i.Size, i.Origin)
+		}
+	case "restore":
+		if len(args) < 3 {
+			color.Red("missing trash id")
+			return
+		}
+		var dest string
+		if len(args) > 3 {
+			dest = args[3]
+		}
+		if err = t.Restore(args[2], dest); err != nil {
+			color.Red("cannot restore %s: %v", args[2], err)
+			return
+		}
+		color.Green("%s restored", args[2])
+	case "empty":
+		var age time.Duration
+		if len(args) > 2 {
+			if age, err = time.ParseDuration(args[2]); err != nil {
+				color.Red("invalid age %s: %v", args[2], err)
+				return
+			}
+		}
+		cnt, err := t.Empty(age)
+		if err != nil {
+			color.Red("cannot empty trash of %s: %v", args[1], err)
+		}
+		color.Green("%d items deleted from trash", cnt)
+	default:
+		color.Red("unknown trash command %s", args[0])
+	}
+}
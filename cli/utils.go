@@ -41,6 +41,27 @@ func isLocalPath(ph string) bool {
 	return false
 }
 
+// readConfig loads the configuration of the store name and resolves its secrets
+func readConfig(name string) (store.Config, error) {
+	home := GetHome()
+	logrus.Infof("home is '%s'", home)
+
+	var c store.Config
+	l := store.NewLocalMount(home)
+	err := store.ReadYaml(l, fmt.Sprintf("%s.yaml", name), &c)
+	if err != nil {
+		color.Red("store '%s' not defined", name)
+		logrus.Infof("cannot load '%s' from '%s': %v", name, home, err)
+		return c, err
+	}
+
+	err = resolveSecrets(&c)
+	if err != nil {
+		color.Red("cannot resolve secrets for store '%s': %v", name, err)
+	}
+	return c, err
+}
+
 func GetFS(ph string) (f store.FS, name, ph2 string, err error) {
 	if isLocalPath(ph) {
 		dir, ph2 := filepath.Split(ph)
@@ -51,16 +72,9 @@ func GetFS(ph string) (f store.FS, name, ph2 string, err error) {
 
 	var found bool
 	if f, found = fsCache[name]; !found {
-		home := GetHome()
-		logrus.Infof("home is '%s'", home)
-
-		var c store.Config
-		l := store.NewLocalMount(home)
-		err := store.ReadYaml(l, fmt.Sprintf("%s.yaml", name), &c)
+		c, err := readConfig(name)
 		if err != nil {
-			color.Red("store '%s' not defined", name)
-			logrus.Infof("cannot load '%s' from '%s': %v", name, home, err)
-			return nil, "", "", err
+			return nil, name, "", err
 		}
 
 		f, err = store.NewFS(c)
@@ -0,0 +1,150 @@
+package cli
+
+import (
+	"babybluefs/store"
+	"bytes"
+	"errors"
+	"github.com/chzyer/readline"
+	"github.com/fatih/color"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const vaultFile = "vault.bin"
+
+var vault *store.Vault
+
+func getVaultKey() ([]byte, error) {
+	if keyFile, ok := os.LookupEnv("SF_VAULT_KEYFILE"); ok {
+		key, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		return bytes.TrimSpace(key), nil
+	}
+	if passphrase, ok := os.LookupEnv("SF_VAULT_PASSPHRASE"); ok {
+		return []byte(passphrase), nil
+	}
+	return readline.Password("vault passphrase: ")
+}
+
+// getVault opens the vault in the home folder. It returns nil when no vault has been created yet
+func getVault(create bool) (*store.Vault, error) {
+	if vault != nil {
+		return vault, nil
+	}
+
+	home := GetHome()
+	if _, err := os.Stat(filepath.Join(home, vaultFile)); err != nil && !create {
+		return nil, nil
+	}
+
+	key, err := getVaultKey()
+	if err != nil {
+		return nil, err
+	}
+	vault, err = store.OpenVault(store.NewLocalMount(home), vaultFile, key)
+	return vault, err
+}
+
+// resolveSecrets replaces vault: and env: references in a configuration.
+// The vault is opened only when the configuration refers to it
+func resolveSecrets(target interface{}) error {
+	err := store.ResolveSecrets(target, nil)
+	if !errors.Is(err, store.ErrVaultLocked) {
+		return err
+	}
+
+	v, err := getVault(false)
+	if err != nil {
+		return err
+	}
+	return store.ResolveSecrets(target, v)
+}
+
+// sealSecrets moves the plain credentials of the configuration c to the vault and writes c back to
+// file with vault references. The vault is opened only when c has plain credentials
+func sealSecrets(c *store.Config, file string) error {
+	if n, err := store.SealSecrets(c, nil, ""); err != nil || n == 0 {
+		return err
+	}
+
+	v, err := getVault(true)
+	if err != nil {
+		return err
+	}
+	prefix := strings.TrimSuffix(filepath.Base(file), ".yaml")
+	if _, err = store.SealSecrets(c, v, prefix); err != nil {
+		return err
+	}
+	if err = v.Save(store.NewLocalMount(GetHome()), vaultFile); err != nil {
+		return err
+	}
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, data, 0644)
+}
+
+func Vault(args []string) {
+	if len(args) == 0 {
+		color.Green("usage: vault [ls|set id [value]|rm id]")
+		return
+	}
+
+	v, err := getVault(args[0] == "set")
+	if err != nil {
+		color.Red("cannot open vault: %v", err)
+		return
+	}
+	if v == nil {
+		color.Green("vault is empty")
+		return
+	}
+
+	l := store.NewLocalMount(GetHome())
+	switch args[0] {
+	case "ls":
+		for _, id := range v.List() {
+			color.Green("%s%s", store.VaultPrefix, id)
+		}
+	case "set":
+		if len(args) < 2 {
+			color.Red("missing secret id")
+			return
+		}
+		var secret string
+		if len(args) > 2 {
+			secret = strings.Join(args[2:], " ")
+		} else {
+			s, err := readline.Password("secret: ")
+			if err != nil {
+				color.Red("cannot read secret: %v", err)
+				return
+			}
+			secret = string(s)
+		}
+		v.Set(args[1], secret)
+		if err = v.Save(l, vaultFile); err != nil {
+			color.Red("cannot save vault: %v", err)
+			return
+		}
+		color.Green("secret %s%s stored", store.VaultPrefix, args[1])
+	case "rm":
+		if len(args) < 2 {
+			color.Red("missing secret id")
+			return
+		}
+		v.Delete(args[1])
+		if err = v.Save(l, vaultFile); err != nil {
+			color.Red("cannot save vault: %v", err)
+			return
+		}
+		color.Green("secret %s removed", args[1])
+	default:
+		color.Red("unknown vault command %s", args[0])
+	}
+}
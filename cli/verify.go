@@ -0,0 +1,39 @@
+package cli
+
+import (
+	"babybluefs/store"
+	"flag"
+	"github.com/fatih/color"
+)
+
+// Verify reads the files of a store and compares them with their recorded hashes and backend checksums.
+// With --record the files without hashes get them
+func Verify(args []string) {
+	if len(args) < 1 {
+		color.Green("missing target")
+		return
+	}
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	record := fs.Bool("record", false, "records the hashes of the files without them")
+	if err := fs.Parse(args[1:]); err != nil {
+		return
+	}
+
+	f, _, ph, err := GetFS(args[0])
+	if err != nil {
+		return
+	}
+
+	report, err := store.Verify(f, ph, *record)
+	for _, n := range report.Corrupted {
+		color.Red("corrupted: %s", n)
+	}
+	for _, n := range report.Unhashed {
+		color.Green("no hash: %s", n)
+	}
+	if err != nil {
+		color.Red("cannot verify %s: %v", args[0], err)
+	}
+	color.Green("%d files verified, %d corrupted, %d without hash, %d recorded",
+		report.Verified, len(report.Corrupted), len(report.Unhashed), report.Recorded)
+}
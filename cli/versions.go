@@ -0,0 +1,76 @@
+package cli
+
+import (
+	"babybluefs/store"
+	"github.com/fatih/color"
+	"io"
+	"strings"
+	"time"
+)
+
+func getVersioned(target string) (store.FS, *store.Versioned, string, bool) {
+	f, _, ph, err := GetFS(target)
+	if err != nil {
+		return nil, nil, "", false
+	}
+	v, ok := store.Find[*store.Versioned](f)
+	if !ok {
+		color.Red("versioning is not enabled on %s", target)
+		return nil, nil, "", false
+	}
+	return f, v, ph, true
+}
+
+// Versions lists the prior contents of a file
+func Versions(args []string) {
+	if len(args) < 1 {
+		color.Green("missing target")
+		return
+	}
+
+	_, v, ph, ok := getVersioned(args[0])
+	if !ok {
+		return
+	}
+	versions, err := v.Versions(ph)
+	if err != nil {
+		color.Red("cannot list versions of %s: %v", args[0], err)
+		return
+	}
+
+	color.Green("Version\tTime\tSize\n")
+	for _, ver := range versions {
+		color.Green("%s\t%s\t%d\n", ver.ID, ver.Time.Local().Format(time.RFC3339), ver.Size)
+	}
+}
+
+// Restore replaces a file with one of its versions, specified as path@version
+func Restore(args []string) {
+	if len(args) < 1 {
+		color.Green("missing target")
+		return
+	}
+
+	idx := strings.LastIndex(args[0], "@")
+	if idx < 0 {
+		color.Red("missing version in %s: use path@version", args[0])
+		return
+	}
+	target, id := args[0][0:idx], args[0][idx+1:]
+	f, v, ph, ok := getVersioned(target)
+	if !ok {
+		return
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		_ = pw.CloseWithError(v.PullVersion(ph, id, pw))
+	}()
+	err := f.Push(ph, pr)
+	_ = pr.Close()
+	if err != nil {
+		color.Red("cannot restore %s to version %s: %v", target, id, err)
+		return
+	}
+	color.Green("%s restored to version %s", target, id)
+}
@@ -0,0 +1,152 @@
+package index
+
+import (
+	"archive/zip"
+	"babybluefs/store"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/gabriel-vasile/mimetype"
+	"golang.org/x/net/html"
+)
+
+// maxExtractSize is the largest file whose text is extracted
+const maxExtractSize = 16 << 20
+
+// officeParts are the entries of office documents that contain the text
+var officeParts = []string{
+	"word/document.xml", "xl/sharedStrings.xml", "ppt/slides/slide*.xml", "content.xml",
+}
+
+// isMime returns true when m or one of its parents is any of the provided MIME types
+func isMime(m *mimetype.MIME, mimes ...string) bool {
+	for ; m != nil; m = m.Parent() {
+		for _, t := range mimes {
+			if m.Is(t) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Extract returns the text of the file name for plain text, Markdown, HTML and office documents (docx,
+// xlsx, pptx and OpenDocument). It returns ok false for other types, which are not read beyond their head
+func Extract(f store.FS, name string) (text string, ok bool, err error) {
+	info, err := f.Stat(name)
+	if err != nil || info.Size() > maxExtractSize {
+		return "", false, err
+	}
+	if !isMime(store.Mime(f, name), "text/plain", "application/zip") {
+		return "", false, nil
+	}
+
+	data, err := store.ReadFile(f, name)
+	if err != nil {
+		return "", false, err
+	}
+	m := store.DetectMime(data)
+	switch {
+	case isMime(m, "text/html"):
+		return extractHTML(data), true, nil
+	case isMime(m, "text/plain"):
+		return string(data), true, nil
+	case isMime(m, "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+		"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+		"application/vnd.openxmlformats-officedocument.presentationml.presentation",
+		"application/vnd.oasis.opendocument.text", "application/vnd.oasis.opendocument.spreadsheet",
+		"application/vnd.oasis.opendocument.presentation"):
+		text, err := extractOffice(data)
+		return text, err == nil, err
+	default:
+		return "", false, nil
+	}
+}
+
+// extractHTML returns the text of an HTML document without scripts and styles
+func extractHTML(data []byte) string {
+	var sb strings.Builder
+	z := html.NewTokenizer(bytes.NewReader(data))
+	skip := false
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return sb.String()
+		case html.StartTagToken:
+			tag, _ := z.TagName()
+			skip = string(tag) == "script" || string(tag) == "style"
+		case html.EndTagToken:
+			skip = false
+			sb.WriteString("\n")
+		case html.TextToken:
+			if !skip {
+				sb.Write(z.Text())
+			}
+		}
+	}
+}
+
+// extractOffice returns the text of the XML parts of a zipped office document, one paragraph per line.
+// The parts together are read up to maxExtractSize, whatever size the archive claims, and the text
+// extracted until then is returned
+func extractOffice(data []byte) (string, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	left := int64(maxExtractSize)
+	for _, zf := range zr.File {
+		var matched bool
+		for _, p := range officeParts {
+			if ok, _ := path.Match(p, zf.Name); ok {
+				matched = true
+			}
+		}
+		if !matched {
+			continue
+		}
+		r, err := zf.Open()
+		if err != nil {
+			return "", err
+		}
+		lr := &io.LimitedReader{R: r, N: left}
+		err = extractXML(lr, &sb)
+		r.Close()
+		left = lr.N
+		if left <= 0 {
+			// the last part is cut, so its error is expected
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return sb.String(), nil
+}
+
+func extractXML(r io.Reader, sb *strings.Builder) error {
+	d := xml.NewDecoder(r)
+	for {
+		t, err := d.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch t := t.(type) {
+		case xml.CharData:
+			sb.Write(t)
+		case xml.EndElement:
+			// paragraphs in word and OpenDocument, shared strings in spreadsheets
+			if t.Name.Local == "p" || t.Name.Local == "si" {
+				sb.WriteString("\n")
+			}
+		}
+	}
+}
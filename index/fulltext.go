@@ -0,0 +1,383 @@
+package index
+
+import (
+	"babybluefs/store"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/sirupsen/logrus"
+)
+
+// snippetLen is the length of the text around a hit returned as snippet
+const snippetLen = 120
+
+// Doc is a document in the full-text index
+type Doc struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// Posting is a document that contains a term
+type Posting struct {
+	Path string `json:"path"`
+	// Count is the number of occurrences of the term
+	Count int `json:"count"`
+	// Offset is the position of the first occurrence in the text of the document
+	Offset int `json:"offset"`
+}
+
+// FullText is an inverted index of the text of the documents in a store. Only the postings are kept,
+// so snippets and grep read the matching documents from the store again
+type FullText struct {
+	Store   string    `json:"store"`
+	Updated time.Time `json:"updated"`
+	// Folders are the folders that have been built, so that the index can tell which queries it covers
+	Folders []string       `json:"folders,omitempty"`
+	Docs    map[string]Doc `json:"docs"`
+	// Postings lists the documents that contain each term, sorted by path
+	Postings map[string][]Posting `json:"postings"`
+
+	db   store.FS
+	lock sync.RWMutex
+}
+
+// Hit is a document that matches a search
+type Hit struct {
+	Path string
+	// Line is the line of the match for grep, starting from 1
+	Line    int
+	Score   int
+	Snippet string
+}
+
+func fullTextDbName(name string) string {
+	return fmt.Sprintf("%s.text.json", name)
+}
+
+// OpenFullText reads the full-text index of the store name from db. A new index is returned when it
+// does not exist
+func OpenFullText(db store.FS, name string) (*FullText, error) {
+	ft := &FullText{Store: name, Docs: map[string]Doc{}, Postings: map[string][]Posting{}, db: db}
+	err := store.ReadJSON(db, fullTextDbName(name), ft)
+	if os.IsNotExist(err) {
+		return ft, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the full-text index of %s: %w", name, err)
+	}
+	if ft.Docs == nil {
+		ft.Docs = map[string]Doc{}
+	}
+	if ft.Postings == nil {
+		ft.Postings = map[string][]Posting{}
+	}
+	return ft, nil
+}
+
+// Save writes the index to its db
+func (ft *FullText) Save() error {
+	ft.lock.RLock()
+	defer ft.lock.RUnlock()
+	return store.WriteJSON(ft.db, fullTextDbName(ft.Store), ft)
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// terms returns the distinct lower case words of text with at least two letters or digits
+func terms(text string) []string {
+	seen := map[string]bool{}
+	var ts []string
+	for _, t := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !isWordRune(r)
+	}) {
+		if len([]rune(t)) > 1 && !seen[t] {
+			seen[t] = true
+			ts = append(ts, t)
+		}
+	}
+	return ts
+}
+
+// scan returns the postings of the document name by term. Offsets are in the lower case text, so they
+// are zero when lowering the case changes the length of the text
+func scan(name, text string) map[string]Posting {
+	lower := strings.ToLower(text)
+	exact := len(lower) == len(text)
+	postings := map[string]Posting{}
+	start := -1
+	for i, r := range lower + " " {
+		switch {
+		case isWordRune(r) && start < 0:
+			start = i
+		case !isWordRune(r) && start >= 0:
+			if t := lower[start:i]; utf8.RuneCountInString(t) > 1 {
+				p, ok := postings[t]
+				if !ok {
+					p = Posting{Path: name}
+					if exact {
+						p.Offset = start
+					}
+				}
+				p.Count++
+				postings[t] = p
+			}
+			start = -1
+		}
+	}
+	return postings
+}
+
+// find returns the position of the document name in the postings ps
+func find(ps []Posting, name string) (int, bool) {
+	i := sort.Search(len(ps), func(i int) bool {
+		return ps[i].Path >= name
+	})
+	return i, i < len(ps) && ps[i].Path == name
+}
+
+// remove drops the document name from the postings. It must be called with the lock
+func (ft *FullText) remove(name string) {
+	if _, ok := ft.Docs[name]; !ok {
+		return
+	}
+	for t, ps := range ft.Postings {
+		i, ok := find(ps, name)
+		if !ok {
+			continue
+		}
+		if ps = append(ps[:i], ps[i+1:]...); len(ps) == 0 {
+			delete(ft.Postings, t)
+		} else {
+			ft.Postings[t] = ps
+		}
+	}
+	delete(ft.Docs, name)
+}
+
+// add puts the document name with its text in the postings. It must be called with the lock
+func (ft *FullText) add(name string, doc Doc, text string) {
+	ft.remove(name)
+	ft.Docs[name] = doc
+	for t, p := range scan(name, text) {
+		ps := ft.Postings[t]
+		i, _ := find(ps, name)
+		ps = append(ps, Posting{})
+		copy(ps[i+1:], ps[i:])
+		ps[i] = p
+		ft.Postings[t] = ps
+	}
+}
+
+// changed returns true when the file is not indexed with its current size and modification time
+func (ft *FullText) changed(name string, info fs.FileInfo) bool {
+	ft.lock.RLock()
+	defer ft.lock.RUnlock()
+	doc, ok := ft.Docs[name]
+	return !ok || doc.Size != info.Size() || !doc.ModTime.Equal(info.ModTime())
+}
+
+// index extracts and indexes the text of the file name. Files without text are dropped from the index
+func (ft *FullText) index(f store.FS, name string, info fs.FileInfo) error {
+	text, ok, err := Extract(f, name)
+	if err != nil {
+		return err
+	}
+	ft.lock.Lock()
+	defer ft.lock.Unlock()
+	if ok {
+		ft.add(name, Doc{Size: info.Size(), ModTime: info.ModTime()}, text)
+	} else {
+		ft.remove(name)
+	}
+	ft.Updated = time.Now()
+	return nil
+}
+
+// Covers returns true when folder has been built, alone or with a parent folder
+func (ft *FullText) Covers(folder string) bool {
+	ft.lock.RLock()
+	defer ft.lock.RUnlock()
+	return covers(ft.Folders, folder)
+}
+
+// Build walks the folder of f and indexes the new and changed documents. A document whose text cannot be
+// extracted is skipped with a warning and tried again on the next build
+func (ft *FullText) Build(f store.FS, folder string) error {
+	folder = strings.Trim(folder, "/")
+	files := map[string]fs.FileInfo{}
+	err := store.Walk(f, folder, 0, func(dir string, info fs.FileInfo) {
+		if !store.IsMeta(info.Name()) {
+			files[path.Join(dir, info.Name())] = info
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	ft.lock.Lock()
+	for name := range ft.Docs {
+		if _, ok := files[name]; !ok && inFolder(name, folder) {
+			ft.remove(name)
+		}
+	}
+	ft.Folders = addFolder(ft.Folders, folder)
+	ft.Updated = time.Now()
+	ft.lock.Unlock()
+
+	for name, info := range files {
+		if !ft.changed(name, info) {
+			continue
+		}
+		if err := ft.index(f, name, info); err != nil {
+			logrus.Warnf("cannot extract the text of %s on %s: %v", name, f, err)
+		}
+	}
+	return nil
+}
+
+// Update indexes name again after a change. A folder is built again
+func (ft *FullText) Update(f store.FS, name string) error {
+	name = strings.Trim(name, "/")
+	if store.IsMeta(path.Base(name)) {
+		return nil
+	}
+
+	info, err := f.Stat(name)
+	switch {
+	case os.IsNotExist(err):
+		ft.lock.Lock()
+		defer ft.lock.Unlock()
+		for n := range ft.Docs {
+			if inFolder(n, name) {
+				ft.remove(n)
+			}
+		}
+		return nil
+	case err != nil:
+		return err
+	case info.IsDir():
+		return ft.Build(f, name)
+	}
+	return ft.index(f, name, info)
+}
+
+// Follow updates the index with the names received from changes until the channel is closed. The index
+// is saved once the changes of the last followSaveDelay are applied, and when the channel is closed
+func (ft *FullText) Follow(f store.FS, changes <-chan string) {
+	follow(ft, f, changes)
+}
+
+// snippet returns the text around the position i on a single line
+func snippet(text string, i int) string {
+	start, end := i-snippetLen/3, i+snippetLen*2/3
+	if start < 0 {
+		start = 0
+	}
+	if end > len(text) {
+		end = len(text)
+	}
+	for start > 0 && !utf8.RuneStart(text[start]) {
+		start--
+	}
+	for end < len(text) && !utf8.RuneStart(text[end]) {
+		end++
+	}
+
+	s := strings.Join(strings.Fields(text[start:end]), " ")
+	if start > 0 {
+		s = "..." + s
+	}
+	if end < len(text) {
+		s = s + "..."
+	}
+	return s
+}
+
+// Search returns the documents in folder that contain all the words of query, the most relevant first.
+// The snippets are taken from the documents read again from f
+func (ft *FullText) Search(f store.FS, folder, query string) []Hit {
+	ts := terms(query)
+	if len(ts) == 0 {
+		return nil
+	}
+
+	ft.lock.RLock()
+	var hits []Hit
+	var offsets []int
+	for _, p := range ft.Postings[ts[0]] {
+		if !inFolder(p.Path, strings.Trim(folder, "/")) {
+			continue
+		}
+		score := p.Count
+		for _, t := range ts[1:] {
+			i, ok := find(ft.Postings[t], p.Path)
+			if !ok {
+				score = 0
+				break
+			}
+			score += ft.Postings[t][i].Count
+		}
+		if score > 0 {
+			hits = append(hits, Hit{Path: p.Path, Score: score})
+			offsets = append(offsets, p.Offset)
+		}
+	}
+	ft.lock.RUnlock()
+
+	for i := range hits {
+		if text, ok, err := Extract(f, hits[i].Path); err == nil && ok && offsets[i] < len(text) {
+			hits[i].Snippet = snippet(text, offsets[i])
+		}
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].Path < hits[j].Path
+	})
+	return hits
+}
+
+// Grep returns the lines of the documents in folder that match re, sorted by path and line. The text of
+// the indexed documents is extracted again from f
+func (ft *FullText) Grep(f store.FS, folder string, re *regexp.Regexp) []Hit {
+	var names []string
+	ft.lock.RLock()
+	for name := range ft.Docs {
+		if inFolder(name, strings.Trim(folder, "/")) {
+			names = append(names, name)
+		}
+	}
+	ft.lock.RUnlock()
+
+	var hits []Hit
+	for _, name := range names {
+		text, ok, err := Extract(f, name)
+		if err != nil || !ok {
+			continue
+		}
+		for n, line := range strings.Split(text, "\n") {
+			if loc := re.FindStringIndex(line); loc != nil {
+				hits = append(hits, Hit{Path: name, Line: n + 1, Score: 1, Snippet: snippet(line, loc[0])})
+			}
+		}
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Path != hits[j].Path {
+			return hits[i].Path < hits[j].Path
+		}
+		return hits[i].Line < hits[j].Line
+	})
+	return hits
+}
@@ -0,0 +1,94 @@
+package index
+
+import (
+	"archive/zip"
+	"babybluefs/store"
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func docx(t *testing.T, text string) []byte {
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	w, err := zw.Create("[Content_Types].xml")
+	assert.NoError(t, err)
+	_, _ = w.Write([]byte(`<?xml version="1.0"?><Types></Types>`))
+	w, err = zw.Create("word/document.xml")
+	assert.NoError(t, err)
+	_, _ = w.Write([]byte(`<?xml version="1.0"?><w:document xmlns:w="w"><w:body><w:p><w:r><w:t>` + text +
+		`</w:t></w:r></w:p></w:body></w:document>`))
+	assert.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func TestFullText(t *testing.T) {
+	f := store.NewMemory(nil, 0)
+	db := store.NewMemory(nil, 0)
+
+	assert.NoError(t, f.Push("notes/todo.md", bytes.NewReader([]byte("# Todo\nrenew the storage contract\ncall Bob"))))
+	assert.NoError(t, f.Push("web/index.html", bytes.NewReader([]byte(
+		"<html><head><script>var contract = 1</script></head><body><p>Storage pricing</p></body></html>"))))
+	assert.NoError(t, f.Push("office/report.docx", bytes.NewReader(docx(t, "The storage contract expires in May"))))
+	assert.NoError(t, f.Push("bin/data", bytes.NewReader([]byte{0, 1, 2, 3, 0xff, 0xfe, 0, 0})))
+	// a broken document does not stop the build
+	assert.NoError(t, f.Push("office/broken.docx", bytes.NewReader(docx(t, "a < b"))))
+	_, _, err := Extract(f, "office/broken.docx")
+	assert.Error(t, err)
+
+	ft, err := OpenFullText(db, "mem")
+	assert.NoError(t, err)
+	assert.NoError(t, ft.Build(f, "office"))
+	assert.False(t, ft.Covers(""))
+	assert.NoError(t, ft.Build(f, ""))
+	assert.True(t, ft.Covers("web"))
+	assert.NoError(t, ft.Save())
+	assert.Len(t, ft.Docs, 3)
+
+	ft, err = OpenFullText(db, "mem")
+	assert.NoError(t, err)
+	hits := ft.Search(f, "", "Storage CONTRACT")
+	assert.Len(t, hits, 2)
+	assert.Equal(t, "notes/todo.md", hits[0].Path)
+	assert.Contains(t, hits[0].Snippet, "renew the storage contract")
+	assert.Equal(t, "office/report.docx", hits[1].Path)
+	assert.Len(t, ft.Search(f, "web", "storage"), 1)
+	// scripts are not indexed
+	assert.Empty(t, ft.Search(f, "web", "contract"))
+
+	hits = ft.Grep(f, "", regexp.MustCompile(`(?i)bob`))
+	assert.Equal(t, []Hit{{Path: "notes/todo.md", Line: 3, Score: 1, Snippet: "call Bob"}}, hits)
+
+	assert.NoError(t, f.Remove("notes/todo.md"))
+	assert.NoError(t, ft.Update(f, "notes/todo.md"))
+	assert.Len(t, ft.Search(f, "", "contract"), 1)
+	assert.NotContains(t, ft.Postings, "bob")
+
+	// the index keeps the postings, not the text of the documents
+	data, err := store.ReadFile(db, "mem.text.json")
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "expires in May")
+	assert.Equal(t, []Posting{{Path: "office/report.docx", Count: 1, Offset: 21}}, ft.Postings["expires"])
+}
+
+func TestExtractOfficeLimit(t *testing.T) {
+	// many slides that expand well beyond the limit together
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	slide := []byte(`<?xml version="1.0"?><p:sld xmlns:p="p"><a:t xmlns:a="a">` + strings.Repeat("word ", 1<<18) + `</a:t></p:sld>`)
+	for i := 0; i < 40; i++ {
+		w, err := zw.Create(fmt.Sprintf("ppt/slides/slide%d.xml", i))
+		assert.NoError(t, err)
+		_, _ = w.Write(slide)
+	}
+	assert.NoError(t, zw.Close())
+	assert.Less(t, buf.Len(), maxExtractSize)
+
+	text, err := extractOffice(buf.Bytes())
+	assert.NoError(t, err)
+	assert.NotEmpty(t, text)
+	assert.LessOrEqual(t, len(text), maxExtractSize)
+}
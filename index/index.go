@@ -0,0 +1,265 @@
+package index
+
+import (
+	"babybluefs/store"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Entry describes an indexed file
+type Entry struct {
+	Path       string                     `json:"path"`
+	Size       int64                      `json:"size"`
+	ModTime    time.Time                  `json:"modTime"`
+	ModifiedBy string                     `json:"modifiedBy,omitempty"`
+	Group      store.Group                `json:"group,omitempty"`
+	Mime       string                     `json:"mime,omitempty"`
+	Hashes     map[string]string          `json:"hashes,omitempty"`
+	Meta       map[string]json.RawMessage `json:"meta,omitempty"`
+}
+
+// Index keeps the entries of the files of a store in a JSON file, so that they can be queried without
+// walking the store
+type Index struct {
+	Store   string    `json:"store"`
+	Updated time.Time `json:"updated"`
+	// Folders are the folders that have been built, so that the index can tell which queries it covers
+	Folders []string         `json:"folders,omitempty"`
+	Entries map[string]Entry `json:"entries"`
+
+	db   store.FS
+	lock sync.RWMutex
+}
+
+func dbName(name string) string {
+	return fmt.Sprintf("%s.json", name)
+}
+
+// Open reads the index of the store name from db. A new index is returned when it does not exist
+func Open(db store.FS, name string) (*Index, error) {
+	idx := &Index{Store: name, Entries: map[string]Entry{}, db: db}
+	err := store.ReadJSON(db, dbName(name), idx)
+	if os.IsNotExist(err) {
+		return idx, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the index of %s: %w", name, err)
+	}
+	if idx.Entries == nil {
+		idx.Entries = map[string]Entry{}
+	}
+	return idx, nil
+}
+
+// Save writes the index to its db
+func (idx *Index) Save() error {
+	idx.lock.RLock()
+	defer idx.lock.RUnlock()
+	return store.WriteJSON(idx.db, dbName(idx.Store), idx)
+}
+
+// newEntry reads the meta and, when the file is new or changed, the MIME type of the file name
+func newEntry(f store.FS, name string, info fs.FileInfo, old Entry, found bool) Entry {
+	e := Entry{
+		Path:    name,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	}
+	if found && old.Size == e.Size && old.ModTime.Equal(e.ModTime) {
+		e.Mime = old.Mime
+	} else if e.Size > 0 {
+		e.Mime = store.Mime(f, name).String()
+	}
+
+	meta, _ := store.ListMeta(f, name)
+	if len(meta) > 0 {
+		e.Meta = meta
+		var attr store.Attr
+		_ = store.GetMeta(f, name, &attr)
+		e.ModifiedBy = attr.ModifiedBy
+		e.Group = attr.Group
+		e.Hashes = attr.Hashes
+	}
+	return e
+}
+
+func inFolder(name, folder string) bool {
+	return folder == "" || folder == "." || name == folder || strings.HasPrefix(name, folder+"/")
+}
+
+// covers returns true when folder is inside one of the built folders
+func covers(folders []string, folder string) bool {
+	folder = strings.Trim(folder, "/")
+	for _, f := range folders {
+		if inFolder(folder, f) {
+			return true
+		}
+	}
+	return false
+}
+
+// addFolder adds folder to the built folders, dropping the ones inside it
+func addFolder(folders []string, folder string) []string {
+	if covers(folders, folder) {
+		return folders
+	}
+	kept := []string{folder}
+	for _, f := range folders {
+		if !inFolder(f, folder) {
+			kept = append(kept, f)
+		}
+	}
+	return kept
+}
+
+// Covers returns true when folder has been built, alone or with a parent folder
+func (idx *Index) Covers(folder string) bool {
+	idx.lock.RLock()
+	defer idx.lock.RUnlock()
+	return covers(idx.Folders, folder)
+}
+
+// Build walks the folder of f and brings its entries up to date. Files that are not changed since
+// the last build keep their MIME type, so only new and changed files are read
+func (idx *Index) Build(f store.FS, folder string) error {
+	folder = strings.Trim(folder, "/")
+	files := map[string]fs.FileInfo{}
+	err := store.Walk(f, folder, 0, func(dir string, info fs.FileInfo) {
+		if !store.IsMeta(info.Name()) {
+			files[path.Join(dir, info.Name())] = info
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	entries := map[string]Entry{}
+	idx.lock.RLock()
+	for name, info := range files {
+		old, found := idx.Entries[name]
+		entries[name] = newEntry(f, name, info, old, found)
+	}
+	idx.lock.RUnlock()
+
+	idx.lock.Lock()
+	defer idx.lock.Unlock()
+	for name := range idx.Entries {
+		if _, ok := files[name]; !ok && inFolder(name, folder) {
+			delete(idx.Entries, name)
+		}
+	}
+	for name, e := range entries {
+		idx.Entries[name] = e
+	}
+	idx.Folders = addFolder(idx.Folders, folder)
+	idx.Updated = time.Now()
+	return nil
+}
+
+// Update brings the entry of name up to date after a change. A folder is built again
+func (idx *Index) Update(f store.FS, name string) error {
+	name = strings.Trim(name, "/")
+	if store.IsMeta(path.Base(name)) {
+		return nil
+	}
+
+	info, err := f.Stat(name)
+	switch {
+	case os.IsNotExist(err):
+		idx.lock.Lock()
+		defer idx.lock.Unlock()
+		for n := range idx.Entries {
+			if inFolder(n, name) {
+				delete(idx.Entries, n)
+			}
+		}
+		return nil
+	case err != nil:
+		return err
+	case info.IsDir():
+		return idx.Build(f, name)
+	}
+
+	idx.lock.RLock()
+	old, found := idx.Entries[name]
+	idx.lock.RUnlock()
+	e := newEntry(f, name, info, old, found)
+
+	idx.lock.Lock()
+	defer idx.lock.Unlock()
+	idx.Entries[name] = e
+	idx.Updated = time.Now()
+	return nil
+}
+
+// updater is an index that is kept current with the changes of a store
+type updater interface {
+	Update(f store.FS, name string) error
+	Save() error
+}
+
+// followSaveDelay is how long follow collects changes before it saves the index
+var followSaveDelay = time.Second
+
+func follow(u updater, f store.FS, changes <-chan string) {
+	save := time.NewTimer(followSaveDelay)
+	save.Stop()
+	defer save.Stop()
+	dirty := false
+	for {
+		select {
+		case name, ok := <-changes:
+			if !ok {
+				if dirty {
+					if err := u.Save(); err != nil {
+						logrus.Warnf("cannot save the index of %s: %v", f, err)
+					}
+				}
+				return
+			}
+			if err := u.Update(f, name); err != nil {
+				logrus.Warnf("cannot index %s on %s: %v", name, f, err)
+				continue
+			}
+			if !dirty {
+				dirty = true
+				save.Reset(followSaveDelay)
+			}
+		case <-save.C:
+			dirty = false
+			if err := u.Save(); err != nil {
+				logrus.Warnf("cannot save the index of %s: %v", f, err)
+			}
+		}
+	}
+}
+
+// Follow updates the index with the names received from changes until the channel is closed. The index
+// is saved once the changes of the last followSaveDelay are applied, and when the channel is closed
+func (idx *Index) Follow(f store.FS, changes <-chan string) {
+	follow(idx, f, changes)
+}
+
+// SyncEvents converts the monitor messages of a mesh sync, in the form op,name,..., in the names
+// of the changed files. The returned channel is closed when mon is closed
+func SyncEvents(mon <-chan string) <-chan string {
+	changes := make(chan string)
+	go func() {
+		defer close(changes)
+		for m := range mon {
+			parts := strings.SplitN(m, ",", 3)
+			if len(parts) > 1 && parts[1] != "" {
+				changes <- parts[1]
+			}
+		}
+	}()
+	return changes
+}
@@ -0,0 +1,63 @@
+package index
+
+import (
+	"babybluefs/store"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestIndex(t *testing.T) {
+	f := store.NewMemory(nil, 0)
+	db := store.NewMemory(nil, 0)
+
+	assert.NoError(t, f.Push("docs/a.pdf", bytes.NewReader(append([]byte("%PDF-1.4\n"), make([]byte, 2000)...))))
+	assert.NoError(t, f.Push("docs/b.txt", bytes.NewReader([]byte("some text"))))
+	assert.NoError(t, f.Push("c.txt", bytes.NewReader([]byte("other text"))))
+	assert.NoError(t, store.SetMeta(f, "docs/b.txt", store.Attr{ModifiedBy: "alice", Group: "g"}))
+
+	idx, err := Open(db, "mem")
+	assert.NoError(t, err)
+	assert.NoError(t, idx.Build(f, "docs"))
+	assert.True(t, idx.Covers("docs"))
+	assert.False(t, idx.Covers(""))
+	assert.NoError(t, idx.Build(f, ""))
+	assert.True(t, idx.Covers(""))
+	assert.Equal(t, []string{""}, idx.Folders)
+	assert.NoError(t, idx.Save())
+	assert.Len(t, idx.Entries, 3)
+
+	idx, err = Open(db, "mem")
+	assert.NoError(t, err)
+	paths := func(es []Entry) []string {
+		var ps []string
+		for _, e := range es {
+			ps = append(ps, e.Path)
+		}
+		return ps
+	}
+	assert.Equal(t, []string{"docs/a.pdf"}, paths(idx.Find(Query{Name: "*.pdf"})))
+	assert.Equal(t, []string{"docs/a.pdf"}, paths(idx.Find(Query{Mime: "application/pdf"})))
+	assert.Equal(t, []string{"c.txt", "docs/b.txt"}, paths(idx.Find(Query{Mime: "text/"})))
+	assert.Equal(t, []string{"docs/b.txt"}, paths(idx.Find(Query{ModifiedBy: "alice", Folder: "docs"})))
+	assert.Equal(t, []string{"docs/a.pdf"}, paths(idx.Find(Query{Larger: 1000})))
+	assert.Equal(t, []string{"docs/b.txt"}, paths(idx.Find(Query{Meta: map[string]string{"bbfs.attr": "alice"}})))
+
+	changes := make(chan string)
+	done := make(chan bool)
+	go func() {
+		idx.Follow(f, SyncEvents(changes))
+		done <- true
+	}()
+	assert.NoError(t, f.Remove("docs/a.pdf"))
+	assert.NoError(t, f.Push("d.txt", bytes.NewReader(make([]byte, 5000))))
+	changes <- "delete,docs/a.pdf,bob,0"
+	changes <- "pull,d.txt,bob,0"
+	close(changes)
+	<-done
+	assert.Equal(t, []string{"d.txt"}, paths(idx.Find(Query{Larger: 1000})))
+	idx, err = Open(db, "mem")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"d.txt"}, paths(idx.Find(Query{Larger: 1000})))
+	assert.True(t, idx.Covers("docs"))
+}
@@ -0,0 +1,96 @@
+package index
+
+import (
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Query selects the entries that match all its non-zero fields
+type Query struct {
+	// Folder limits the query to a folder and its subfolders
+	Folder string
+	// Name is a glob pattern on the name of the file, e.g. *.pdf
+	Name       string
+	ModifiedBy string
+	Group      string
+	// Mime is a MIME type, e.g. application/pdf, or a prefix ending with /, e.g. image/
+	Mime string
+	// Larger and Smaller are the exclusive bounds of the size
+	Larger  int64
+	Smaller int64
+	// After and Before are the exclusive bounds of the modification time
+	After  time.Time
+	Before time.Time
+	// Hash is the hex encoded hash of the content with any recorded algorithm
+	Hash string
+	// Meta lists the keys that the meta of the file must have. With a value, the JSON of the key must
+	// contain it
+	Meta map[string]string
+}
+
+func (q Query) matches(e Entry) bool {
+	switch {
+	case q.Folder != "" && !inFolder(e.Path, strings.Trim(q.Folder, "/")):
+		return false
+	case q.ModifiedBy != "" && e.ModifiedBy != q.ModifiedBy:
+		return false
+	case q.Group != "" && string(e.Group) != q.Group:
+		return false
+	case q.Larger > 0 && e.Size <= q.Larger:
+		return false
+	case q.Smaller > 0 && e.Size >= q.Smaller:
+		return false
+	case !q.After.IsZero() && !e.ModTime.After(q.After):
+		return false
+	case !q.Before.IsZero() && !e.ModTime.Before(q.Before):
+		return false
+	}
+
+	if q.Name != "" {
+		if ok, _ := path.Match(q.Name, path.Base(e.Path)); !ok {
+			return false
+		}
+	}
+	if q.Mime != "" {
+		mime := strings.Split(e.Mime, ";")[0]
+		if strings.HasSuffix(q.Mime, "/") && !strings.HasPrefix(mime, q.Mime) ||
+			!strings.HasSuffix(q.Mime, "/") && mime != q.Mime {
+			return false
+		}
+	}
+	if q.Hash != "" {
+		found := false
+		for _, h := range e.Hashes {
+			found = found || strings.EqualFold(h, q.Hash)
+		}
+		if !found {
+			return false
+		}
+	}
+	for k, v := range q.Meta {
+		m, ok := e.Meta[k]
+		if !ok || v != "" && !strings.Contains(string(m), v) {
+			return false
+		}
+	}
+	return true
+}
+
+// Find returns the entries that match q sorted by path
+func (idx *Index) Find(q Query) []Entry {
+	idx.lock.RLock()
+	defer idx.lock.RUnlock()
+
+	var entries []Entry
+	for _, e := range idx.Entries {
+		if q.matches(e) {
+			entries = append(entries, e)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Path < entries[j].Path
+	})
+	return entries
+}
@@ -12,7 +12,7 @@ import (
 // Config defines a mesh built of multiple file storages and groups
 type Config struct {
 	Remotes []store.Config         `json:"remotes" yaml:"remotes"`
-	Groups  map[store.Group]string `json:"groups" yaml:"groups"`
+	Groups  map[store.Group]string `json:"groups" yaml:"groups" secret:"true"`
 }
 
 // FromFile reads a Mesh configuration from a local file and update the provided mesh m.
@@ -66,6 +66,7 @@ func FromConfig(c Config, m *Mesh, reconnect bool) error {
 		}
 
 		m.Remotes[name] = remote{
+			Name:  name,
 			F:     f,
 			Group: c.Group,
 		}
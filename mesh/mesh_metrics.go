@@ -0,0 +1,32 @@
+package mesh
+
+import (
+	"babybluefs/store"
+	"time"
+)
+
+const (
+	metricFiles        = "bbfs_mesh_files_total"
+	metricSyncDuration = "bbfs_mesh_sync_duration_seconds"
+	metricLastSuccess  = "bbfs_mesh_last_success_timestamp_seconds"
+	metricSyncErrors   = "bbfs_mesh_sync_errors_total"
+)
+
+// countFile records a file pushed, pulled, conflicted or deleted during the sync with a remote
+func countFile(r remote, action string) {
+	store.DefaultMetrics.Add(metricFiles, "Files transferred by the mesh sync", 1,
+		"remote", r.Name, "action", action)
+}
+
+// recordSync records the duration and the outcome of the sync with a remote
+func recordSync(r remote, start time.Time, err error) {
+	store.DefaultMetrics.Set(metricSyncDuration, "Duration of the last sync with a remote",
+		time.Since(start).Seconds(), "remote", r.Name)
+	if err == nil {
+		store.DefaultMetrics.Set(metricLastSuccess, "Time of the last successful sync with a remote",
+			float64(time.Now().Unix()), "remote", r.Name)
+	} else {
+		store.DefaultMetrics.Add(metricSyncErrors, "Syncs with a remote completed with errors", 1,
+			"remote", r.Name)
+	}
+}
@@ -8,6 +8,7 @@ import (
 )
 
 type remote struct {
+	Name  string
 	F     store.FS
 	Group store.Group
 }
@@ -32,15 +32,23 @@ func Sync(mesh *Mesh, folder string, ignoreOlderThan time.Time, mon chan string)
 	for n, remote := range remotes {
 		go func() {
 			tm := ignoreOlderThan
-			ec <- syncFolder(folder, local, remote, keys, now, tm, mon)
+			start := time.Now()
+			e := syncFolder(folder, local, remote, keys, now, tm, mon)
+			recordSync(remote, start, e)
+			ec <- e
 		}()
 		select {
 		case e := <-ec:
+			mesh.sync.Lock()
 			if e == nil {
-				mesh.sync.Lock()
 				lastSync[n] = now
-				mesh.sync.Unlock()
 			}
+			if h := store.Health(remote.F); h != nil {
+				mesh.RemotesState[n] = h.Error()
+			} else {
+				delete(mesh.RemotesState, n)
+			}
+			mesh.sync.Unlock()
 			err = multierror.Append(e)
 		case <-ctx.Done():
 			return context.Canceled
@@ -294,6 +302,7 @@ func pushFile(i item, local store.FS, remote remote, keys Keys, mon chan string)
 	var me *multierror.Error
 	if i.l == nil {
 		logrus.Infof("file %s removed from remote", i.name)
+		countFile(remote, "delete")
 		return deleteFile(remote.F, i.name, i.la.ModifiedBy, mon)
 	}
 	r := getEncryptedAccessToFile(remote, keys)
@@ -301,6 +310,7 @@ func pushFile(i item, local store.FS, remote remote, keys Keys, mon chan string)
 	me = multierror.Append(store.SetMeta(remote.F, i.name, i.la))
 
 	if me.Len() == 0 {
+		countFile(remote, "push")
 		if mon != nil {
 			logrus.Debugf("file %s pushed to remote", i.name)
 			mon <- fmt.Sprintf("push,%s,%s,%x", i.name, i.la.ModifiedBy, i.la.CRC64s[0])
@@ -313,6 +323,7 @@ func pullFile(i item, local store.FS, remote remote, conflict bool, keys Keys, m
 	var me *multierror.Error
 	if i.r == nil {
 		logrus.Infof("file %s removed from local", i.name)
+		countFile(remote, "delete")
 		return deleteFile(local, i.name, i.ra.ModifiedBy, mon)
 	}
 
@@ -332,6 +343,11 @@ func pullFile(i item, local store.FS, remote remote, conflict bool, keys Keys, m
 
 	if me.Len() == 0 {
 		logrus.Infof("file %s pulled from remote into %s", i.name, dest)
+		if conflict {
+			countFile(remote, "conflict")
+		} else {
+			countFile(remote, "pull")
+		}
 		if mon != nil {
 			if conflict {
 				mon <- fmt.Sprintf("conflict,%s,%s,%x", i.name, i.ra.ModifiedBy, i.ra.CRC64s[0])
@@ -356,7 +372,11 @@ func syncLocalFiles(dir string, local store.FS, now time.Time, localFiles []fs.F
 		n := path.Join(dir, l.Name())
 		_ = store.UpdateAttr(local, n, n, func(attr store.Attr) store.Attr {
 			if l.ModTime().After(attr.SyncTime) {
-				crc := store.CalculateCRC64(local, n)
+				// the crc64 recorded by Hashed on write saves reading the file again
+				crc, ok := attr.RecordedCRC64(l.ModTime())
+				if !ok {
+					crc = store.CalculateCRC64(local, n)
+				}
 				if len(attr.CRC64s) == 0 || crc != attr.CRC64s[0] {
 					if len(attr.CRC64s) > 16 {
 						attr.CRC64s = append([]uint64{crc}, attr.CRC64s[0:15]...)
@@ -1,6 +1,7 @@
 package store
 
 import (
+	"strconv"
 	"time"
 )
 
@@ -9,6 +10,24 @@ type Attr struct {
 	Group      Group     `json:"group"`
 	SyncTime   time.Time `json:"crcTime"`
 	CRC64s     []uint64  `json:"crc64s"`
+	// Hashes are the hex encoded hashes of the content by algorithm, recorded by Hashed
+	Hashes map[string]string `json:"hashes,omitempty"`
+	// HashTime is when the hashes were recorded. They do not match a file changed later
+	HashTime time.Time `json:"hashTime,omitempty"`
+}
+
+func init() {
+	RegisterMeta("bbfs.attr", Attr{})
+}
+
+// RecordedCRC64 returns the crc64 recorded by Hashed when it is still valid for a file changed at modTime
+func (a Attr) RecordedCRC64(modTime time.Time) (uint64, bool) {
+	sum, ok := a.Hashes[HashCRC64]
+	if !ok || a.HashTime.Before(modTime) {
+		return 0, false
+	}
+	crc, err := strconv.ParseUint(sum, 16, 64)
+	return crc, err == nil
 }
 
 func UpdateAttr(f FS, src string, dest string, update func(attr Attr) Attr) error {
@@ -0,0 +1,138 @@
+package store
+
+import (
+	"github.com/hashicorp/go-multierror"
+	"github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+// idleCheck is the idle time after which a session is checked before use
+const idleCheck = 10 * time.Second
+
+type pooledConn struct {
+	c        interface{}
+	lastUsed time.Time
+}
+
+// connPool keeps the sessions of connection oriented backends like FTP, SFTP and SMB.
+// Broken sessions are closed and replaced with new ones dialed with the original configuration
+type connPool struct {
+	name  string
+	dial  func() (interface{}, error)
+	alive func(c interface{}) error
+	close func(c interface{}) error
+	idle  chan pooledConn
+	slots chan struct{}
+	lock  sync.Mutex
+	open  map[interface{}]bool
+}
+
+func newConnPool(name string, size int, dial func() (interface{}, error),
+	alive func(c interface{}) error, close func(c interface{}) error) *connPool {
+	if size < 1 {
+		size = 1
+	}
+	return &connPool{
+		name:  name,
+		dial:  dial,
+		alive: alive,
+		close: close,
+		idle:  make(chan pooledConn, size),
+		slots: make(chan struct{}, size),
+		open:  map[interface{}]bool{},
+	}
+}
+
+// connect dials the first session so that configuration errors are reported by the constructor
+func (p *connPool) connect() error {
+	c, err := p.get()
+	if err != nil {
+		return err
+	}
+	p.put(c, nil)
+	return nil
+}
+
+func (p *connPool) discard(c interface{}) {
+	p.lock.Lock()
+	delete(p.open, c)
+	p.lock.Unlock()
+	_ = p.close(c)
+}
+
+// get waits for a free slot and returns an idle session or a new one
+func (p *connPool) get() (interface{}, error) {
+	p.slots <- struct{}{}
+
+	for {
+		select {
+		case pc := <-p.idle:
+			if time.Since(pc.lastUsed) < idleCheck {
+				return pc.c, nil
+			}
+			err := p.alive(pc.c)
+			if err == nil {
+				return pc.c, nil
+			}
+			logrus.Infof("session to %s is not alive, redial: %v", p.name, err)
+			p.discard(pc.c)
+		default:
+			c, err := p.dial()
+			if err != nil {
+				<-p.slots
+				return nil, err
+			}
+			p.lock.Lock()
+			p.open[c] = true
+			p.lock.Unlock()
+			return c, nil
+		}
+	}
+}
+
+// put returns a session to the pool. Sessions that failed with a connection error are closed
+func (p *connPool) put(c interface{}, err error) {
+	if err != nil && errorClass(err) != "" {
+		logrus.Infof("session to %s closed after error: %v", p.name, err)
+		p.discard(c)
+	} else {
+		p.idle <- pooledConn{c, time.Now()}
+	}
+	<-p.slots
+}
+
+// do runs op on a session. When retry is true and the session turns out to be broken,
+// op is executed again on a new session. Operations that consume a stream must not be retried
+func (p *connPool) do(op func(c interface{}) error, retry bool) error {
+	c, err := p.get()
+	if err != nil {
+		return err
+	}
+	err = op(c)
+	p.put(c, err)
+	if err == nil || !retry || errorClass(err) == "" {
+		return err
+	}
+
+	if c, err = p.get(); err != nil {
+		return err
+	}
+	err = op(c)
+	p.put(c, err)
+	return err
+}
+
+func (p *connPool) closeAll() error {
+	var me *multierror.Error
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for c := range p.open {
+		me = multierror.Append(me, p.close(c))
+	}
+	p.open = map[interface{}]bool{}
+	for len(p.idle) > 0 {
+		<-p.idle
+	}
+	return me.ErrorOrNil()
+}
@@ -0,0 +1,52 @@
+package store
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"syscall"
+	"testing"
+)
+
+type fakeConn struct {
+	id int
+}
+
+func TestConnPool(t *testing.T) {
+	dialed := 0
+	closed := 0
+	p := newConnPool("fake", 2,
+		func() (interface{}, error) {
+			dialed++
+			return &fakeConn{id: dialed}, nil
+		},
+		func(c interface{}) error {
+			return nil
+		},
+		func(c interface{}) error {
+			closed++
+			return nil
+		})
+	assert.NoError(t, p.connect())
+
+	var used []int
+	err := p.do(func(c interface{}) error {
+		fc := c.(*fakeConn)
+		used = append(used, fc.id)
+		if fc.id == 1 {
+			return &os.SyscallError{Syscall: "read", Err: syscall.ECONNRESET}
+		}
+		return nil
+	}, true)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2}, used)
+	assert.Equal(t, 1, closed)
+
+	err = p.do(func(c interface{}) error {
+		return os.ErrNotExist
+	}, true)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	assert.Equal(t, 2, dialed)
+
+	assert.NoError(t, p.closeAll())
+	assert.Equal(t, 2, closed)
+}
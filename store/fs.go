@@ -70,6 +70,17 @@ type Props struct {
 	Free int64
 	// Quota is the maximal possible amount of bytes
 	Quota int64
+	// Warning reports a condition that needs attention, e.g. a soft quota that is exceeded
+	Warning string
+}
+
+// retentionPeriod returns the retention of p, which is kept as the offset from the zero time. Zero means
+// no retention
+func retentionPeriod(p Props) time.Duration {
+	if p.Retention.IsZero() {
+		return 0
+	}
+	return p.Retention.Sub(time.Time{})
 }
 
 type simpleFileInfo struct {
@@ -102,3 +113,13 @@ func (f simpleFileInfo) IsDir() bool {
 func (f simpleFileInfo) Sys() interface{} {
 	return nil
 }
+
+// logicalFileInfo reports the size of the content as seen by a decorator instead of the stored size
+type logicalFileInfo struct {
+	fs.FileInfo
+	size int64
+}
+
+func (f logicalFileInfo) Size() int64 {
+	return f.size
+}
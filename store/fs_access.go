@@ -5,36 +5,176 @@ import (
 	"github.com/patrickmn/go-cache"
 	"io"
 	"io/fs"
-	"math"
 	"os"
 	"path"
+	"sort"
+	"strings"
 	"time"
 )
 
+// Permission is an operation that a principal can do on a path
+type Permission string
+
+const (
+	PermRead   Permission = "read"
+	PermWrite  Permission = "write"
+	PermDelete Permission = "delete"
+	PermList   Permission = "list"
+)
+
+// AccessRule grants or denies permissions on a path and, unless a deeper rule says otherwise, on everything
+// below it. Principals are user ids, group names prefixed by @, @owner for the members of the group of a file
+// and * for anybody
+type AccessRule struct {
+	Path       string       `json:"path" yaml:"path"`
+	Principals []string     `json:"principals" yaml:"principals"`
+	Allow      []Permission `json:"allow" yaml:"allow"`
+	Deny       []Permission `json:"deny" yaml:"deny"`
+}
+
+// Policy defines the groups and the access rules of a store
+type Policy struct {
+	// Groups lists the members of each group. Files created by a user belong to the first of its groups by name
+	Groups map[Group][]string `json:"groups" yaml:"groups"`
+	Rules  []AccessRule       `json:"rules" yaml:"rules"`
+}
+
+type AccessConfig struct {
+	// Store is where the policy file is located. Default is the store itself
+	Store *Config `json:"store,omitempty" yaml:"store,omitempty"`
+	// Policy is the name of the policy file. Files with yaml or yml extension are read as yaml, others as json
+	Policy string `json:"policy" yaml:"policy"`
+	// Principal is the user that accesses the store
+	Principal string `json:"principal" yaml:"principal"`
+}
+
+// LoadPolicy reads a policy file from f
+func LoadPolicy(f FS, name string) (*Policy, error) {
+	var p Policy
+	var err error
+	switch path.Ext(name) {
+	case ".yaml", ".yml":
+		err = ReadYaml(f, name, &p)
+	default:
+		err = ReadJSON(f, name, &p)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read policy %s from %s: %w", name, f, err)
+	}
+	return &p, nil
+}
+
+// GroupsOf returns the groups of principal sorted by name
+func (p *Policy) GroupsOf(principal string) []Group {
+	var groups []Group
+	for g, members := range p.Groups {
+		for _, m := range members {
+			if m == principal {
+				groups = append(groups, g)
+			}
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i] < groups[j]
+	})
+	return groups
+}
+
+func (p *Policy) isMember(principal string, group Group) bool {
+	for _, m := range p.Groups[group] {
+		if m == principal {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Policy) matches(principal string, owner Group, r AccessRule) bool {
+	for _, rp := range r.Principals {
+		switch {
+		case rp == "*" || rp == principal:
+			return true
+		case rp == "@owner":
+			if owner != "" && p.isMember(principal, owner) {
+				return true
+			}
+		case strings.HasPrefix(rp, "@"):
+			if p.isMember(principal, Group(rp[1:])) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func hasPermission(perms []Permission, perm Permission) bool {
+	for _, p := range perms {
+		if p == perm || p == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// Allowed returns true when principal has perm on name, whose files belong to owner. The rules on the
+// deepest path decide; among them, a deny wins over an allow. Without rules the access is denied
+func (p *Policy) Allowed(principal string, name string, owner Group, perm Permission) bool {
+	for dir := path.Clean("/" + name); ; dir = path.Dir(dir) {
+		var allowed, denied bool
+		for _, r := range p.Rules {
+			if path.Clean("/"+r.Path) != dir || !p.matches(principal, owner, r) {
+				continue
+			}
+			denied = denied || hasPermission(r.Deny, perm)
+			allowed = allowed || hasPermission(r.Allow, perm)
+		}
+		if denied || allowed {
+			return !denied
+		}
+		if dir == "/" {
+			return false
+		}
+	}
+}
+
+// Access enforces a policy for a principal. New files are tagged with the first group of the principal
 type Access struct {
 	F          FS
-	Groups     []Group
+	Policy     *Policy
+	Principal  string
 	GroupCache *cache.Cache
 }
 
-func NewAccess(f FS, groups []Group, cacheExpiration time.Duration) FS {
+func NewAccess(f FS, policy *Policy, principal string, cacheExpiration time.Duration) FS {
 	return &Access{
 		F:          f,
-		Groups:     groups,
+		Policy:     policy,
+		Principal:  principal,
 		GroupCache: cache.New(cacheExpiration, 2*cacheExpiration),
 	}
 }
 
-func (a Access) Props() Props {
-	return Props{
-		Quota:       math.MaxInt64,
-		Free:        math.MaxInt64,
-		MinFileSize: 0,
-		MaxFileSize: math.MaxInt64,
+func newAccessFromConfig(f FS, c AccessConfig) (FS, error) {
+	src := f
+	if c.Store != nil {
+		var err error
+		if src, err = NewFS(*c.Store); err != nil {
+			return nil, err
+		}
+		defer src.Close()
+	}
+	policy, err := LoadPolicy(src, c.Policy)
+	if err != nil {
+		return nil, err
 	}
+	return NewAccess(f, policy, c.Principal, time.Minute), nil
+}
+
+func (a *Access) Props() Props {
+	return a.F.Props()
 }
 
-func (a Access) GetGroup(name string) Group {
+func (a *Access) GetGroup(name string) Group {
 	group, ok := a.GroupCache.Get(name)
 	if !ok {
 		var m Attr
@@ -45,94 +185,174 @@ func (a Access) GetGroup(name string) Group {
 	return group.(Group)
 }
 
-func (a Access) isAccessible(name string) bool {
-	group := a.GetGroup(name)
-	for _, g := range a.Groups {
-		if g == group {
-			return true
-		}
+// check returns os.ErrPermission when the principal does not have perm on name.
+// Permissions on a meta file are the permissions on its file
+func (a *Access) check(name string, perm Permission) error {
+	if IsMeta(name) {
+		name = metaTarget(name)
 	}
-	return false
+	if !a.Policy.Allowed(a.Principal, name, a.GetGroup(name), perm) {
+		return fmt.Errorf("%s cannot %s %s: %w", a.Principal, perm, name, os.ErrPermission)
+	}
+	return nil
 }
 
-func (a Access) ReadDir(name string, opts ListOption) ([]fs.FileInfo, error) {
+func (a *Access) ReadDir(name string, opts ListOption) ([]fs.FileInfo, error) {
+	if err := a.check(name, PermList); err != nil {
+		return nil, err
+	}
 	ls, err := a.F.ReadDir(name, opts)
 	if err != nil {
 		return nil, err
 	}
 	var is []fs.FileInfo
 	for _, l := range ls {
-		if a.isAccessible(path.Join(name, l.Name())) {
+		perm := PermRead
+		if l.IsDir() {
+			perm = PermList
+		}
+		if a.check(path.Join(name, l.Name()), perm) == nil {
 			is = append(is, l)
 		}
 	}
 	return is, nil
 }
 
-func (a Access) Watch(name string) chan string {
-	if a.isAccessible(name) {
-		return a.F.Watch(name)
-	} else {
+func (a *Access) Watch(name string) chan string {
+	if a.check(name, PermRead) != nil {
 		return nil
 	}
+	return a.F.Watch(name)
 }
 
-func (a Access) Stat(name string) (fs.FileInfo, error) {
-	if a.isAccessible(name) {
-		return a.F.Stat(name)
-	} else {
-		return nil, os.ErrPermission
+func (a *Access) Stat(name string) (fs.FileInfo, error) {
+	if a.check(name, PermList) != nil {
+		if err := a.check(name, PermRead); err != nil {
+			return nil, err
+		}
 	}
+	return a.F.Stat(name)
 }
 
-func (a Access) Remove(name string) error {
-	if a.isAccessible(name) {
-		return a.Remove(name)
-	} else {
-		return os.ErrPermission
+func (a *Access) Remove(name string) error {
+	if err := a.check(name, PermDelete); err != nil {
+		return err
 	}
+	a.GroupCache.Delete(name)
+	return a.F.Remove(name)
 }
 
-func (a Access) Touch(name string) error {
-	if a.isAccessible(name) {
-		return a.F.Touch(name)
-	} else {
-		return os.ErrPermission
+func (a *Access) Touch(name string) error {
+	if err := a.check(name, PermWrite); err != nil {
+		return err
 	}
+	return a.F.Touch(name)
 }
 
-func (a Access) Rename(old, new string) error {
-	if a.isAccessible(old) {
-		return a.F.Rename(old, new)
-	} else {
-		return os.ErrPermission
+func (a *Access) Rename(old, new string) error {
+	if err := a.check(old, PermDelete); err != nil {
+		return err
+	}
+	if err := a.check(new, PermWrite); err != nil {
+		return err
 	}
+	a.GroupCache.Delete(old)
+	a.GroupCache.Delete(new)
+	return a.F.Rename(old, new)
 }
 
-func (a Access) MkdirAll(name string) error {
-	return a.F.MkdirAll(name)
+// MkdirAll tags the folders it creates with the first group of the principal, like Push does with files
+func (a *Access) MkdirAll(name string) error {
+	if err := a.check(name, PermWrite); err != nil {
+		return err
+	}
+	var created []string
+	for dir := path.Clean(name); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		if _, err := a.F.Stat(dir); !os.IsNotExist(err) {
+			break
+		}
+		created = append(created, dir)
+	}
+	if err := a.F.MkdirAll(name); err != nil {
+		return err
+	}
+
+	groups := a.Policy.GroupsOf(a.Principal)
+	if len(groups) == 0 {
+		return nil
+	}
+	for _, dir := range created {
+		a.GroupCache.Delete(dir)
+		err := UpdateAttr(a.F, dir, dir, func(attr Attr) Attr {
+			attr.Group = groups[0]
+			attr.ModifiedBy = a.Principal
+			return attr
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func (a Access) Pull(name string, w io.Writer) error {
-	if !a.isAccessible(name) {
-		return os.ErrPermission
+func (a *Access) Pull(name string, w io.Writer) error {
+	if err := a.check(name, PermRead); err != nil {
+		return err
 	}
 	return a.F.Pull(name, w)
 }
 
-func (a Access) Push(name string, r io.Reader) error {
-	_, err := a.F.Stat(name)
-	if err == nil && !a.isAccessible(name) {
-		return os.ErrPermission
+// Push tags new files with the group of the principal
+func (a *Access) Push(name string, r io.Reader) error {
+	if err := a.check(name, PermWrite); err != nil {
+		return err
+	}
+	_, statErr := a.F.Stat(name)
+	if err := a.F.Push(name, r); err != nil {
+		return err
+	}
+
+	groups := a.Policy.GroupsOf(a.Principal)
+	if !os.IsNotExist(statErr) || IsMeta(name) || len(groups) == 0 {
+		return nil
+	}
+	a.GroupCache.Delete(name)
+	return UpdateAttr(a.F, name, name, func(attr Attr) Attr {
+		attr.Group = groups[0]
+		attr.ModifiedBy = a.Principal
+		return attr
+	})
+}
+
+// ReadMeta reads the native meta of the inner store when the principal can read name
+func (a *Access) ReadMeta(name string) ([]byte, error) {
+	n, ok := nativeMeta(a.F)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	if err := a.check(name, PermRead); err != nil {
+		return nil, err
 	}
+	return n.ReadMeta(name)
+}
 
-	return a.F.Push(name, r)
+// WriteMeta writes the native meta of the inner store when the principal can write name
+func (a *Access) WriteMeta(name string, data []byte) error {
+	n, ok := nativeMeta(a.F)
+	if !ok {
+		return ErrNotSupported
+	}
+	if err := a.check(name, PermWrite); err != nil {
+		return err
+	}
+	a.GroupCache.Delete(name)
+	return n.WriteMeta(name, data)
 }
 
-func (a Access) Close() error {
+func (a *Access) Close() error {
 	return a.F.Close()
 }
 
-func (a Access) String() string {
-	return fmt.Sprintf("Access(%s)", a.F.String())
+func (a *Access) String() string {
+	return fmt.Sprintf("%s#access!%s", a.F, a.Principal)
 }
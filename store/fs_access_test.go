@@ -0,0 +1,70 @@
+package store
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAccess(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "stg/test/access")
+	_ = os.RemoveAll(dir)
+	_ = os.MkdirAll(dir, 0755)
+	l := NewLocalMount(dir)
+
+	policy := Policy{
+		Groups: map[Group][]string{"dev": {"alice", "bob"}, "ops": {"carol"}},
+		Rules: []AccessRule{
+			{Path: "/", Principals: []string{"*"}, Allow: []Permission{PermList}},
+			{Path: "docs", Principals: []string{"@dev"}, Allow: []Permission{PermRead, PermWrite, PermList}},
+			{Path: "docs/secret", Principals: []string{"bob"}, Deny: []Permission{PermRead}},
+			{Path: "shared", Principals: []string{"*"}, Allow: []Permission{PermWrite, PermList}},
+			{Path: "shared", Principals: []string{"@owner"}, Allow: []Permission{PermRead, PermDelete}},
+		},
+	}
+	assert.NoError(t, WriteYaml(l, "policy.yaml", policy))
+	p, err := LoadPolicy(l, "policy.yaml")
+	assert.NoError(t, err)
+
+	alice := NewAccess(l, p, "alice", time.Minute)
+	bob := NewAccess(l, p, "bob", time.Minute)
+	carol := NewAccess(l, p, "carol", time.Minute)
+
+	assert.NoError(t, alice.Push("docs/secret/plan.txt", bytes.NewBufferString("plan")))
+	assert.NoError(t, WriteFile(alice, "docs/readme.txt", []byte("readme")))
+	var attr Attr
+	assert.NoError(t, GetMeta(l, "docs/readme.txt", &attr))
+	assert.Equal(t, Group("dev"), attr.Group)
+
+	_, err = ReadFile(bob, "docs/readme.txt")
+	assert.NoError(t, err)
+	_, err = ReadFile(bob, "docs/secret/plan.txt")
+	assert.ErrorIs(t, err, os.ErrPermission)
+	assert.ErrorIs(t, carol.Push("docs/x.txt", bytes.NewBufferString("x")), os.ErrPermission)
+	assert.ErrorIs(t, carol.MkdirAll("docs/sub"), os.ErrPermission)
+	assert.NoError(t, alice.MkdirAll("docs/sub/deep"))
+	for _, dir := range []string{"docs/sub", "docs/sub/deep"} {
+		attr = Attr{}
+		assert.NoError(t, GetMeta(l, dir, &attr))
+		assert.Equal(t, Group("dev"), attr.Group, dir)
+	}
+	assert.ErrorIs(t, alice.Remove("docs/readme.txt"), os.ErrPermission)
+
+	ls, err := carol.ReadDir("", 0)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, ls)
+	// files that carol cannot read are not listed
+	ls, err = carol.ReadDir("docs", 0)
+	assert.NoError(t, err)
+	for _, fi := range ls {
+		assert.True(t, fi.IsDir())
+	}
+
+	assert.NoError(t, carol.Push("shared/report.txt", bytes.NewBufferString("report")))
+	_, err = ReadFile(alice, "shared/report.txt")
+	assert.ErrorIs(t, err, os.ErrPermission)
+	assert.NoError(t, carol.Remove("shared/report.txt"))
+}
@@ -0,0 +1,499 @@
+package store
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc64"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrAuditTampered = errors.New("audit log has been tampered")
+
+type AuditConfig struct {
+	// Log is the store that keeps the audit log. When nil the log is kept in the audited store
+	Log *Config `json:"log,omitempty" yaml:"log,omitempty"`
+	// Folder is the location of the log segments. Default is .audit
+	Folder string `json:"folder" yaml:"folder"`
+	// Actor is recorded for the changes that do not carry a ModifiedBy attribute
+	Actor string `json:"actor" yaml:"actor"`
+}
+
+// AuditRecord is an entry in the audit log. Hash covers the record and the hash of the previous
+// record, so that any change in the log breaks the chain
+type AuditRecord struct {
+	Time  time.Time `json:"time"`
+	Op    string    `json:"op"`
+	Path  string    `json:"path"`
+	Dest  string    `json:"dest,omitempty"`
+	Size  int64     `json:"size"`
+	CRC64 uint64    `json:"crc64,omitempty"`
+	Actor string    `json:"actor,omitempty"`
+	Error string    `json:"error,omitempty"`
+	Prev  string    `json:"prev"`
+	Hash  string    `json:"hash"`
+}
+
+// AuditFilter selects the records returned by ReadAudit. Empty fields match all the records
+type AuditFilter struct {
+	Path string
+	From time.Time
+	To   time.Time
+}
+
+const auditSegmentExt = ".log"
+
+func (r AuditRecord) digest() string {
+	r.Hash = ""
+	data, _ := json.Marshal(r)
+	h := sha256.Sum256(append([]byte(r.Prev), data...))
+	return hex.EncodeToString(h[:])
+}
+
+func (f AuditFilter) match(r AuditRecord) bool {
+	if f.Path != "" && !strings.HasPrefix(r.Path, f.Path) && !strings.HasPrefix(r.Dest, f.Path) {
+		return false
+	}
+	if !f.From.IsZero() && r.Time.Before(f.From) {
+		return false
+	}
+	return f.To.IsZero() || !r.Time.After(f.To)
+}
+
+// Appender is implemented by the file storages that add a line at the end of a file under an exclusive
+// lock, which is held also against other processes. Append calls line with the last line of the file, or
+// nil when the file is empty or does not exist, and appends the line it returns
+type Appender interface {
+	Append(name string, line func(last []byte) ([]byte, error)) error
+}
+
+// appender returns the backend of f when it can append and the decorators in between do not change the content
+func appender(f FS) (Appender, bool) {
+	for f != nil {
+		if a, ok := f.(Appender); ok {
+			return a, true
+		}
+		switch f.(type) {
+		case *Retry, *BWLimit, *Metrics, *MetaAware:
+			f = Unwrap(f)
+		default:
+			return nil, false
+		}
+	}
+	return nil, false
+}
+
+// auditLocks serializes the writers of the segments kept on file storages that cannot append, by store and segment
+var auditLocks sync.Map
+
+// Audit appends a record to a hash-chained log for every write, rename and delete.
+// The log is split in daily segments, which are only appended. When the log is kept in the audited store,
+// the changes to the log folder are refused
+type Audit struct {
+	F      FS
+	Log    FS
+	Config AuditConfig
+	shared bool
+}
+
+// OpenAuditLog returns the store and the folder that keep the audit log of the store configured in c
+func OpenAuditLog(c Config) (FS, string, error) {
+	if c.Audit == nil {
+		return nil, "", fmt.Errorf("audit is not enabled in %s", c.Name)
+	}
+	folder := c.Audit.Folder
+	if folder == "" {
+		folder = ".audit"
+	}
+	if c.Audit.Log != nil {
+		f, err := NewFS(*c.Audit.Log)
+		return f, folder, err
+	}
+	c.Audit = nil
+	f, err := NewFS(c)
+	return f, folder, err
+}
+
+// NewAudit records the changes on f in a log kept in the folder of the store log.
+// When log is nil, the log is kept in f and hidden from the listings
+func NewAudit(f FS, log FS, config AuditConfig) (FS, error) {
+	if config.Folder == "" {
+		config.Folder = ".audit"
+	}
+	a := &Audit{
+		F:      f,
+		Log:    log,
+		Config: config,
+	}
+	if log == nil {
+		a.Log, a.shared = f, true
+	}
+	if _, err := auditSegments(a.Log, config.Folder); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func auditSegments(log FS, folder string) ([]string, error) {
+	ls, err := log.ReadDir(folder, IncludeHiddenFiles)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var segments []string
+	for _, l := range ls {
+		if !l.IsDir() && strings.HasSuffix(l.Name(), auditSegmentExt) {
+			segments = append(segments, l.Name())
+		}
+	}
+	sort.Strings(segments)
+	return segments, nil
+}
+
+func readSegment(log FS, name string) ([]AuditRecord, error) {
+	data, err := ReadFile(log, name)
+	if err != nil {
+		return nil, err
+	}
+	var records []AuditRecord
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		var r AuditRecord
+		if err := json.Unmarshal(line, &r); err != nil {
+			return nil, fmt.Errorf("%w: invalid record in %s: %v", ErrAuditTampered, name, err)
+		}
+		records = append(records, r)
+	}
+	return records, nil
+}
+
+// lastLine returns the last line of the size bytes of r, reading from the end
+func lastLine(r io.ReaderAt, size int64) ([]byte, error) {
+	var tail []byte
+	for off := size; off > 0; {
+		n := int64(4096)
+		if n > off {
+			n = off
+		}
+		off -= n
+		chunk := make([]byte, n)
+		if _, err := r.ReadAt(chunk, off); err != nil && err != io.EOF {
+			return nil, err
+		}
+		tail = append(chunk, tail...)
+		trimmed := bytes.TrimRight(tail, "\n")
+		if i := bytes.LastIndexByte(trimmed, '\n'); i >= 0 {
+			return trimmed[i+1:], nil
+		}
+		if off == 0 {
+			return trimmed, nil
+		}
+	}
+	return nil, nil
+}
+
+// prevHash returns the hash of the record before a new one in the segment day, whose last line is last
+func (a *Audit) prevHash(day string, last []byte) (string, error) {
+	if len(last) > 0 {
+		var r AuditRecord
+		if err := json.Unmarshal(last, &r); err != nil {
+			return "", fmt.Errorf("%w: invalid record in %s: %v", ErrAuditTampered, day, err)
+		}
+		return r.Hash, nil
+	}
+
+	// the chain continues from the last record of the previous segment
+	segments, err := auditSegments(a.Log, a.Config.Folder)
+	if err != nil {
+		return "", err
+	}
+	for i := len(segments) - 1; i >= 0; i-- {
+		if segments[i] >= day+auditSegmentExt {
+			continue
+		}
+		records, err := readSegment(a.Log, path.Join(a.Config.Folder, segments[i]))
+		if err != nil {
+			return "", err
+		}
+		if len(records) > 0 {
+			return records[len(records)-1].Hash, nil
+		}
+	}
+	return "", nil
+}
+
+// append adds r at the end of the segment of the day. The previous record is read while the segment is
+// locked, so that concurrent writers keep a single chain
+func (a *Audit) append(r AuditRecord) error {
+	if r.Actor == "" {
+		r.Actor = a.Config.Actor
+	}
+	r.Time = time.Now().UTC()
+	day := r.Time.Format("2006-01-02")
+	name := path.Join(a.Config.Folder, day+auditSegmentExt)
+	line := func(last []byte) ([]byte, error) {
+		prev, err := a.prevHash(day, last)
+		if err != nil {
+			return nil, err
+		}
+		r.Prev = prev
+		r.Hash = r.digest()
+		return json.Marshal(r)
+	}
+
+	if ap, ok := appender(a.Log); ok {
+		return ap.Append(name, line)
+	}
+
+	// the segment is written again, so writers are serialized at least in this process
+	l, _ := auditLocks.LoadOrStore(a.Log.String()+"/"+name, &sync.Mutex{})
+	l.(*sync.Mutex).Lock()
+	defer l.(*sync.Mutex).Unlock()
+	data, err := ReadFile(a.Log, name)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	last, err := lastLine(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return err
+	}
+	next, err := line(last)
+	if err != nil {
+		return err
+	}
+	return WriteFile(a.Log, name, append(append(data, next...), '\n'))
+}
+
+func (a *Audit) record(r AuditRecord, err error) error {
+	if IsMeta(r.Path) {
+		return err
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	if e := a.append(r); e != nil {
+		return fmt.Errorf("cannot write audit log: %w", e)
+	}
+	return err
+}
+
+func (a *Audit) isLogArea(name string) bool {
+	if !a.shared {
+		return false
+	}
+	name = path.Clean(name)
+	return name == a.Config.Folder || strings.HasPrefix(name, a.Config.Folder+"/")
+}
+
+// protect refuses the changes to the log folder. The attempts are recorded
+func (a *Audit) protect(op string, names ...string) error {
+	for _, name := range names {
+		if a.isLogArea(name) {
+			err := fmt.Errorf("cannot %s %s in the audit log: %w", op, name, os.ErrPermission)
+			return a.record(AuditRecord{Op: op, Path: name}, err)
+		}
+	}
+	return nil
+}
+
+// recordMeta adds a record for a change to the meta of name, with the actor found in the new meta
+func (a *Audit) recordMeta(name string, data []byte, err error) error {
+	r := AuditRecord{Op: "meta", Path: name}
+	if doc, e := decodeMeta(data, name); e == nil {
+		key, _ := metaKey(Attr{})
+		var attr Attr
+		if v, ok := doc.Meta[key]; ok && json.Unmarshal(v, &attr) == nil {
+			r.Actor = attr.ModifiedBy
+		}
+	}
+	return a.record(r, err)
+}
+
+// ReadAudit returns the records of the log in folder that match the filter
+func ReadAudit(log FS, folder string, filter AuditFilter) ([]AuditRecord, error) {
+	segments, err := auditSegments(log, folder)
+	if err != nil {
+		return nil, err
+	}
+	var records []AuditRecord
+	for _, s := range segments {
+		if !filter.From.IsZero() && s < filter.From.UTC().Format("2006-01-02") {
+			continue
+		}
+		if !filter.To.IsZero() && s > filter.To.UTC().Format("2006-01-02")+auditSegmentExt {
+			continue
+		}
+		rs, err := readSegment(log, path.Join(folder, s))
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range rs {
+			if filter.match(r) {
+				records = append(records, r)
+			}
+		}
+	}
+	return records, nil
+}
+
+// VerifyAudit checks the hash chain of the log in folder and returns the number of valid records
+func VerifyAudit(log FS, folder string) (int, error) {
+	segments, err := auditSegments(log, folder)
+	if err != nil {
+		return 0, err
+	}
+	var prev string
+	var cnt int
+	for _, s := range segments {
+		rs, err := readSegment(log, path.Join(folder, s))
+		if err != nil {
+			return cnt, err
+		}
+		for i, r := range rs {
+			if r.Prev != prev {
+				return cnt, fmt.Errorf("%w: record %d in %s does not follow the previous one", ErrAuditTampered, i+1, s)
+			}
+			if r.digest() != r.Hash {
+				return cnt, fmt.Errorf("%w: record %d in %s has been modified", ErrAuditTampered, i+1, s)
+			}
+			prev = r.Hash
+			cnt++
+		}
+	}
+	return cnt, nil
+}
+
+func (a *Audit) Props() Props {
+	return a.F.Props()
+}
+
+func (a *Audit) ReadDir(name string, opts ListOption) ([]fs.FileInfo, error) {
+	ls, err := a.F.ReadDir(name, opts)
+	if err != nil || !a.shared {
+		return ls, err
+	}
+
+	var fis []fs.FileInfo
+	for _, l := range ls {
+		if !a.isLogArea(path.Join(name, l.Name())) {
+			fis = append(fis, l)
+		}
+	}
+	return fis, nil
+}
+
+func (a *Audit) Stat(name string) (fs.FileInfo, error) {
+	return a.F.Stat(name)
+}
+
+func (a *Audit) Remove(name string) error {
+	if err := a.protect("remove", name); err != nil {
+		return err
+	}
+	var size int64
+	if l, err := a.F.Stat(name); err == nil {
+		size = l.Size()
+	}
+	err := a.F.Remove(name)
+	return a.record(AuditRecord{Op: "remove", Path: name, Size: size}, err)
+}
+
+func (a *Audit) Touch(name string) error {
+	if err := a.protect("touch", name); err != nil {
+		return err
+	}
+	err := a.F.Touch(name)
+	return a.record(AuditRecord{Op: "touch", Path: name}, err)
+}
+
+func (a *Audit) Watch(name string) chan string {
+	return a.F.Watch(name)
+}
+
+func (a *Audit) Rename(old, new string) error {
+	if err := a.protect("rename", old, new); err != nil {
+		return err
+	}
+	err := a.F.Rename(old, new)
+	return a.record(AuditRecord{Op: "rename", Path: old, Dest: new}, err)
+}
+
+func (a *Audit) MkdirAll(name string) error {
+	if err := a.protect("mkdir", name); err != nil {
+		return err
+	}
+	err := a.F.MkdirAll(name)
+	return a.record(AuditRecord{Op: "mkdir", Path: name}, err)
+}
+
+func (a *Audit) Pull(name string, w io.Writer) error {
+	return a.F.Pull(name, w)
+}
+
+func (a *Audit) Push(name string, r io.Reader) error {
+	if err := a.protect("push", name); err != nil {
+		return err
+	}
+	if IsMeta(name) {
+		// the meta carries the actor of the change, e.g. the ModifiedBy set after a push
+		buf := &bytes.Buffer{}
+		err := a.F.Push(name, io.TeeReader(r, buf))
+		return a.recordMeta(metaTarget(name), buf.Bytes(), err)
+	}
+
+	h := crc64.New(crc64.MakeTable(crc64.ECMA))
+	cr := &CountingReader{R: io.TeeReader(r, h)}
+	err := a.F.Push(name, cr)
+	return a.record(AuditRecord{Op: "push", Path: name, Size: cr.Cnt, CRC64: h.Sum64()}, err)
+}
+
+// ReadMeta reads the native meta of the inner store, when available
+func (a *Audit) ReadMeta(name string) ([]byte, error) {
+	n, ok := nativeMeta(a.F)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	return n.ReadMeta(name)
+}
+
+// WriteMeta writes the native meta of the inner store and records the change like a push of the sidecar
+func (a *Audit) WriteMeta(name string, data []byte) error {
+	n, ok := nativeMeta(a.F)
+	if !ok {
+		return ErrNotSupported
+	}
+	if err := a.protect("meta", name); err != nil {
+		return err
+	}
+	err := n.WriteMeta(name, data)
+	if errors.Is(err, ErrNotSupported) {
+		// the meta goes to the sidecar, whose push is recorded
+		return err
+	}
+	return a.recordMeta(name, data, err)
+}
+
+func (a *Audit) Close() error {
+	if !a.shared {
+		_ = a.Log.Close()
+	}
+	return a.F.Close()
+}
+
+func (a *Audit) String() string {
+	return fmt.Sprintf("%s#audit", a.F)
+}
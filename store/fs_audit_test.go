@@ -0,0 +1,87 @@
+package store
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestAudit(t *testing.T) {
+	log := NewMemory(nil, 0)
+	a, err := NewAudit(NewLocalMount(os.TempDir()), log, AuditConfig{Actor: "tester"})
+	assert.NoError(t, err)
+
+	name := "stg/test/audit.txt"
+	assert.NoError(t, a.Push(name, bytes.NewBufferString("audited")))
+	assert.NoError(t, a.Rename(name, name+".bak"))
+	assert.NoError(t, a.Remove(name+".bak"))
+
+	cnt, err := VerifyAudit(log, ".audit")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, cnt)
+
+	records, err := ReadAudit(log, ".audit", AuditFilter{Path: name, From: time.Now().Add(-time.Hour)})
+	assert.NoError(t, err)
+	assert.Len(t, records, 3)
+	assert.Equal(t, "push", records[0].Op)
+	assert.Equal(t, int64(7), records[0].Size)
+	assert.Equal(t, "tester", records[0].Actor)
+
+	segment := path.Join(".audit", time.Now().UTC().Format("2006-01-02")+auditSegmentExt)
+	data, _ := ReadFile(log, segment)
+	data = bytes.Replace(data, []byte(`"op":"remove"`), []byte(`"op":"touch"`), 1)
+	assert.NoError(t, WriteFile(log, segment, data))
+	_, err = VerifyAudit(log, ".audit")
+	assert.ErrorIs(t, err, ErrAuditTampered)
+}
+
+func TestAuditShared(t *testing.T) {
+	l := NewLocalMount(os.TempDir())
+	folder := "stg/test/.audit"
+	_ = os.RemoveAll(path.Join(os.TempDir(), folder))
+
+	// two writers on the same log keep a single chain
+	a1, err := NewAudit(l, nil, AuditConfig{Folder: folder, Actor: "first"})
+	assert.NoError(t, err)
+	a2, err := NewAudit(l, nil, AuditConfig{Folder: folder, Actor: "second"})
+	assert.NoError(t, err)
+
+	name := "stg/test/audit-shared.txt"
+	assert.NoError(t, l.Push(name, bytes.NewBufferString("shared")))
+	done := make(chan bool)
+	for _, a := range []FS{a1, a2} {
+		go func(a FS) {
+			for i := 0; i < 10; i++ {
+				assert.NoError(t, a.Touch(name))
+			}
+			done <- true
+		}(a)
+	}
+	<-done
+	<-done
+	cnt, err := VerifyAudit(l, folder)
+	assert.NoError(t, err)
+	assert.Equal(t, 20, cnt)
+
+	assert.NoError(t, SetMeta(a1, name, Attr{ModifiedBy: "mesh"}))
+	records, err := ReadAudit(l, folder, AuditFilter{Path: name})
+	assert.NoError(t, err)
+	if assert.NotEmpty(t, records) {
+		assert.Equal(t, "meta", records[len(records)-1].Op)
+		assert.Equal(t, "mesh", records[len(records)-1].Actor)
+	}
+
+	segment := path.Join(folder, time.Now().UTC().Format("2006-01-02")+auditSegmentExt)
+	assert.ErrorIs(t, a1.Push(segment, bytes.NewBufferString("forged")), os.ErrPermission)
+	assert.ErrorIs(t, a1.Remove(segment), os.ErrPermission)
+	cnt, err = VerifyAudit(l, folder)
+	assert.NoError(t, err)
+	assert.Equal(t, 23, cnt)
+
+	_ = RemoveMeta(l, name)
+	_ = l.Remove(name)
+	_ = os.RemoveAll(path.Join(os.TempDir(), folder))
+}
@@ -3,6 +3,9 @@ package store
 import (
 	"bytes"
 	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"github.com/Azure/azure-pipeline-go/pipeline"
 	"github.com/Azure/azure-storage-file-go/azfile"
@@ -10,6 +13,7 @@ import (
 	"io/fs"
 	"math"
 	"net/url"
+	"os"
 	"path"
 	"strings"
 )
@@ -17,7 +21,7 @@ import (
 type AzureConfig struct {
 	Addr        string `json:"addr" yaml:"addr"`
 	AccountName string `json:"accountName" yaml:"accountName"`
-	AccountKey  string `json:"accountKey" yaml:"accountKey"`
+	AccountKey  string `json:"accountKey" yaml:"accountKey" secret:"true"`
 	Share       string `json:"share" yaml:"share"`
 }
 
@@ -124,7 +128,14 @@ func (az *AzureFS) Push(name string, r io.Reader) error {
 	if err != nil {
 		return err
 	}
-	_, err = fileURL.Create(ctx, azfile.FileMaxSizeInBytes, azfile.FileHTTPHeaders{}, azfile.Metadata{})
+	// the meta survives an overwrite, as it does with a sidecar
+	metadata := azfile.Metadata{}
+	if props, err := fileURL.GetProperties(ctx); err == nil {
+		if v, ok := props.NewMetadata()[azureMetaKey]; ok {
+			metadata[azureMetaKey] = v
+		}
+	}
+	_, err = fileURL.Create(ctx, azfile.FileMaxSizeInBytes, azfile.FileHTTPHeaders{}, metadata)
 	if err != nil {
 		return err
 	}
@@ -132,6 +143,7 @@ func (az *AzureFS) Push(name string, r io.Reader) error {
 	var offset int64
 	var n int
 	buf := make([]byte, 16000)
+	h := md5.New()
 	for err != io.EOF {
 		n, err = r.Read(buf)
 		if err != nil && err != io.EOF {
@@ -144,11 +156,16 @@ func (az *AzureFS) Push(name string, r io.Reader) error {
 				_, _ = fileURL.Resize(ctx, 0)
 				return err
 			}
+			h.Write(buf[0:n])
 			offset += int64(n)
 		}
 	}
 
-	_, err = fileURL.Resize(ctx, offset)
+	if _, err = fileURL.Resize(ctx, offset); err != nil {
+		return err
+	}
+	// the Content-MD5 is not computed by the service for files uploaded by range
+	_, err = fileURL.SetHTTPHeaders(ctx, azfile.FileHTTPHeaders{ContentMD5: h.Sum(nil)})
 	return err
 }
 
@@ -247,7 +264,12 @@ func (az *AzureFS) Rename(old, new string) error {
 		return err
 	}
 
-	_, err = newUrl.StartCopy(ctx, oldUrl.URL(), azfile.Metadata{})
+	// the metadata, and so the meta, moves with the file
+	metadata := azfile.Metadata{}
+	if props, err := oldUrl.GetProperties(ctx); err == nil {
+		metadata = props.NewMetadata()
+	}
+	_, err = newUrl.StartCopy(ctx, oldUrl.URL(), metadata)
 	if err != nil {
 		return err
 	}
@@ -255,6 +277,70 @@ func (az *AzureFS) Rename(old, new string) error {
 	return err
 }
 
+// azureMetaKey is the file metadata that keeps the meta, encoded in base64
+const azureMetaKey = "bbfsmeta"
+
+// azureMetaLimit is the space available for the meta in the 8KB of metadata of a file
+const azureMetaLimit = 7000
+
+// ReadMeta reads the meta from the metadata of the file
+func (az *AzureFS) ReadMeta(name string) ([]byte, error) {
+	fileURL, err := az.getFileUrl(name)
+	if err != nil {
+		return nil, err
+	}
+	props, err := fileURL.GetProperties(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	v, ok := props.NewMetadata()[azureMetaKey]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return base64.StdEncoding.DecodeString(v)
+}
+
+// WriteMeta sets the meta in the metadata of the file, preserving the other entries
+func (az *AzureFS) WriteMeta(name string, data []byte) error {
+	v := base64.StdEncoding.EncodeToString(data)
+	if len(v) > azureMetaLimit {
+		return ErrNotSupported
+	}
+	fileURL, err := az.getFileUrl(name)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	props, err := fileURL.GetProperties(ctx)
+	if err != nil {
+		return err
+	}
+	metadata := props.NewMetadata()
+	if data == nil {
+		delete(metadata, azureMetaKey)
+	} else {
+		metadata[azureMetaKey] = v
+	}
+	_, err = fileURL.SetMetadata(ctx, metadata)
+	return err
+}
+
+// Checksum returns the Content-MD5 of the file
+func (az *AzureFS) Checksum(name string) (string, string, error) {
+	fileURL, err := az.getFileUrl(name)
+	if err != nil {
+		return "", "", err
+	}
+	props, err := fileURL.GetProperties(context.Background())
+	if err != nil {
+		return "", "", err
+	}
+	if len(props.ContentMD5()) == 0 {
+		return "", "", ErrNotSupported
+	}
+	return HashMD5, hex.EncodeToString(props.ContentMD5()), nil
+}
+
 func (az *AzureFS) Close() error {
 	return nil
 }
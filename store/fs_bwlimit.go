@@ -0,0 +1,281 @@
+package store
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BWSchedule sets different limits in a time window of the day, e.g. from 08:00 to 18:00
+type BWSchedule struct {
+	From  string `json:"from" yaml:"from"`
+	To    string `json:"to" yaml:"to"`
+	Read  int64  `json:"read" yaml:"read"`
+	Write int64  `json:"write" yaml:"write"`
+}
+
+type BWLimitConfig struct {
+	// Read is the maximal read rate in bytes per second. Zero means no limit
+	Read int64 `json:"read" yaml:"read"`
+	// Write is the maximal write rate in bytes per second. Zero means no limit
+	Write int64 `json:"write" yaml:"write"`
+	// Schedule overrides Read and Write in some hours of the day
+	Schedule []BWSchedule `json:"schedule" yaml:"schedule"`
+}
+
+// TokenBucket limits a flow of bytes to a rate in bytes per second
+type TokenBucket struct {
+	lock   sync.Mutex
+	rate   int64
+	tokens float64
+	last   time.Time
+}
+
+func NewTokenBucket(rate int64) *TokenBucket {
+	return &TokenBucket{rate: rate, last: time.Now()}
+}
+
+// SetRate changes the rate of the bucket. Zero removes the limit
+func (b *TokenBucket) SetRate(rate int64) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.rate != rate {
+		b.rate = rate
+		b.tokens = 0
+		b.last = time.Now()
+	}
+}
+
+func (b *TokenBucket) Rate() int64 {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.rate
+}
+
+// Wait blocks until n bytes can flow
+func (b *TokenBucket) Wait(n int) {
+	b.lock.Lock()
+	if b.rate <= 0 {
+		b.lock.Unlock()
+		return
+	}
+
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * float64(b.rate)
+	// the burst is at most one second of traffic
+	if b.tokens > float64(b.rate) {
+		b.tokens = float64(b.rate)
+	}
+	b.last = now
+	b.tokens -= float64(n)
+	var d time.Duration
+	if b.tokens < 0 {
+		d = time.Duration(-b.tokens / float64(b.rate) * float64(time.Second))
+	}
+	b.lock.Unlock()
+
+	time.Sleep(d)
+}
+
+// GlobalReadLimit and GlobalWriteLimit are shared by all BWLimit file storages
+var GlobalReadLimit = NewTokenBucket(0)
+var GlobalWriteLimit = NewTokenBucket(0)
+
+// SetGlobalBWLimit limits the total traffic of all the file storages with a BWLimit decorator
+func SetGlobalBWLimit(read, write int64) {
+	GlobalReadLimit.SetRate(read)
+	GlobalWriteLimit.SetRate(write)
+}
+
+// ParseSize converts a size like 512, 10K, 1.5M or 2G in bytes
+func ParseSize(s string) (int64, error) {
+	s = strings.TrimSpace(strings.ToUpper(s))
+	s = strings.TrimSuffix(s, "B")
+	mul := 1.0
+	if s != "" {
+		switch s[len(s)-1] {
+		case 'K':
+			mul = 1 << 10
+		case 'M':
+			mul = 1 << 20
+		case 'G':
+			mul = 1 << 30
+		case 'T':
+			mul = 1 << 40
+		}
+		if mul > 1 {
+			s = s[0 : len(s)-1]
+		}
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size '%s'", s)
+	}
+	return int64(v * mul), nil
+}
+
+// ParseBWLimit reads a limit in the form rate or read:write, e.g. 1M or 2M:512K
+func ParseBWLimit(s string) (BWLimitConfig, error) {
+	parts := strings.SplitN(s, ":", 2)
+	read, err := ParseSize(parts[0])
+	if err != nil {
+		return BWLimitConfig{}, err
+	}
+	write := read
+	if len(parts) == 2 {
+		if write, err = ParseSize(parts[1]); err != nil {
+			return BWLimitConfig{}, err
+		}
+	}
+	return BWLimitConfig{Read: read, Write: write}, nil
+}
+
+func parseClock(s string) (time.Duration, error) {
+	tm, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(tm.Hour())*time.Hour + time.Duration(tm.Minute())*time.Minute, nil
+}
+
+// rates returns the limits active at the time now
+func (c BWLimitConfig) rates(now time.Time) (read, write int64) {
+	clock := time.Duration(now.Hour())*time.Hour + time.Duration(now.Minute())*time.Minute
+	for _, s := range c.Schedule {
+		from, err := parseClock(s.From)
+		if err != nil {
+			continue
+		}
+		to, err := parseClock(s.To)
+		if err != nil {
+			continue
+		}
+		if from <= to && clock >= from && clock < to || from > to && (clock >= from || clock < to) {
+			return s.Read, s.Write
+		}
+	}
+	return c.Read, c.Write
+}
+
+// BWLimit limits the bandwidth used by Pull and Push. The traffic waits for both its own buckets and the
+// global ones, so the stricter limit wins
+type BWLimit struct {
+	F      FS
+	Config BWLimitConfig
+	read   *TokenBucket
+	write  *TokenBucket
+}
+
+func NewBWLimit(f FS, config BWLimitConfig) FS {
+	return &BWLimit{
+		F:      f,
+		Config: config,
+		read:   NewTokenBucket(config.Read),
+		write:  NewTokenBucket(config.Write),
+	}
+}
+
+// bwPipeChunk is the largest piece of data moved before waiting for the buckets
+const bwPipeChunk = 32 * 1024
+
+type bwPipe struct {
+	R       io.Reader
+	W       io.Writer
+	Buckets []*TokenBucket
+}
+
+func (b bwPipe) wait(n int) {
+	for _, bucket := range b.Buckets {
+		bucket.Wait(n)
+	}
+}
+
+func (b bwPipe) Write(p []byte) (n int, err error) {
+	for len(p) > 0 && err == nil {
+		c := len(p)
+		if c > bwPipeChunk {
+			c = bwPipeChunk
+		}
+		b.wait(c)
+		var w int
+		w, err = b.W.Write(p[0:c])
+		n += w
+		p = p[w:]
+	}
+	return
+}
+
+func (b bwPipe) Read(p []byte) (n int, err error) {
+	if len(p) > bwPipeChunk {
+		p = p[0:bwPipeChunk]
+	}
+	n, err = b.R.Read(p)
+	b.wait(n)
+	return
+}
+
+func (b *BWLimit) updateRates() {
+	read, write := b.Config.rates(time.Now())
+	b.read.SetRate(read)
+	b.write.SetRate(write)
+}
+
+func (b *BWLimit) Props() Props {
+	return b.F.Props()
+}
+
+func (b *BWLimit) ReadDir(name string, opts ListOption) ([]fs.FileInfo, error) {
+	return b.F.ReadDir(name, opts)
+}
+
+func (b *BWLimit) Stat(name string) (fs.FileInfo, error) {
+	return b.F.Stat(name)
+}
+
+func (b *BWLimit) Remove(name string) error {
+	return b.F.Remove(name)
+}
+
+func (b *BWLimit) Touch(name string) error {
+	return b.F.Touch(name)
+}
+
+func (b *BWLimit) Watch(name string) chan string {
+	return b.F.Watch(name)
+}
+
+func (b *BWLimit) Rename(old, new string) error {
+	return b.F.Rename(old, new)
+}
+
+func (b *BWLimit) MkdirAll(name string) error {
+	return b.F.MkdirAll(name)
+}
+
+func (b *BWLimit) Pull(name string, w io.Writer) error {
+	b.updateRates()
+	return b.F.Pull(name, bwPipe{
+		W:       w,
+		Buckets: []*TokenBucket{b.read, GlobalReadLimit},
+	})
+}
+
+func (b *BWLimit) Push(name string, r io.Reader) error {
+	b.updateRates()
+	return b.F.Push(name, bwPipe{
+		R:       r,
+		Buckets: []*TokenBucket{b.write, GlobalWriteLimit},
+	})
+}
+
+func (b *BWLimit) Close() error {
+	return b.F.Close()
+}
+
+func (b *BWLimit) String() string {
+	return fmt.Sprintf("%s#bwlimit", b.F)
+}
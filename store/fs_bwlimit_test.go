@@ -0,0 +1,38 @@
+package store
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestBWLimit(t *testing.T) {
+	l, err := ParseBWLimit("64K:32k")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(64*1024), l.Read)
+	assert.Equal(t, int64(32*1024), l.Write)
+
+	c := BWLimitConfig{Read: 1, Schedule: []BWSchedule{{From: "22:00", To: "06:00", Read: 2}}}
+	r, _ := c.rates(time.Date(2022, 1, 1, 23, 0, 0, 0, time.UTC))
+	assert.Equal(t, int64(2), r)
+	r, _ = c.rates(time.Date(2022, 1, 1, 12, 0, 0, 0, time.UTC))
+	assert.Equal(t, int64(1), r)
+
+	f := NewBWLimit(NewLocalMount(os.TempDir()), BWLimitConfig{Write: 100 * 1024})
+	name := "stg/test/bwlimit.bin"
+	start := time.Now()
+	assert.NoError(t, f.Push(name, bytes.NewReader(make([]byte, 150*1024))))
+	assert.True(t, time.Since(start) > 400*time.Millisecond)
+
+	SetGlobalBWLimit(0, 100*1024)
+	defer SetGlobalBWLimit(0, 0)
+	f, err = decorate(NewLocalMount(os.TempDir()), Config{BWLimit: &BWLimitConfig{Write: 10 << 20}})
+	assert.NoError(t, err)
+	start = time.Now()
+	assert.NoError(t, f.Push(name, bytes.NewReader(make([]byte, 150*1024))))
+	assert.True(t, time.Since(start) > 400*time.Millisecond)
+
+	_ = f.Remove(name)
+}
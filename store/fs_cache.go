@@ -0,0 +1,629 @@
+package store
+
+import (
+	"container/list"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/patrickmn/go-cache"
+	"github.com/sirupsen/logrus"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type CacheConfig struct {
+	// Dir is the local folder for cached content. The default is in the user cache folder
+	Dir string `json:"dir" yaml:"dir"`
+	// MaxBytes is the budget for cached content. Least recently used files are evicted first
+	MaxBytes int64 `json:"maxBytes" yaml:"maxBytes"`
+	// TTL is how long results of ReadDir and Stat are reused
+	TTL time.Duration `json:"ttl" yaml:"ttl"`
+	// WriteBack returns from Push as soon as the content is in the cache and uploads it in background
+	WriteBack bool `json:"writeBack" yaml:"writeBack"`
+	// RetryPeriod is the delay before a failed background upload is tried again
+	RetryPeriod time.Duration `json:"retryPeriod" yaml:"retryPeriod"`
+}
+
+const (
+	cacheIndexFile = "index.json"
+	cacheQueueFile = "queue.json"
+	cacheDataDir   = "data"
+)
+
+type cacheEntry struct {
+	Name    string    `json:"name"`
+	File    string    `json:"file"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	Pending bool      `json:"pending"`
+}
+
+// Cache keeps a local copy of content and listings of a slow file storage
+type Cache struct {
+	F       FS
+	Config  CacheConfig
+	meta    *cache.Cache
+	lock    sync.Mutex
+	lru     *list.List
+	entries map[string]*list.Element
+	used    int64
+	queue   []string
+	// flushing has an entry for each file being uploaded, which is closed at the end of the upload
+	flushing map[string]chan struct{}
+	wake     chan struct{}
+	done     chan struct{}
+	// stopped is closed when the background upload has ended
+	stopped chan struct{}
+	closed  bool
+}
+
+func NewCache(f FS, config CacheConfig) (FS, error) {
+	if config.Dir == "" {
+		dir, err := os.UserCacheDir()
+		if err != nil {
+			return nil, err
+		}
+		h := sha1.Sum([]byte(f.String()))
+		config.Dir = filepath.Join(dir, "babybluefs", hex.EncodeToString(h[:8]))
+	}
+	if config.MaxBytes == 0 {
+		config.MaxBytes = 1 << 30
+	}
+	if config.TTL == 0 {
+		config.TTL = time.Minute
+	}
+	if config.RetryPeriod == 0 {
+		config.RetryPeriod = 30 * time.Second
+	}
+	if err := os.MkdirAll(filepath.Join(config.Dir, cacheDataDir), 0700); err != nil {
+		return nil, err
+	}
+
+	c := &Cache{
+		F:        f,
+		Config:   config,
+		meta:     cache.New(config.TTL, 2*config.TTL),
+		lru:      list.New(),
+		entries:  map[string]*list.Element{},
+		flushing: map[string]chan struct{}{},
+		wake:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	c.load()
+
+	if ch := f.Watch(""); ch != nil {
+		go func() {
+			for {
+				select {
+				case name, ok := <-ch:
+					if !ok {
+						return
+					}
+					c.Invalidate(name)
+				case <-c.done:
+					return
+				}
+			}
+		}()
+	}
+	go c.upload()
+	return c, nil
+}
+
+func (c *Cache) dataPath(file string) string {
+	return filepath.Join(c.Config.Dir, cacheDataDir, file)
+}
+
+// load restores the index and the upload queue saved by a previous session. Files still pending in
+// the index are queued again, since the session may have ended before the queue was saved
+func (c *Cache) load() {
+	var entries []cacheEntry
+	data, err := os.ReadFile(filepath.Join(c.Config.Dir, cacheIndexFile))
+	if err == nil {
+		_ = json.Unmarshal(data, &entries)
+	}
+	data, err = os.ReadFile(filepath.Join(c.Config.Dir, cacheQueueFile))
+	if err == nil {
+		_ = json.Unmarshal(data, &c.queue)
+	}
+
+	queued := map[string]bool{}
+	for _, n := range c.queue {
+		queued[n] = true
+	}
+	known := map[string]bool{}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ModTime.After(entries[j].ModTime)
+	})
+	for _, e := range entries {
+		if _, err := os.Stat(c.dataPath(e.File)); err == nil {
+			e := e
+			e.Pending = e.Pending || queued[e.Name]
+			c.entries[e.Name] = c.lru.PushBack(&e)
+			c.used += e.Size
+			known[e.File] = true
+			if e.Pending && !queued[e.Name] {
+				c.queue = append(c.queue, e.Name)
+				queued[e.Name] = true
+			}
+		}
+	}
+
+	var queue []string
+	for _, n := range c.queue {
+		if _, ok := c.entries[n]; ok {
+			queue = append(queue, n)
+		} else {
+			logrus.Warnf("content of %s waiting for upload to %s is missing in the cache", n, c.F)
+		}
+	}
+	c.queue = queue
+	_ = c.saveQueue()
+
+	// temporary files belong to transfers that did not complete. Other unknown files are kept, since
+	// they may hold content that was not uploaded yet
+	ls, _ := os.ReadDir(filepath.Join(c.Config.Dir, cacheDataDir))
+	for _, l := range ls {
+		switch {
+		case known[l.Name()]:
+		case strings.HasPrefix(l.Name(), "pull-") || strings.HasPrefix(l.Name(), "push-"):
+			_ = os.Remove(c.dataPath(l.Name()))
+		default:
+			logrus.Warnf("unknown file %s kept in cache %s", l.Name(), c.Config.Dir)
+		}
+	}
+}
+
+func (c *Cache) saveIndex() error {
+	var entries []cacheEntry
+	for e := c.lru.Front(); e != nil; e = e.Next() {
+		entries = append(entries, *e.Value.(*cacheEntry))
+	}
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(c.Config.Dir, cacheIndexFile), data, 0600)
+}
+
+func (c *Cache) saveQueue() error {
+	data, err := json.Marshal(c.queue)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(c.Config.Dir, cacheQueueFile), data, 0600)
+}
+
+// add registers a file already written in the data folder. The lock must be held
+func (c *Cache) add(e *cacheEntry) {
+	c.drop(e.Name)
+	c.entries[e.Name] = c.lru.PushFront(e)
+	c.used += e.Size
+	c.evict()
+}
+
+// drop forgets a cached file and deletes its content. The lock must be held
+func (c *Cache) drop(name string) {
+	if el, ok := c.entries[name]; ok {
+		e := el.Value.(*cacheEntry)
+		_ = os.Remove(c.dataPath(e.File))
+		c.used -= e.Size
+		c.lru.Remove(el)
+		delete(c.entries, name)
+	}
+}
+
+// evict removes least recently used files until the budget is respected. The lock must be held
+func (c *Cache) evict() {
+	for el := c.lru.Back(); el != nil && c.used > c.Config.MaxBytes; {
+		prev := el.Prev()
+		if e := el.Value.(*cacheEntry); !e.Pending {
+			c.drop(e.Name)
+		}
+		el = prev
+	}
+}
+
+func (c *Cache) lookup(name string) *cacheEntry {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if el, ok := c.entries[name]; ok {
+		c.lru.MoveToFront(el)
+		e := *el.Value.(*cacheEntry)
+		return &e
+	}
+	return nil
+}
+
+func (c *Cache) invalidateMeta(name string) {
+	c.meta.Delete("stat:" + name)
+	dir := path.Dir(name)
+	if dir == "." {
+		dir = ""
+	}
+	c.meta.Delete(fmt.Sprintf("ls:%d:%s", 0, dir))
+	c.meta.Delete(fmt.Sprintf("ls:%d:%s", IncludeHiddenFiles, dir))
+}
+
+// Invalidate discards cached information about name, for instance after a change notified by Watch
+func (c *Cache) Invalidate(name string) {
+	c.invalidateMeta(name)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if el, ok := c.entries[name]; ok && !el.Value.(*cacheEntry).Pending {
+		c.drop(name)
+	}
+}
+
+func (c *Cache) Props() Props {
+	return c.F.Props()
+}
+
+func (c *Cache) ReadDir(name string, opts ListOption) ([]fs.FileInfo, error) {
+	key := fmt.Sprintf("ls:%d:%s", opts, name)
+	if ls, ok := c.meta.Get(key); ok {
+		return ls.([]fs.FileInfo), nil
+	}
+
+	ls, err := c.F.ReadDir(name, opts)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	// files waiting for upload are not yet visible in the file storage
+	c.lock.Lock()
+	seen := map[string]int{}
+	for i, l := range ls {
+		seen[l.Name()] = i
+	}
+	for _, n := range c.queue {
+		dir, base := path.Split(n)
+		if path.Clean(dir) != path.Clean(name) || (opts&IncludeHiddenFiles) == 0 && base[0] == '.' {
+			continue
+		}
+		if el, ok := c.entries[n]; ok {
+			e := el.Value.(*cacheEntry)
+			l := simpleFileInfo{name: base, size: e.Size, modTime: e.ModTime}
+			if i, ok := seen[base]; ok {
+				ls[i] = l
+			} else {
+				ls = append(ls, l)
+			}
+		}
+	}
+	c.lock.Unlock()
+	if err != nil && len(ls) == 0 {
+		return nil, err
+	}
+
+	c.meta.Set(key, ls, cache.DefaultExpiration)
+	return ls, nil
+}
+
+func (c *Cache) Stat(name string) (fs.FileInfo, error) {
+	if e := c.lookup(name); e != nil && e.Pending {
+		return simpleFileInfo{name: path.Base(name), size: e.Size, modTime: e.ModTime}, nil
+	}
+	if l, ok := c.meta.Get("stat:" + name); ok {
+		return l.(fs.FileInfo), nil
+	}
+
+	l, err := c.F.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	c.meta.Set("stat:"+name, l, cache.DefaultExpiration)
+	return l, nil
+}
+
+func (c *Cache) Remove(name string) error {
+	c.lock.Lock()
+	c.drop(name)
+	pending := c.dequeue(name)
+	c.lock.Unlock()
+	c.invalidateMeta(name)
+
+	err := c.F.Remove(name)
+	if pending && os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (c *Cache) Touch(name string) error {
+	c.invalidateMeta(name)
+	return c.F.Touch(name)
+}
+
+func (c *Cache) Watch(name string) chan string {
+	return c.F.Watch(name)
+}
+
+func (c *Cache) Rename(old, new string) error {
+	if err := c.Flush(old); err != nil {
+		return err
+	}
+
+	c.lock.Lock()
+	c.drop(old)
+	c.drop(new)
+	c.lock.Unlock()
+	c.invalidateMeta(old)
+	c.invalidateMeta(new)
+
+	return c.F.Rename(old, new)
+}
+
+func (c *Cache) MkdirAll(name string) error {
+	c.invalidateMeta(name)
+	return c.F.MkdirAll(name)
+}
+
+func (c *Cache) Pull(name string, w io.Writer) error {
+	if e := c.lookup(name); e != nil {
+		fresh := e.Pending
+		if !fresh {
+			l, err := c.Stat(name)
+			fresh = err == nil && l.Size() == e.Size && !l.ModTime().After(e.ModTime)
+		}
+		if fresh {
+			f, err := os.Open(c.dataPath(e.File))
+			if err == nil {
+				defer f.Close()
+				_, err = io.Copy(w, f)
+				return err
+			}
+		}
+	}
+
+	tmp, err := os.CreateTemp(filepath.Join(c.Config.Dir, cacheDataDir), "pull-")
+	if err != nil {
+		return c.F.Pull(name, w)
+	}
+	cw := &countingWriter{W: io.MultiWriter(w, tmp)}
+	err = c.F.Pull(name, cw)
+	_ = tmp.Close()
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+
+	c.install(tmp.Name(), name, cw.Cnt, false)
+	return nil
+}
+
+type countingWriter struct {
+	W   io.Writer
+	Cnt int64
+}
+
+func (w *countingWriter) Write(bs []byte) (int, error) {
+	n, err := w.W.Write(bs)
+	w.Cnt += int64(n)
+	return n, err
+}
+
+// install moves a temporary file in the cache as the content of name. The index is saved before the
+// file is moved, so that a file in the data folder is always known after a crash
+func (c *Cache) install(tmp string, name string, size int64, pending bool) {
+	h := sha1.Sum([]byte(name))
+	file := fmt.Sprintf("%s-%d", hex.EncodeToString(h[:]), time.Now().UnixNano())
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.add(&cacheEntry{
+		Name:    name,
+		File:    file,
+		Size:    size,
+		ModTime: time.Now(),
+		Pending: pending,
+	})
+	if _, ok := c.entries[name]; !ok {
+		// evicted right away since it does not fit in the budget
+		_ = os.Remove(tmp)
+		return
+	}
+	err := c.saveIndex()
+	if err == nil {
+		err = os.Rename(tmp, c.dataPath(file))
+	}
+	if err != nil {
+		logrus.Warnf("cannot cache %s: %v", name, err)
+		c.drop(name)
+		_ = os.Remove(tmp)
+		_ = c.saveIndex()
+	}
+}
+
+func (c *Cache) Push(name string, r io.Reader) error {
+	c.invalidateMeta(name)
+	tmp, err := os.CreateTemp(filepath.Join(c.Config.Dir, cacheDataDir), "push-")
+	if err != nil {
+		if c.Config.WriteBack {
+			return err
+		}
+		return c.F.Push(name, r)
+	}
+
+	cr := &CountingReader{R: r}
+	if c.Config.WriteBack {
+		_, err = io.Copy(tmp, cr)
+	} else {
+		err = c.F.Push(name, io.TeeReader(cr, tmp))
+	}
+	_ = tmp.Close()
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+
+	c.install(tmp.Name(), name, cr.Cnt, c.Config.WriteBack)
+	if c.Config.WriteBack {
+		c.lock.Lock()
+		c.dequeue(name)
+		c.queue = append(c.queue, name)
+		err = c.saveQueue()
+		c.lock.Unlock()
+
+		select {
+		case c.wake <- struct{}{}:
+		default:
+		}
+	}
+	return err
+}
+
+// dequeue removes name from the upload queue and returns true if it was there. The index is saved too,
+// so that it is not queued again on load. The lock must be held
+func (c *Cache) dequeue(name string) bool {
+	for i, n := range c.queue {
+		if n == name {
+			c.queue = append(c.queue[0:i], c.queue[i+1:]...)
+			_ = c.saveQueue()
+			_ = c.saveIndex()
+			return true
+		}
+	}
+	return false
+}
+
+// Pending returns the files waiting to be uploaded
+func (c *Cache) Pending() []string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]string{}, c.queue...)
+}
+
+// Flush uploads name if it is waiting in the queue. Only one upload of a file runs at a time: a second
+// Flush waits for the first and uploads only when the file was pushed again in the meantime
+func (c *Cache) Flush(name string) error {
+	c.lock.Lock()
+	for c.flushing[name] != nil {
+		ch := c.flushing[name]
+		c.lock.Unlock()
+		<-ch
+		c.lock.Lock()
+	}
+	el, ok := c.entries[name]
+	queued := false
+	for _, n := range c.queue {
+		queued = queued || n == name
+	}
+	if !ok || !queued {
+		c.lock.Unlock()
+		return nil
+	}
+	e := *el.Value.(*cacheEntry)
+	done := make(chan struct{})
+	c.flushing[name] = done
+	c.lock.Unlock()
+
+	f, err := os.Open(c.dataPath(e.File))
+	if err == nil {
+		err = c.F.Push(name, f)
+		_ = f.Close()
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.flushing, name)
+	close(done)
+	if err != nil {
+		return err
+	}
+	// a Push during the upload replaced the content, which stays in the queue
+	if el, ok := c.entries[name]; ok && el.Value.(*cacheEntry).File == e.File {
+		el.Value.(*cacheEntry).Pending = false
+		c.dequeue(name)
+	} else if !ok {
+		c.dequeue(name)
+	}
+	c.evict()
+	c.invalidateMeta(name)
+	return nil
+}
+
+// FlushAll uploads all the files in the queue
+func (c *Cache) FlushAll() error {
+	for _, name := range c.Pending() {
+		if err := c.Flush(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Cache) upload() {
+	defer close(c.stopped)
+	for {
+		select {
+		case <-c.wake:
+		case <-time.After(c.Config.RetryPeriod):
+		case <-c.done:
+			return
+		}
+		if err := c.FlushAll(); err != nil {
+			logrus.Warnf("cannot upload cached files to %s: %v", c.F, err)
+		}
+	}
+}
+
+// ReadMeta reads the native meta of the inner store, when available
+func (c *Cache) ReadMeta(name string) ([]byte, error) {
+	n, ok := nativeMeta(c.F)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	return n.ReadMeta(name)
+}
+
+// WriteMeta writes the native meta of the inner store. A file waiting for upload is uploaded first, so
+// that the meta is not lost when the upload replaces the file
+func (c *Cache) WriteMeta(name string, data []byte) error {
+	n, ok := nativeMeta(c.F)
+	if !ok {
+		return ErrNotSupported
+	}
+	if err := c.Flush(name); err != nil {
+		return err
+	}
+	c.invalidateMeta(name)
+	return n.WriteMeta(name, data)
+}
+
+func (c *Cache) Close() error {
+	c.lock.Lock()
+	if c.closed {
+		c.lock.Unlock()
+		return nil
+	}
+	c.closed = true
+	c.lock.Unlock()
+
+	close(c.done)
+	<-c.stopped
+	err := c.FlushAll()
+	if err != nil {
+		logrus.Warnf("files left in upload queue for %s: %v", c.F, err)
+	}
+
+	c.lock.Lock()
+	_ = c.saveIndex()
+	c.lock.Unlock()
+	return c.F.Close()
+}
+
+func (c *Cache) String() string {
+	return fmt.Sprintf("%s#cache", c.F)
+}
@@ -0,0 +1,134 @@
+package store
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	l := NewLocalMount(os.TempDir())
+	dir := filepath.Join(os.TempDir(), "stg", "cache")
+	_ = os.RemoveAll(dir)
+
+	f, err := NewCache(l, CacheConfig{Dir: dir, MaxBytes: 16, WriteBack: true})
+	assert.NoError(t, err)
+	c := f.(*Cache)
+
+	name := "stg/test/cached.txt"
+	_ = l.Remove(name)
+	assert.NoError(t, c.Push(name, bytes.NewBufferString("write back content")))
+	assert.Equal(t, []string{name}, c.Pending())
+
+	st, err := c.Stat(name)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(18), st.Size())
+
+	assert.NoError(t, c.Close())
+	assert.Empty(t, c.Pending())
+	data, err := ReadFile(l, name)
+	assert.NoError(t, err)
+	assert.Equal(t, "write back content", string(data))
+
+	f, err = NewCache(l, CacheConfig{Dir: dir, MaxBytes: 16})
+	assert.NoError(t, err)
+	c = f.(*Cache)
+	assert.Equal(t, int64(0), c.used)
+
+	assert.NoError(t, c.Push("stg/test/small.txt", bytes.NewBufferString("small")))
+	w := &ByteStream{}
+	assert.NoError(t, c.Pull("stg/test/small.txt", w))
+	assert.Equal(t, "small", string(w.Data))
+	assert.NotNil(t, c.lookup("stg/test/small.txt"))
+
+	_ = c.Remove("stg/test/small.txt")
+	_ = c.Remove(name)
+	assert.NoError(t, c.Close())
+}
+
+func TestCacheRecovery(t *testing.T) {
+	l := NewLocalMount(os.TempDir())
+	dir := filepath.Join(os.TempDir(), "stg", "cache-recovery")
+	_ = os.RemoveAll(dir)
+
+	// uploads fail, so the file stays in the queue of the first session
+	f, err := NewCache(NewReadOnly(l), CacheConfig{Dir: dir, WriteBack: true})
+	assert.NoError(t, err)
+	crashed := f.(*Cache)
+	defer close(crashed.done)
+
+	name := "stg/test/recovered.txt"
+	_ = l.Remove(name)
+	assert.NoError(t, crashed.Push(name, bytes.NewBufferString("not uploaded")))
+
+	// the session ends before the queue is saved
+	assert.NoError(t, os.Remove(filepath.Join(dir, cacheQueueFile)))
+
+	f, err = NewCache(l, CacheConfig{Dir: dir, WriteBack: true})
+	assert.NoError(t, err)
+	c := f.(*Cache)
+	assert.NoError(t, c.Close())
+
+	data, err := ReadFile(l, name)
+	assert.NoError(t, err)
+	assert.Equal(t, "not uploaded", string(data))
+	_ = l.Remove(name)
+}
+
+// slowFS counts the pushes and delays them, so that uploads overlap
+type slowFS struct {
+	FS
+	lock   sync.Mutex
+	pushes int
+	ch     chan string
+}
+
+func (s *slowFS) Push(name string, r io.Reader) error {
+	s.lock.Lock()
+	s.pushes++
+	s.lock.Unlock()
+	time.Sleep(20 * time.Millisecond)
+	return s.FS.Push(name, r)
+}
+
+func (s *slowFS) Watch(string) chan string {
+	return s.ch
+}
+
+func TestCacheFlushOnce(t *testing.T) {
+	dir, err := os.MkdirTemp("", "cache")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	l := &slowFS{FS: NewLocalMount(filepath.Join(dir, "store")), ch: make(chan string)}
+	f, err := NewCache(l, CacheConfig{Dir: filepath.Join(dir, "cache"), WriteBack: true})
+	assert.NoError(t, err)
+	c := f.(*Cache)
+
+	name := "flushed.txt"
+	assert.NoError(t, c.Push(name, bytes.NewBufferString("content")))
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, c.Flush(name))
+		}()
+	}
+	assert.NoError(t, c.Rename(name, "renamed.txt"))
+	wg.Wait()
+	assert.NoError(t, c.Close())
+	assert.Equal(t, 1, l.pushes)
+
+	// the watcher has stopped with Close, so nobody receives the notification
+	time.Sleep(10 * time.Millisecond)
+	select {
+	case l.ch <- "renamed.txt":
+		assert.Fail(t, "watch goroutine still running after Close")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,249 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"io/fs"
+	"path"
+)
+
+// compressMagic starts every file written by Compressed. Files without it are returned as they are
+const compressMagic = "\x00bbz"
+
+const (
+	algoNone byte = 'n'
+	algoGzip byte = 'g'
+	algoZstd byte = 'z'
+)
+
+type CompressionConfig struct {
+	// Algorithm is zstd (default) or gzip
+	Algorithm string `json:"algorithm" yaml:"algorithm"`
+	// Level is the compression level. Zero uses the default of the algorithm
+	Level int `json:"level" yaml:"level"`
+}
+
+// Compressed compresses the content on Push and decompresses it on Pull
+type Compressed struct {
+	F      FS
+	Config CompressionConfig
+}
+
+// compressInfo is the meta that records the original size of a compressed file
+type compressInfo struct {
+	Size int64
+}
+
+func init() {
+	RegisterMeta("bbfs.compress", compressInfo{})
+}
+
+func NewCompressed(f FS, config CompressionConfig) FS {
+	if config.Algorithm == "" {
+		config.Algorithm = "zstd"
+	}
+	return &Compressed{
+		F:      f,
+		Config: config,
+	}
+}
+
+func (c *Compressed) algo() byte {
+	if c.Config.Algorithm == "gzip" {
+		return algoGzip
+	}
+	return algoZstd
+}
+
+func (c *Compressed) newWriter(algo byte, w io.Writer) (io.WriteCloser, error) {
+	switch algo {
+	case algoGzip:
+		level := c.Config.Level
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		return gzip.NewWriterLevel(w, level)
+	case algoZstd:
+		level := zstd.SpeedDefault
+		if c.Config.Level != 0 {
+			level = zstd.EncoderLevelFromZstd(c.Config.Level)
+		}
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(level))
+	default:
+		return nopWriteCloser{w}, nil
+	}
+}
+
+func newDecompressReader(algo byte, r io.Reader) (io.ReadCloser, error) {
+	switch algo {
+	case algoGzip:
+		return gzip.NewReader(r)
+	case algoZstd:
+		d, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	case algoNone:
+		return io.NopCloser(r), nil
+	default:
+		return nil, fmt.Errorf("unknown compression algorithm '%c'", algo)
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+func (c *Compressed) logical(dir string, l fs.FileInfo) fs.FileInfo {
+	if l.IsDir() {
+		return l
+	}
+	var ci compressInfo
+	if err := GetMeta(c.F, path.Join(dir, l.Name()), &ci); err != nil {
+		return l
+	}
+	return logicalFileInfo{l, ci.Size}
+}
+
+func (c *Compressed) Props() Props {
+	return c.F.Props()
+}
+
+func (c *Compressed) ReadDir(name string, opts ListOption) ([]fs.FileInfo, error) {
+	ls, err := c.F.ReadDir(name, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var fis []fs.FileInfo
+	for _, l := range ls {
+		fis = append(fis, c.logical(name, l))
+	}
+	return fis, nil
+}
+
+func (c *Compressed) Stat(name string) (fs.FileInfo, error) {
+	l, err := c.F.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	return c.logical(path.Dir(name), l), nil
+}
+
+func (c *Compressed) Remove(name string) error {
+	return c.F.Remove(name)
+}
+
+func (c *Compressed) Touch(name string) error {
+	return c.F.Touch(name)
+}
+
+func (c *Compressed) Watch(name string) chan string {
+	return c.F.Watch(name)
+}
+
+// Rename moves the meta, which records the original size, together with the file when the storage
+// below does not move it
+func (c *Compressed) Rename(old, new string) error {
+	doc, metaErr := loadMeta(c.F, old)
+	if err := c.F.Rename(old, new); err != nil {
+		return err
+	}
+	if metaErr != nil || IsMeta(old) {
+		return nil
+	}
+	if _, err := loadMeta(c.F, old); err != nil {
+		return nil
+	}
+	if err := storeMeta(c.F, new, doc); err != nil {
+		return err
+	}
+	return storeMeta(c.F, old, newMetaDoc())
+}
+
+func (c *Compressed) MkdirAll(name string) error {
+	return c.F.MkdirAll(name)
+}
+
+func (c *Compressed) Pull(name string, w io.Writer) error {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(c.F.Pull(name, pw))
+	}()
+	defer pr.Close()
+
+	br := bufio.NewReader(pr)
+	head, err := br.Peek(len(compressMagic) + 1)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if !bytes.HasPrefix(head, []byte(compressMagic)) || len(head) <= len(compressMagic) {
+		_, err = io.Copy(w, br)
+		return err
+	}
+
+	_, _ = br.Discard(len(head))
+	r, err := newDecompressReader(head[len(compressMagic)], br)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	_, err = io.Copy(w, r)
+	return err
+}
+
+func (c *Compressed) Push(name string, r io.Reader) error {
+	br := bufio.NewReaderSize(r, 512)
+	head, err := br.Peek(512)
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	algo := c.algo()
+	if IsCompressedMime(DetectMime(head)) {
+		algo = algoNone
+	}
+
+	cr := &CountingReader{br, 0}
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := pw.Write(append([]byte(compressMagic), algo))
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		cw, err := c.newWriter(algo, pw)
+		if err == nil {
+			_, err = io.Copy(cw, cr)
+			if e := cw.Close(); err == nil {
+				err = e
+			}
+		}
+		pw.CloseWithError(err)
+	}()
+
+	err = c.F.Push(name, pr)
+	pr.CloseWithError(err)
+	if err != nil {
+		return err
+	}
+	return SetMeta(c.F, name, compressInfo{cr.Cnt})
+}
+
+func (c *Compressed) Close() error {
+	return c.F.Close()
+}
+
+func (c *Compressed) String() string {
+	return fmt.Sprintf("%s#compressed-%s", c.F, c.Config.Algorithm)
+}
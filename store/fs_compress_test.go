@@ -0,0 +1,56 @@
+package store
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCompressed(t *testing.T) {
+	l := NewLocalMount(os.TempDir())
+
+	for _, algo := range []string{"zstd", "gzip"} {
+		// the configuration compresses before it encrypts
+		c, err := decorate(l, Config{Compression: &CompressionConfig{Algorithm: algo}, Encryption: &EncryptionConfig{Key: "Hello"}})
+		assert.NoError(t, err)
+		compressed, ok := Find[*Compressed](c)
+		if assert.True(t, ok) {
+			_, ok = Find[*Encrypted](compressed.F)
+			assert.True(t, ok)
+		}
+
+		name := "stg/test/compressed.csv"
+		content := []byte(strings.Repeat("id,name,value\n1,first,0.5\n", 1000))
+		assert.NoError(t, c.Push(name, bytes.NewReader(content)))
+
+		w := &ByteStream{}
+		assert.NoError(t, c.Pull(name, w))
+		assert.Equal(t, content, w.Data)
+
+		st, err := c.Stat(name)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(len(content)), st.Size())
+
+		st, err = l.Stat(name)
+		assert.NoError(t, err)
+		assert.Less(t, st.Size(), int64(len(content))/10)
+
+		renamed := "stg/test/renamed.csv"
+		assert.NoError(t, c.Rename(name, renamed))
+		st, err = c.Stat(renamed)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(len(content)), st.Size())
+
+		_ = RemoveMeta(l, renamed)
+		_ = l.Remove(renamed)
+	}
+
+	legacy := "stg/test/legacy.txt"
+	assert.NoError(t, l.Push(legacy, bytes.NewBufferString("not compressed")))
+	w := &ByteStream{}
+	assert.NoError(t, NewCompressed(l, CompressionConfig{}).Pull(legacy, w))
+	assert.Equal(t, "not compressed", string(w.Data))
+	_ = l.Remove(legacy)
+}
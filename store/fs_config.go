@@ -28,6 +28,30 @@ type Config struct {
 	HTTP       *HTTPConfig       `json:"http,omitempty" yaml:"http,omitempty"`
 	Sharepoint *SharepointConfig `json:"sharepoint,omitempty" yaml:"sharepoint,omitempty"`
 	Kafka      *KafkaConfig      `json:"kafka,omitempty" yaml:"kafka,omitempty"`
+	Union      *UnionConfig      `json:"union,omitempty" yaml:"union,omitempty"`
+	Mirror     *MirrorConfig     `json:"mirror,omitempty" yaml:"mirror,omitempty"`
+	Erasure    *ErasureConfig    `json:"erasure,omitempty" yaml:"erasure,omitempty"`
+	Sharded    *ShardedConfig    `json:"sharded,omitempty" yaml:"sharded,omitempty"`
+
+	BWLimit     *BWLimitConfig     `json:"bwlimit,omitempty" yaml:"bwlimit,omitempty"`
+	Retry       *RetryConfig       `json:"retry,omitempty" yaml:"retry,omitempty"`
+	Encryption  *EncryptionConfig  `json:"encryption,omitempty" yaml:"encryption,omitempty"`
+	Quota       *QuotaConfig       `json:"quota,omitempty" yaml:"quota,omitempty"`
+	Compression *CompressionConfig `json:"compression,omitempty" yaml:"compression,omitempty"`
+	Dedup       *DedupConfig       `json:"dedup,omitempty" yaml:"dedup,omitempty"`
+	Hashed      *HashConfig        `json:"hashed,omitempty" yaml:"hashed,omitempty"`
+	Cache       *CacheConfig       `json:"cache,omitempty" yaml:"cache,omitempty"`
+	Versioned   *VersionedConfig   `json:"versioned,omitempty" yaml:"versioned,omitempty"`
+	Trash       *TrashConfig       `json:"trash,omitempty" yaml:"trash,omitempty"`
+	WORM        *WORMConfig        `json:"worm,omitempty" yaml:"worm,omitempty"`
+	Access      *AccessConfig      `json:"access,omitempty" yaml:"access,omitempty"`
+	Audit       *AuditConfig       `json:"audit,omitempty" yaml:"audit,omitempty"`
+	// MetaAware renames and removes the files together with their meta sidecars
+	MetaAware bool `json:"metaAware,omitempty" yaml:"metaAware,omitempty"`
+	// ReadOnly refuses all the changes to the store
+	ReadOnly bool `json:"readOnly,omitempty" yaml:"readOnly,omitempty"`
+	// Metrics records the activity of the store in DefaultMetrics
+	Metrics bool `json:"metrics,omitempty" yaml:"metrics,omitempty"`
 }
 
 const keyHashFile = ".keyHash"
@@ -55,6 +79,83 @@ func IsValidKeyHash(f FS, g Group, groups map[Group]string) bool {
 
 // NewFS creates a new file storage broker with the given configuration c
 func NewFS(c Config) (FS, error) {
+	f, err := newBackend(c)
+	if err != nil {
+		return nil, err
+	}
+	return decorate(f, c)
+}
+
+// decorate wraps the file storage f with the decorators enabled in the configuration c
+func decorate(f FS, c Config) (FS, error) {
+	var err error
+	// every store is limited by the global buckets on top of its own limits, even when the global
+	// limits are set after the store is created
+	bwlimit := BWLimitConfig{}
+	if c.BWLimit != nil {
+		bwlimit = *c.BWLimit
+	}
+	f = NewBWLimit(f, bwlimit)
+	if c.Retry != nil {
+		f = NewRetry(f, *c.Retry)
+	}
+	// encryption stays below compression and the other decorators, since encrypted data does not compress
+	if c.Encryption != nil {
+		b, err := NewAesCipher([]byte(c.Encryption.Key))
+		if err != nil {
+			return nil, err
+		}
+		f = NewEncrypted(f, b)
+	}
+	if c.MetaAware {
+		f = NewMetaAware(f)
+	}
+	if c.Quota != nil {
+		f, err = NewQuotaWithConfig(f, *c.Quota)
+	}
+	if err == nil && c.Compression != nil {
+		f = NewCompressed(f, *c.Compression)
+	}
+	if err == nil && c.Dedup != nil {
+		f = NewDedupWithConfig(f, *c.Dedup)
+	}
+	if err == nil && c.Hashed != nil {
+		f, err = NewHashed(f, *c.Hashed)
+	}
+	if err == nil && c.Cache != nil {
+		f, err = NewCache(f, *c.Cache)
+	}
+	if err == nil && c.Versioned != nil {
+		f = NewVersioned(f, *c.Versioned)
+	}
+	if err == nil && c.Trash != nil {
+		f = NewTrashWithConfig(f, *c.Trash)
+	}
+	if err == nil && c.WORM != nil {
+		f = NewWORM(f, *c.WORM)
+	}
+	if err == nil && c.ReadOnly {
+		f = NewReadOnly(f)
+	}
+	if err == nil && c.Access != nil {
+		f, err = newAccessFromConfig(f, *c.Access)
+	}
+	if err == nil && c.Audit != nil {
+		var log FS
+		if c.Audit.Log != nil {
+			if log, err = NewFS(*c.Audit.Log); err != nil {
+				return nil, err
+			}
+		}
+		f, err = NewAudit(f, log, *c.Audit)
+	}
+	if err == nil && c.Metrics {
+		f = NewMetrics(f, c.Name)
+	}
+	return f, err
+}
+
+func newBackend(c Config) (FS, error) {
 	switch {
 	case c.FTP != nil:
 		return NewFTP(*c.FTP)
@@ -72,6 +173,14 @@ func NewFS(c Config) (FS, error) {
 		return NewSharepoint(*c.Sharepoint)
 	case c.Kafka != nil:
 		return NewKafka(*c.Kafka)
+	case c.Union != nil:
+		return newUnionFromConfig(*c.Union)
+	case c.Mirror != nil:
+		return newMirrorFromConfig(*c.Mirror)
+	case c.Erasure != nil:
+		return newErasureFromConfig(*c.Erasure)
+	case c.Sharded != nil:
+		return newShardedFromConfig(*c.Sharded)
 	}
 
 	return nil, os.ErrInvalid
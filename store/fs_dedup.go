@@ -0,0 +1,340 @@
+package store
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/hashicorp/go-multierror"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const dedupMagic = "#bbfs-dedup\n"
+
+// DefaultChunkFolder is the hidden folder where Dedup stores the chunks
+const DefaultChunkFolder = ".chunks"
+
+type DedupConfig struct {
+	// Folder is the hidden folder where the chunks are stored. Default is .chunks
+	Folder string `json:"folder" yaml:"folder"`
+	// MinChunk, AvgChunk and MaxChunk are the sizes of the chunks in bytes. Zero uses 256KB, 1MB and 4MB
+	MinChunk int `json:"minChunk" yaml:"minChunk"`
+	AvgChunk int `json:"avgChunk" yaml:"avgChunk"`
+	MaxChunk int `json:"maxChunk" yaml:"maxChunk"`
+}
+
+// Dedup splits files in content defined chunks and stores each chunk only once.
+// The original file is replaced by a small manifest listing the chunks
+type Dedup struct {
+	F        FS
+	Folder   string
+	MinChunk int
+	AvgChunk int
+	MaxChunk int
+	// lock keeps GC out while a Push writes its chunks and manifest
+	lock sync.RWMutex
+}
+
+type dedupManifest struct {
+	Size   int64    `json:"size"`
+	Chunks []string `json:"chunks"`
+}
+
+func NewDedup(f FS, chunkFolder string) FS {
+	return NewDedupWithConfig(f, DedupConfig{Folder: chunkFolder})
+}
+
+func NewDedupWithConfig(f FS, config DedupConfig) FS {
+	if config.Folder == "" {
+		config.Folder = DefaultChunkFolder
+	}
+	if config.MinChunk == 0 {
+		config.MinChunk = 256 * 1024
+	}
+	if config.AvgChunk == 0 {
+		config.AvgChunk = 1024 * 1024
+	}
+	if config.MaxChunk == 0 {
+		config.MaxChunk = 4 * 1024 * 1024
+	}
+	return &Dedup{
+		F:        f,
+		Folder:   config.Folder,
+		MinChunk: config.MinChunk,
+		AvgChunk: config.AvgChunk,
+		MaxChunk: config.MaxChunk,
+	}
+}
+
+var gearTable = func() [256]uint64 {
+	var t [256]uint64
+	var x uint64 = 0x62616279626c7565
+	for i := range t {
+		// splitmix64 keeps the table identical across builds and platforms
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		t[i] = z ^ (z >> 31)
+	}
+	return t
+}()
+
+type chunker struct {
+	r    io.Reader
+	buf  []byte
+	eof  bool
+	min  int
+	max  int
+	mask uint64
+}
+
+func newChunker(r io.Reader, min, avg, max int) *chunker {
+	var mask uint64 = 1
+	for mask < uint64(avg) {
+		mask <<= 1
+	}
+	return &chunker{
+		r:    r,
+		buf:  make([]byte, 0, max),
+		min:  min,
+		max:  max,
+		mask: mask - 1,
+	}
+}
+
+func (c *chunker) fill() error {
+	for !c.eof && len(c.buf) < c.max {
+		n, err := c.r.Read(c.buf[len(c.buf):c.max])
+		c.buf = c.buf[0 : len(c.buf)+n]
+		if err == io.EOF {
+			c.eof = true
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// next returns the next chunk or io.EOF when the stream is over.
+// The returned slice is valid until the following call
+func (c *chunker) next(cut []byte) ([]byte, error) {
+	if err := c.fill(); err != nil {
+		return nil, err
+	}
+	if len(c.buf) == 0 {
+		return nil, io.EOF
+	}
+
+	end := len(c.buf)
+	if end > c.min {
+		var h uint64
+		for i := c.min; i < end; i++ {
+			h = (h << 1) + gearTable[c.buf[i]]
+			if h&c.mask == 0 {
+				end = i + 1
+				break
+			}
+		}
+	}
+
+	cut = append(cut[:0], c.buf[0:end]...)
+	c.buf = c.buf[0:copy(c.buf, c.buf[end:])]
+	return cut, nil
+}
+
+func (d *Dedup) chunkName(hash string) string {
+	return path.Join(d.Folder, hash[0:2], hash)
+}
+
+func (d *Dedup) isChunkArea(name string) bool {
+	name = strings.Trim(name, "/")
+	return name == d.Folder || strings.HasPrefix(name, d.Folder+"/")
+}
+
+// readManifest returns the manifest stored in name, or nil when name is a regular file
+func (d *Dedup) readManifest(name string) (*dedupManifest, error) {
+	head, err := Peek(d.F, name, len(dedupMagic))
+	if len(head) < len(dedupMagic) {
+		return nil, err
+	}
+	if string(head) != dedupMagic {
+		return nil, nil
+	}
+
+	var s ByteStream
+	if err = d.F.Pull(name, &s); err != nil {
+		return nil, err
+	}
+	var m dedupManifest
+	if err = json.Unmarshal(s.Data[len(dedupMagic):], &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+func (d *Dedup) logical(dir string, l fs.FileInfo) fs.FileInfo {
+	if l.IsDir() {
+		return l
+	}
+	m, err := d.readManifest(path.Join(dir, l.Name()))
+	if err != nil || m == nil {
+		return l
+	}
+	return logicalFileInfo{l, m.Size}
+}
+
+func (d *Dedup) Props() Props {
+	return d.F.Props()
+}
+
+func (d *Dedup) ReadDir(name string, opts ListOption) ([]fs.FileInfo, error) {
+	ls, err := d.F.ReadDir(name, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var fis []fs.FileInfo
+	for _, l := range ls {
+		if d.isChunkArea(path.Join(name, l.Name())) {
+			continue
+		}
+		fis = append(fis, d.logical(name, l))
+	}
+	return fis, nil
+}
+
+func (d *Dedup) Stat(name string) (fs.FileInfo, error) {
+	l, err := d.F.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	return d.logical(path.Dir(name), l), nil
+}
+
+func (d *Dedup) Remove(name string) error {
+	return d.F.Remove(name)
+}
+
+func (d *Dedup) Touch(name string) error {
+	return d.F.Touch(name)
+}
+
+func (d *Dedup) Watch(name string) chan string {
+	return d.F.Watch(name)
+}
+
+func (d *Dedup) Rename(old, new string) error {
+	return d.F.Rename(old, new)
+}
+
+func (d *Dedup) MkdirAll(name string) error {
+	return d.F.MkdirAll(name)
+}
+
+func (d *Dedup) Pull(name string, w io.Writer) error {
+	m, err := d.readManifest(name)
+	if err != nil {
+		return err
+	}
+	if m == nil {
+		return d.F.Pull(name, w)
+	}
+
+	for _, c := range m.Chunks {
+		if err = d.F.Pull(d.chunkName(c), w); err != nil {
+			return fmt.Errorf("cannot read chunk %s of %s: %w", c, name, err)
+		}
+	}
+	return nil
+}
+
+func (d *Dedup) Push(name string, r io.Reader) error {
+	var m dedupManifest
+	var buf []byte
+
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	c := newChunker(r, d.MinChunk, d.AvgChunk, d.MaxChunk)
+	for {
+		chunk, err := c.next(buf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		buf = chunk
+
+		h := sha256.Sum256(chunk)
+		hash := hex.EncodeToString(h[:])
+		if !Exists(d.F, d.chunkName(hash)) {
+			if err = d.F.Push(d.chunkName(hash), bytes.NewReader(chunk)); err != nil {
+				return err
+			}
+		} else {
+			// a reused chunk is renewed, so that the grace period of GC in other processes protects it
+			_ = d.F.Touch(d.chunkName(hash))
+		}
+		m.Chunks = append(m.Chunks, hash)
+		m.Size += int64(len(chunk))
+	}
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return d.F.Push(name, &ByteStream{append([]byte(dedupMagic), data...), 0})
+}
+
+// GC removes the chunks that are not referenced by any manifest and are older than grace.
+// Pushes on the same Dedup wait for GC to end; the grace period protects the chunks of uploads
+// still in progress in other processes
+func (d *Dedup) GC(grace time.Duration) (removed int, err error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	used := map[string]bool{}
+	err = Walk(d, "", IncludeHiddenFiles, func(dir string, file fs.FileInfo) {
+		m, _ := d.readManifest(path.Join(dir, file.Name()))
+		if m != nil {
+			for _, c := range m.Chunks {
+				used[c] = true
+			}
+		}
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var me *multierror.Error
+	limit := time.Now().Add(-grace)
+	err = Walk(d.F, d.Folder, IncludeHiddenFiles, func(dir string, file fs.FileInfo) {
+		if !used[file.Name()] && file.ModTime().Before(limit) {
+			e := d.F.Remove(path.Join(dir, file.Name()))
+			if e == nil {
+				removed++
+			}
+			me = multierror.Append(me, e)
+		}
+	})
+	if err != nil {
+		return removed, err
+	}
+	return removed, me.ErrorOrNil()
+}
+
+func (d *Dedup) Close() error {
+	return d.F.Close()
+}
+
+func (d *Dedup) String() string {
+	return fmt.Sprintf("%s#dedup", d.F)
+}
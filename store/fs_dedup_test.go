@@ -0,0 +1,103 @@
+package store
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"math/rand"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDedup(t *testing.T) {
+	l := NewLocalMount(os.TempDir())
+	_ = l.MkdirAll("stg/dedup")
+	d := NewDedup(NewSub(l, "stg/dedup"), "").(*Dedup)
+	d.MinChunk, d.AvgChunk, d.MaxChunk = 1024, 4096, 16384
+
+	content := make([]byte, 256*1024)
+	rand.Read(content)
+	assert.NoError(t, d.Push("a.bin", bytes.NewReader(content)))
+
+	changed := append([]byte{}, content...)
+	changed[100*1024] ^= 0xff
+	assert.NoError(t, d.Push("b.bin", bytes.NewReader(changed)))
+
+	w := &ByteStream{}
+	assert.NoError(t, d.Pull("b.bin", w))
+	assert.Equal(t, changed, w.Data)
+
+	st, err := d.Stat("a.bin")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(content)), st.Size())
+
+	var chunks int
+	_ = Walk(d.F, d.Folder, IncludeHiddenFiles, func(string, os.FileInfo) { chunks++ })
+	m, err := d.readManifest("a.bin")
+	assert.NoError(t, err)
+	assert.Less(t, chunks, 2*len(m.Chunks))
+
+	ls, err := d.ReadDir("", IncludeHiddenFiles)
+	assert.NoError(t, err)
+	for _, l := range ls {
+		assert.NotEqual(t, DefaultChunkFolder, l.Name())
+	}
+
+	assert.NoError(t, d.Remove("a.bin"))
+	assert.NoError(t, d.Remove("b.bin"))
+	removed, err := d.GC(0)
+	assert.NoError(t, err)
+	assert.Equal(t, chunks, removed)
+}
+
+func TestDedupConfig(t *testing.T) {
+	f, err := decorate(NewMemory(nil, 0), Config{Dedup: &DedupConfig{AvgChunk: 4096}})
+	assert.NoError(t, err)
+	d, ok := Find[*Dedup](f)
+	assert.True(t, ok)
+	assert.Equal(t, DefaultChunkFolder, d.Folder)
+	assert.Equal(t, 4096, d.AvgChunk)
+	assert.Equal(t, 256*1024, d.MinChunk)
+}
+
+func TestDedupGCConcurrentPush(t *testing.T) {
+	dir, err := os.MkdirTemp("", "dedup")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	d := NewDedup(NewLocalMount(dir), "").(*Dedup)
+	d.MinChunk, d.AvgChunk, d.MaxChunk = 1024, 4096, 16384
+
+	content := make([]byte, 256*1024)
+	rand.Read(content)
+	assert.NoError(t, d.Push("a.bin", bytes.NewReader(content)))
+	assert.NoError(t, d.Remove("a.bin"))
+
+	// the push reuses the orphan chunks of a.bin and stops halfway until GC has started
+	started, release := make(chan struct{}), make(chan struct{})
+	r := io.MultiReader(bytes.NewReader(content[:128*1024]), onRead(func() {
+		close(started)
+		<-release
+	}), bytes.NewReader(content[128*1024:]))
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		assert.NoError(t, d.Push("b.bin", r))
+	}()
+	<-started
+	go func() {
+		defer wg.Done()
+		_, err := d.GC(0)
+		assert.NoError(t, err)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	w := &ByteStream{}
+	assert.NoError(t, d.Pull("b.bin", w))
+	assert.Equal(t, content, w.Data)
+}
@@ -7,6 +7,11 @@ import (
 	"io/fs"
 )
 
+type EncryptionConfig struct {
+	// Key is the passphrase the AES key is derived from
+	Key string `json:"key" yaml:"key" secret:"true"`
+}
+
 type Encrypted struct {
 	F FS
 	B cipher.Block
@@ -59,6 +64,35 @@ func (e Encrypted) Push(name string, r io.Reader) error {
 	return e.F.Push(name, CipherReader(e.B, r))
 }
 
+// ReadMeta reads and decrypts the native meta of the inner store, when available
+func (e Encrypted) ReadMeta(name string) ([]byte, error) {
+	n, ok := nativeMeta(e.F)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	data, err := n.ReadMeta(name)
+	if err != nil {
+		return nil, err
+	}
+	return DecryptBytes(e.B, data)
+}
+
+// WriteMeta encrypts the meta like the content, so that the inner store never keeps it in clear
+func (e Encrypted) WriteMeta(name string, data []byte) error {
+	n, ok := nativeMeta(e.F)
+	if !ok {
+		return ErrNotSupported
+	}
+	if data == nil {
+		return n.WriteMeta(name, nil)
+	}
+	data, err := EncryptBytes(e.B, data)
+	if err != nil {
+		return err
+	}
+	return n.WriteMeta(name, data)
+}
+
 func (e Encrypted) Close() error {
 	return e.F.Close()
 }
@@ -0,0 +1,577 @@
+package store
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/go-multierror"
+	"github.com/klauspost/reedsolomon"
+	"github.com/sirupsen/logrus"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErasureConfig defines a file storage that stripes each file in Data + Parity shards, one per store
+type ErasureConfig struct {
+	// Shards are the stores that receive the shards. They must be Data + Parity
+	Shards []Config `json:"shards" yaml:"shards"`
+	// Data is the number of data shards. Default is the number of stores minus the parity
+	Data int `json:"data" yaml:"data"`
+	// Parity is the number of parity shards, i.e. the stores that can be lost. Default is 1
+	Parity int `json:"parity" yaml:"parity"`
+}
+
+var ErrNotEnoughShards = errors.New("not enough shards to reconstruct the file")
+
+const (
+	erasureMagic = "\x00bbe"
+	// erasureHeaderSize covers magic, version, shard index, data, parity, the file size and the generation
+	erasureHeaderSize = 4 + 4 + 8 + 8
+	// erasureBlockHeader is the length and the sha256 of each block of a shard
+	erasureBlockHeader = 4 + sha256.Size
+	// erasureBlockSize is the amount of data in each block of a shard
+	erasureBlockSize = 1024 * 1024
+)
+
+type erasureHeader struct {
+	Index  int
+	Data   int
+	Parity int
+	Size   int64
+	// Generation is assigned on each Push and kept when shards are repaired, so that the shards left by
+	// a previous write of the file are not mixed with the current ones
+	Generation int64
+}
+
+func (h erasureHeader) encode() []byte {
+	bs := make([]byte, erasureHeaderSize)
+	copy(bs, erasureMagic)
+	bs[4], bs[5], bs[6], bs[7] = 1, byte(h.Index), byte(h.Data), byte(h.Parity)
+	binary.BigEndian.PutUint64(bs[8:], uint64(h.Size))
+	binary.BigEndian.PutUint64(bs[16:], uint64(h.Generation))
+	return bs
+}
+
+func decodeErasureHeader(bs []byte) (erasureHeader, error) {
+	if len(bs) < erasureHeaderSize || string(bs[0:4]) != erasureMagic || bs[4] != 1 {
+		return erasureHeader{}, fmt.Errorf("invalid shard header: %w", os.ErrInvalid)
+	}
+	return erasureHeader{
+		Index:      int(bs[5]),
+		Data:       int(bs[6]),
+		Parity:     int(bs[7]),
+		Size:       int64(binary.BigEndian.Uint64(bs[8:])),
+		Generation: int64(binary.BigEndian.Uint64(bs[16:])),
+	}, nil
+}
+
+// readErasureHeader reads the header at the start of a shard
+func readErasureHeader(r io.Reader) (erasureHeader, error) {
+	bs := make([]byte, erasureHeaderSize)
+	if _, err := io.ReadFull(r, bs); err != nil {
+		return erasureHeader{}, err
+	}
+	return decodeErasureHeader(bs)
+}
+
+// currentGeneration returns the header of the newest generation with enough shards to decode the file and
+// the number of its shards. When no generation can be decoded, the one with most shards is returned
+func (e *ErasureCoded) currentGeneration(headers []*erasureHeader) (erasureHeader, int) {
+	counts := map[int64]int{}
+	byGeneration := map[int64]erasureHeader{}
+	for _, h := range headers {
+		if h != nil {
+			counts[h.Generation]++
+			byGeneration[h.Generation] = *h
+		}
+	}
+
+	var current erasureHeader
+	found, decodable := 0, false
+	for g, c := range counts {
+		switch {
+		case c >= e.Data && (!decodable || g > current.Generation):
+			current, found, decodable = byGeneration[g], c, true
+		case !decodable && c > found:
+			current, found = byGeneration[g], c
+		}
+	}
+	return current, found
+}
+
+// ErasureCoded splits each file in data and parity shards with Reed-Solomon codes, so that files
+// survive the loss of up to Parity stores
+type ErasureCoded struct {
+	Shards []FS
+	Data   int
+	Parity int
+	enc    reedsolomon.Encoder
+}
+
+func NewErasureCoded(shards []FS, data, parity int) (FS, error) {
+	if parity <= 0 {
+		parity = 1
+	}
+	if data <= 0 {
+		data = len(shards) - parity
+	}
+	if data <= 0 || data+parity != len(shards) || len(shards) > 255 {
+		return nil, fmt.Errorf("%d stores cannot hold %d data and %d parity shards: %w",
+			len(shards), data, parity, os.ErrInvalid)
+	}
+	enc, err := reedsolomon.New(data, parity)
+	if err != nil {
+		return nil, err
+	}
+	return &ErasureCoded{
+		Shards: shards,
+		Data:   data,
+		Parity: parity,
+		enc:    enc,
+	}, nil
+}
+
+func newErasureFromConfig(c ErasureConfig) (FS, error) {
+	var shards []FS
+	for _, sc := range c.Shards {
+		s, err := NewFS(sc)
+		if err != nil {
+			for _, s := range shards {
+				_ = s.Close()
+			}
+			return nil, err
+		}
+		shards = append(shards, s)
+	}
+	return NewErasureCoded(shards, c.Data, c.Parity)
+}
+
+// pushShards encodes r and writes the shards selected by only, or all the shards when only is nil, with
+// the generation in their header. It returns the number of shards written
+func (e *ErasureCoded) pushShards(name string, r io.Reader, only map[int]bool, generation int64) (int, error) {
+	n := e.Data + e.Parity
+	readers := make([]*io.PipeReader, n)
+	writers := make([]*io.PipeWriter, n)
+	for i := range readers {
+		readers[i], writers[i] = io.Pipe()
+	}
+
+	errs := make([]error, n)
+	done := make(chan int, n)
+	for i := range e.Shards {
+		go func(i int) {
+			if only == nil || only[i] {
+				errs[i] = e.Shards[i].Push(name, readers[i])
+			} else {
+				errs[i] = os.ErrInvalid
+			}
+			_ = readers[i].CloseWithError(io.ErrClosedPipe)
+			done <- i
+		}(i)
+	}
+
+	// the size of the file must be known for the header: the content is spooled to a temporary file
+	tmp, err := os.CreateTemp("", "bbfs-erasure")
+	if err == nil {
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+		var size int64
+		if size, err = io.Copy(tmp, r); err == nil {
+			_, err = tmp.Seek(0, io.SeekStart)
+			if err == nil {
+				err = e.encodeStripes(tmp, size, generation, writers)
+			}
+		}
+	}
+	for _, w := range writers {
+		_ = w.CloseWithError(err)
+	}
+	for range e.Shards {
+		<-done
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var me *multierror.Error
+	written := 0
+	for i, err := range errs {
+		switch {
+		case err == nil:
+			written++
+		case only == nil || only[i]:
+			me = multierror.Append(me, fmt.Errorf("shard %d on %s: %w", i, e.Shards[i], err))
+		}
+	}
+	return written, me.ErrorOrNil()
+}
+
+// encodeStripes writes the header and the blocks of each shard. A shard that fails is skipped
+func (e *ErasureCoded) encodeStripes(r io.Reader, size, generation int64, writers []*io.PipeWriter) error {
+	alive := make([]bool, len(writers))
+	write := func(i int, bs []byte) {
+		if alive[i] {
+			if _, err := writers[i].Write(bs); err != nil {
+				alive[i] = false
+			}
+		}
+	}
+	for i := range writers {
+		alive[i] = true
+		write(i, erasureHeader{Index: i, Data: e.Data, Parity: e.Parity, Size: size, Generation: generation}.encode())
+	}
+
+	stripe := make([]byte, erasureBlockSize*e.Data)
+	for remaining := size; remaining > 0; {
+		l := int64(len(stripe))
+		if remaining < l {
+			l = remaining
+		}
+		if _, err := io.ReadFull(r, stripe[0:l]); err != nil {
+			return err
+		}
+		remaining -= l
+
+		shards, err := e.enc.Split(stripe[0:l])
+		if err != nil {
+			return err
+		}
+		if err = e.enc.Encode(shards); err != nil {
+			return err
+		}
+		for i, s := range shards {
+			h := make([]byte, erasureBlockHeader)
+			binary.BigEndian.PutUint32(h, uint32(len(s)))
+			sum := sha256.Sum256(s)
+			copy(h[4:], sum[:])
+			write(i, h)
+			write(i, s)
+		}
+	}
+	return nil
+}
+
+// shardReaders opens a stream on each shard of the current generation. Missing, invalid and stale shards
+// have a nil reader
+func (e *ErasureCoded) shardReaders(name string) ([]*io.PipeReader, erasureHeader, error) {
+	n := e.Data + e.Parity
+	readers := make([]*io.PipeReader, n)
+	headers := make([]*erasureHeader, n)
+	for i, s := range e.Shards {
+		pr, pw := io.Pipe()
+		go func(s FS) {
+			_ = pw.CloseWithError(s.Pull(name, pw))
+		}(s)
+
+		h, err := readErasureHeader(pr)
+		if err != nil || h.Index != i || h.Data != e.Data || h.Parity != e.Parity {
+			_ = pr.CloseWithError(io.ErrClosedPipe)
+			continue
+		}
+		readers[i], headers[i] = pr, &h
+	}
+
+	header, found := e.currentGeneration(headers)
+	for i, h := range headers {
+		if h != nil && h.Generation != header.Generation {
+			// shard left by a previous write of the file
+			_ = readers[i].CloseWithError(io.ErrClosedPipe)
+			readers[i] = nil
+		}
+	}
+	if found < e.Data {
+		closeReaders(readers)
+		if found == 0 {
+			return nil, header, os.ErrNotExist
+		}
+		return nil, header, fmt.Errorf("%s has %d shards out of %d: %w", name, found, e.Data, ErrNotEnoughShards)
+	}
+	return readers, header, nil
+}
+
+func closeReaders(readers []*io.PipeReader) {
+	for _, r := range readers {
+		if r != nil {
+			_ = r.CloseWithError(io.ErrClosedPipe)
+		}
+	}
+}
+
+// readBlock returns the next block of a shard, or nil when the shard is missing or corrupted
+func readBlock(r *io.PipeReader) []byte {
+	if r == nil {
+		return nil
+	}
+	h := make([]byte, erasureBlockHeader)
+	if _, err := io.ReadFull(r, h); err != nil {
+		return nil
+	}
+	l := binary.BigEndian.Uint32(h)
+	if l > erasureBlockSize {
+		return nil
+	}
+	bs := make([]byte, l)
+	if _, err := io.ReadFull(r, bs); err != nil {
+		return nil
+	}
+	if sum := sha256.Sum256(bs); !bytes.Equal(sum[:], h[4:]) {
+		return nil
+	}
+	return bs
+}
+
+// decode writes the content of name to w and returns the header of the shards in use and the indexes of
+// the shards that are damaged
+func (e *ErasureCoded) decode(name string, w io.Writer) (erasureHeader, map[int]bool, error) {
+	readers, header, err := e.shardReaders(name)
+	if err != nil {
+		return header, nil, err
+	}
+	defer closeReaders(readers)
+
+	damaged := map[int]bool{}
+	for i, r := range readers {
+		if r == nil {
+			damaged[i] = true
+		}
+	}
+	for remaining := header.Size; remaining > 0; {
+		shards := make([][]byte, len(readers))
+		for i, r := range readers {
+			if damaged[i] {
+				continue
+			}
+			if shards[i] = readBlock(r); shards[i] == nil {
+				damaged[i] = true
+				_ = r.CloseWithError(io.ErrClosedPipe)
+			}
+		}
+		if len(damaged) > e.Parity {
+			return header, damaged, fmt.Errorf("%s has %d damaged shards: %w", name, len(damaged), ErrNotEnoughShards)
+		}
+		if len(damaged) > 0 {
+			if err = e.enc.ReconstructData(shards); err != nil {
+				return header, damaged, err
+			}
+		}
+
+		l := int64(len(shards[0]) * e.Data)
+		if remaining < l {
+			l = remaining
+		}
+		if w != nil {
+			if err = e.enc.Join(w, shards, int(l)); err != nil {
+				return header, damaged, err
+			}
+		}
+		remaining -= l
+	}
+	return header, damaged, nil
+}
+
+// size returns the size of name as recorded in the header of the shards of the current generation
+func (e *ErasureCoded) size(name string) (int64, bool) {
+	headers := make([]*erasureHeader, len(e.Shards))
+	for i, s := range e.Shards {
+		bs, _ := Peek(s, name, erasureHeaderSize)
+		h, err := decodeErasureHeader(bs)
+		if err == nil && h.Index == i {
+			headers[i] = &h
+		}
+	}
+	h, found := e.currentGeneration(headers)
+	return h.Size, found > 0
+}
+
+// ScrubReport is the outcome of Scrub
+type ScrubReport struct {
+	Checked  int
+	Repaired []string
+	Failed   map[string]error
+}
+
+// Scrub verifies all the files in name and its subfolders and re-creates the shards that are lost or damaged
+func (e *ErasureCoded) Scrub(name string) (ScrubReport, error) {
+	report := ScrubReport{Failed: map[string]error{}}
+	err := Walk(e, name, IncludeHiddenFiles, func(dir string, file fs.FileInfo) {
+		n := path.Join(dir, file.Name())
+		report.Checked++
+		repaired, err := e.repair(n)
+		switch {
+		case err != nil:
+			report.Failed[n] = err
+			logrus.Errorf("cannot repair %s: %v", n, err)
+		case repaired:
+			report.Repaired = append(report.Repaired, n)
+			logrus.Infof("shards of %s repaired", n)
+		}
+	})
+	return report, err
+}
+
+func (e *ErasureCoded) repair(name string) (bool, error) {
+	header, damaged, err := e.decode(name, nil)
+	if err != nil || len(damaged) == 0 {
+		return false, err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		_, _, err := e.decode(name, pw)
+		_ = pw.CloseWithError(err)
+	}()
+	_, err = e.pushShards(name, pr, damaged, header.Generation)
+	_ = pr.Close()
+	return err == nil, err
+}
+
+// fanOut applies op to all the stores. Missing files are not considered an error unless missing everywhere
+func (e *ErasureCoded) fanOut(op func(f FS) error) error {
+	var me *multierror.Error
+	missing := 0
+	for _, s := range e.Shards {
+		err := op(s)
+		switch {
+		case os.IsNotExist(err):
+			missing++
+		case err != nil:
+			me = multierror.Append(me, err)
+		}
+	}
+	if missing == len(e.Shards) {
+		return os.ErrNotExist
+	}
+	return me.ErrorOrNil()
+}
+
+func (e *ErasureCoded) Props() Props {
+	return e.Shards[0].Props()
+}
+
+func (e *ErasureCoded) ReadDir(name string, opts ListOption) ([]fs.FileInfo, error) {
+	entries := map[string]fs.FileInfo{}
+	var found bool
+	var lastErr error
+	for _, s := range e.Shards {
+		ls, err := s.ReadDir(name, opts)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		found = true
+		for _, l := range ls {
+			if _, ok := entries[l.Name()]; !ok {
+				entries[l.Name()] = l
+			}
+		}
+	}
+	if !found {
+		return nil, lastErr
+	}
+
+	var fis []fs.FileInfo
+	for n, l := range entries {
+		if !l.IsDir() {
+			size, ok := e.size(path.Join(name, n))
+			if !ok {
+				continue
+			}
+			l = logicalFileInfo{l, size}
+		}
+		fis = append(fis, l)
+	}
+	sort.Slice(fis, func(i, j int) bool {
+		return fis[i].Name() < fis[j].Name()
+	})
+	return fis, nil
+}
+
+func (e *ErasureCoded) Stat(name string) (fs.FileInfo, error) {
+	var lastErr error = os.ErrNotExist
+	for _, s := range e.Shards {
+		l, err := s.Stat(name)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if l.IsDir() {
+			return l, nil
+		}
+		if size, ok := e.size(name); ok {
+			return logicalFileInfo{l, size}, nil
+		}
+		return nil, os.ErrNotExist
+	}
+	return nil, lastErr
+}
+
+func (e *ErasureCoded) Remove(name string) error {
+	return e.fanOut(func(f FS) error {
+		return f.Remove(name)
+	})
+}
+
+func (e *ErasureCoded) Touch(name string) error {
+	return e.fanOut(func(f FS) error {
+		return f.Touch(name)
+	})
+}
+
+func (e *ErasureCoded) Watch(name string) chan string {
+	return e.Shards[0].Watch(name)
+}
+
+func (e *ErasureCoded) Rename(old, new string) error {
+	return e.fanOut(func(f FS) error {
+		return f.Rename(old, new)
+	})
+}
+
+func (e *ErasureCoded) MkdirAll(name string) error {
+	return e.fanOut(func(f FS) error {
+		return f.MkdirAll(name)
+	})
+}
+
+func (e *ErasureCoded) Pull(name string, w io.Writer) error {
+	_, damaged, err := e.decode(name, w)
+	if err == nil && len(damaged) > 0 {
+		logrus.Warnf("%s reconstructed from %d damaged shards", name, len(damaged))
+	}
+	return err
+}
+
+// Push succeeds when at least the data shards are written. Missing shards are re-created by Scrub
+func (e *ErasureCoded) Push(name string, r io.Reader) error {
+	written, err := e.pushShards(name, r, nil, time.Now().UnixNano())
+	if written < e.Data {
+		return fmt.Errorf("only %d shards of %s written: %w", written, name, err)
+	}
+	if err != nil {
+		logrus.Warnf("%s written with %d shards out of %d: %v", name, written, e.Data+e.Parity, err)
+	}
+	return nil
+}
+
+func (e *ErasureCoded) Close() error {
+	var me *multierror.Error
+	for _, s := range e.Shards {
+		me = multierror.Append(me, s.Close())
+	}
+	return me.ErrorOrNil()
+}
+
+func (e *ErasureCoded) String() string {
+	var names []string
+	for _, s := range e.Shards {
+		names = append(names, s.String())
+	}
+	return fmt.Sprintf("erasure%d+%d(%s)", e.Data, e.Parity, strings.Join(names, "|"))
+}
@@ -0,0 +1,59 @@
+package store
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"testing"
+)
+
+func TestErasureCoded(t *testing.T) {
+	shards := []FS{NewMemory(nil, 0), NewMemory(nil, 0), NewMemory(nil, 0)}
+	e, err := NewErasureCoded(shards, 2, 1)
+	assert.NoError(t, err)
+
+	data := make([]byte, 3*erasureBlockSize+1234)
+	rand.New(rand.NewSource(1)).Read(data)
+	name := "data/erasure.bin"
+	assert.NoError(t, e.Push(name, bytes.NewReader(data)))
+
+	l, err := e.Stat(name)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(data)), l.Size())
+
+	assert.NoError(t, shards[0].Remove(name))
+	w := &ByteStream{}
+	assert.NoError(t, e.Pull(name, w))
+	assert.Equal(t, data, w.Data)
+
+	report, err := e.(*ErasureCoded).Scrub("")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Checked)
+	assert.Equal(t, []string{name}, report.Repaired)
+	_, err = shards[0].Stat(name)
+	assert.NoError(t, err)
+
+	shard, _ := ReadFile(shards[1], name)
+	shard[len(shard)-1] ^= 0xff
+	assert.NoError(t, WriteFile(shards[1], name, shard))
+	w = &ByteStream{}
+	assert.NoError(t, e.Pull(name, w))
+	assert.Equal(t, data, w.Data)
+
+	// a shard of a previous write with the same size is not mixed with the current ones
+	stale, _ := ReadFile(shards[0], name)
+	update := make([]byte, len(data))
+	rand.New(rand.NewSource(2)).Read(update)
+	assert.NoError(t, e.Push(name, bytes.NewReader(update)))
+	assert.NoError(t, WriteFile(shards[0], name, stale))
+	w = &ByteStream{}
+	assert.NoError(t, e.Pull(name, w))
+	assert.Equal(t, update, w.Data)
+	report, err = e.(*ErasureCoded).Scrub("")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{name}, report.Repaired)
+
+	assert.NoError(t, shards[1].Remove(name))
+	assert.NoError(t, shards[2].Remove(name))
+	assert.ErrorIs(t, e.Pull(name, &ByteStream{}), ErrNotEnoughShards)
+}
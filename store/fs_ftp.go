@@ -2,11 +2,13 @@ package store
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"github.com/jlaffaye/ftp"
 	"io"
 	"io/fs"
 	"math"
+	"net/textproto"
 	"os"
 	"path"
 	"strings"
@@ -16,17 +18,19 @@ import (
 type FTPConfig struct {
 	Addr     string        `json:"addr" yaml:"addr"`
 	Username string        `json:"username" yaml:"username"`
-	Password string        `json:"password" yaml:"password"`
+	Password string        `json:"password" yaml:"password" secret:"true"`
 	Base     string        `json:"base" yaml:"base"`
 	Timeout  time.Duration `json:"timeout" yaml:"timeout"`
+	// PoolSize is the number of concurrent sessions to the server. Default is 1
+	PoolSize int `json:"poolSize" yaml:"poolSize"`
 }
 
 type FTP struct {
-	c   *ftp.ServerConn
-	url string
+	pool *connPool
+	url  string
 }
 
-func NewFTP(config FTPConfig) (FS, error) {
+func dialFTP(config FTPConfig) (*ftp.ServerConn, error) {
 	var addr = config.Addr
 	if !strings.ContainsRune(addr, ':') {
 		addr = fmt.Sprintf("%s:21", addr)
@@ -37,11 +41,36 @@ func NewFTP(config FTPConfig) (FS, error) {
 	}
 
 	if err = c.Login(config.Username, config.Password); err != nil {
+		_ = c.Quit()
 		return nil, err
 	}
+	return c, nil
+}
 
+func NewFTP(config FTPConfig) (FS, error) {
 	url := fmt.Sprintf("ftp://%s@%s/%s", config.Username, config.Addr, config.Base)
-	return &FTP{c, url}, nil
+	pool := newConnPool(url, config.PoolSize,
+		func() (interface{}, error) {
+			return dialFTP(config)
+		},
+		func(c interface{}) error {
+			return c.(*ftp.ServerConn).NoOp()
+		},
+		func(c interface{}) error {
+			return c.(*ftp.ServerConn).Quit()
+		})
+	if err := pool.connect(); err != nil {
+		return nil, err
+	}
+
+	return &FTP{pool, url}, nil
+}
+
+// with runs op on a session of the pool. See connPool.do for retry
+func (f *FTP) with(op func(c *ftp.ServerConn) error, retry bool) error {
+	return f.pool.do(func(c interface{}) error {
+		return op(c.(*ftp.ServerConn))
+	}, retry)
 }
 
 func (f *FTP) Props() Props {
@@ -54,54 +83,68 @@ func (f *FTP) Props() Props {
 }
 
 func (f *FTP) MkdirAll(name string) error {
-	if _, err := f.c.List(name); err == nil {
+	return f.with(func(c *ftp.ServerConn) error {
+		return ftpMkdirAll(c, name)
+	}, true)
+}
+
+func ftpMkdirAll(c *ftp.ServerConn, name string) error {
+	if _, err := c.List(name); err == nil {
 		return nil
 	}
 
 	p := ""
 	for _, s := range strings.Split(name, "/") {
 		p = path.Join(p, s)
-		_ = f.c.MakeDir(p)
+		_ = c.MakeDir(p)
 	}
 
-	_, err := f.c.List(name)
+	_, err := c.List(name)
 	return err
 }
 
-func (f *FTP) mkParent(name string) error {
+func ftpMkParent(c *ftp.ServerConn, name string) error {
 	dir := path.Dir(name)
 	if dir == "" {
 		return nil
 	}
-	return f.MkdirAll(dir)
+	return ftpMkdirAll(c, dir)
 }
 
 func (f FTP) Pull(name string, w io.Writer) error {
-	r, err := f.c.Retr(name)
-	if err != nil {
-		return err
-	}
-	defer r.Close()
+	return f.with(func(c *ftp.ServerConn) error {
+		r, err := c.Retr(name)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
 
-	_, err = io.Copy(w, r)
-	return err
+		_, err = io.Copy(w, r)
+		return err
+	}, false)
 }
 
 func (f FTP) Push(name string, r io.Reader) error {
-	err := f.mkParent(name)
-	if err != nil {
-		return err
-	}
-	err = f.Touch(name)
-	if err != nil {
-		return err
-	}
+	return f.with(func(c *ftp.ServerConn) error {
+		err := ftpMkParent(c, name)
+		if err != nil {
+			return err
+		}
+		err = ftpTouch(c, name)
+		if err != nil {
+			return err
+		}
 
-	return f.c.Stor(name, r)
+		return c.Stor(name, r)
+	}, false)
 }
 
 func (f *FTP) ReadDir(name string, opts ListOption) ([]fs.FileInfo, error) {
-	entries, err := f.c.List(name)
+	var entries []*ftp.Entry
+	err := f.with(func(c *ftp.ServerConn) (err error) {
+		entries, err = c.List(name)
+		return err
+	}, true)
 	if err != nil {
 		return nil, err
 	}
@@ -128,8 +171,31 @@ func (f *FTP) Watch(name string) chan string {
 	return nil
 }
 
+// ftpNotFound returns true when err is the reply of the server for a missing file
+func ftpNotFound(err error) bool {
+	var reply *textproto.Error
+	if !errors.As(err, &reply) {
+		return false
+	}
+	msg := strings.ToLower(reply.Msg)
+	return reply.Code == ftp.StatusFileUnavailable ||
+		strings.Contains(msg, "no such file") || strings.Contains(msg, "not found")
+}
+
 func (f *FTP) Stat(name string) (fs.FileInfo, error) {
-	entries, _ := f.c.List(name)
+	var entries []*ftp.Entry
+	err := f.with(func(c *ftp.ServerConn) (err error) {
+		entries, err = c.List(name)
+		if ftpNotFound(err) {
+			// a missing file is reported as an error by some servers
+			return nil
+		}
+		return err
+	}, true)
+	if err != nil {
+		return nil, err
+	}
+
 	switch len(entries) {
 	case 0:
 		return nil, os.ErrNotExist
@@ -146,24 +212,34 @@ func (f *FTP) Stat(name string) (fs.FileInfo, error) {
 }
 
 func (f *FTP) Remove(name string) error {
-	return f.c.Delete(name)
+	return f.with(func(c *ftp.ServerConn) error {
+		return c.Delete(name)
+	}, true)
 }
 
-func (f *FTP) Touch(name string) error {
-	s, err := f.c.FileSize(name)
+func ftpTouch(c *ftp.ServerConn, name string) error {
+	s, err := c.FileSize(name)
 	if err != nil {
 		return err
 	}
-	return f.c.StorFrom(name, bytes.NewReader(nil), uint64(s))
+	return c.StorFrom(name, bytes.NewReader(nil), uint64(s))
+}
+
+func (f *FTP) Touch(name string) error {
+	return f.with(func(c *ftp.ServerConn) error {
+		return ftpTouch(c, name)
+	}, true)
 }
 
 func (f *FTP) Rename(old, new string) error {
-	_ = f.mkParent(new)
-	return f.c.Rename(old, new)
+	return f.with(func(c *ftp.ServerConn) error {
+		_ = ftpMkParent(c, new)
+		return c.Rename(old, new)
+	}, true)
 }
 
 func (f *FTP) Close() error {
-	return f.c.Quit()
+	return f.pool.closeAll()
 }
 
 func (f *FTP) String() string {
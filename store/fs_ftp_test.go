@@ -0,0 +1,19 @@
+package store
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/textproto"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestFTPNotFound(t *testing.T) {
+	assert.True(t, ftpNotFound(&textproto.Error{Code: 550, Msg: "Can't check for file existence"}))
+	assert.True(t, ftpNotFound(fmt.Errorf("list: %w", &textproto.Error{Code: 450, Msg: "No such file or directory"})))
+	assert.False(t, ftpNotFound(&textproto.Error{Code: 530, Msg: "Login incorrect"}))
+	assert.False(t, ftpNotFound(&textproto.Error{Code: 421, Msg: "Too many connections"}))
+	assert.False(t, ftpNotFound(&os.SyscallError{Syscall: "read", Err: syscall.ECONNRESET}))
+	assert.False(t, ftpNotFound(nil))
+}
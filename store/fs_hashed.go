@@ -0,0 +1,288 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"github.com/hashicorp/go-multierror"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"time"
+)
+
+var ErrCorrupted = errors.New("content does not match the recorded hash")
+
+type HashConfig struct {
+	// Algorithms are the hashes recorded for each file: sha256 (default), blake3, crc64 or any registered hash
+	Algorithms []string `json:"algorithms" yaml:"algorithms"`
+}
+
+// NativeChecksum is implemented by the backends that keep a checksum of the content of each file
+type NativeChecksum interface {
+	// Checksum returns the algorithm and the hex encoded checksum of name. It returns ErrNotSupported
+	// when the backend has no checksum for the file
+	Checksum(name string) (algorithm string, sum string, err error)
+}
+
+// nativeChecksum returns the backend of f when it keeps checksums and the decorators in between do not
+// change the content
+func nativeChecksum(f FS) (NativeChecksum, bool) {
+	for f != nil {
+		if n, ok := f.(NativeChecksum); ok {
+			return n, true
+		}
+		switch f.(type) {
+		case *Retry, *BWLimit, *Metrics, *MetaAware, *Hashed, *Trash, *Versioned, *WORM, *ReadOnly:
+			f = Unwrap(f)
+		default:
+			return nil, false
+		}
+	}
+	return nil, false
+}
+
+// Hashed records the hashes of each file in its Attr while the file is pushed and checks them while the
+// file is pulled. Since the data is streamed, a corrupted file has already been written to the destination
+// when Pull returns ErrCorrupted
+type Hashed struct {
+	F          FS
+	Algorithms []string
+}
+
+func NewHashed(f FS, config HashConfig) (FS, error) {
+	if len(config.Algorithms) == 0 {
+		config.Algorithms = []string{HashSHA256}
+	}
+	if _, err := NewHashSet(config.Algorithms...); err != nil {
+		return nil, err
+	}
+	return &Hashed{f, config.Algorithms}, nil
+}
+
+func (h *Hashed) record(name string, sums map[string]string) error {
+	return UpdateAttr(h.F, name, name, func(attr Attr) Attr {
+		attr.Hashes = sums
+		attr.HashTime = time.Now()
+		return attr
+	})
+}
+
+func (h *Hashed) Props() Props {
+	return h.F.Props()
+}
+
+func (h *Hashed) ReadDir(name string, opts ListOption) ([]fs.FileInfo, error) {
+	return h.F.ReadDir(name, opts)
+}
+
+func (h *Hashed) Watch(name string) chan string {
+	return h.F.Watch(name)
+}
+
+func (h *Hashed) Stat(name string) (fs.FileInfo, error) {
+	return h.F.Stat(name)
+}
+
+func (h *Hashed) Remove(name string) error {
+	return h.F.Remove(name)
+}
+
+func (h *Hashed) Touch(name string) error {
+	return h.F.Touch(name)
+}
+
+func (h *Hashed) Rename(old, new string) error {
+	return h.F.Rename(old, new)
+}
+
+func (h *Hashed) MkdirAll(name string) error {
+	return h.F.MkdirAll(name)
+}
+
+// Pull checks the content against the recorded hashes. Files without hashes, e.g. written outside Hashed,
+// are not checked; Verify with record gives them their hashes
+func (h *Hashed) Pull(name string, w io.Writer) error {
+	if IsMeta(name) {
+		return h.F.Pull(name, w)
+	}
+
+	var attr Attr
+	_ = GetMeta(h.F, name, &attr)
+	recorded := knownHashes(attr.Hashes)
+	if len(recorded) == 0 {
+		return h.F.Pull(name, w)
+	}
+	var algorithms []string
+	for a := range recorded {
+		algorithms = append(algorithms, a)
+	}
+	hs, err := NewHashSet(algorithms...)
+	if err != nil {
+		return err
+	}
+	if err := h.F.Pull(name, io.MultiWriter(w, hs)); err != nil {
+		return err
+	}
+	return compareHashes(name, recorded, hs.Sums())
+}
+
+// Push records the hashes of the content in the Attr of the file
+func (h *Hashed) Push(name string, r io.Reader) error {
+	if IsMeta(name) {
+		return h.F.Push(name, r)
+	}
+
+	hs, err := NewHashSet(h.Algorithms...)
+	if err != nil {
+		return err
+	}
+	if err := h.F.Push(name, io.TeeReader(r, hs)); err != nil {
+		return err
+	}
+	return h.record(name, hs.Sums())
+}
+
+func (h *Hashed) Close() error {
+	return h.F.Close()
+}
+
+func (h *Hashed) String() string {
+	return fmt.Sprintf("%s#hash", h.F)
+}
+
+// knownHashes returns the recorded hashes whose algorithm is registered
+func knownHashes(sums map[string]string) map[string]string {
+	known := map[string]string{}
+	for a, s := range sums {
+		if _, err := NewHash(a); err == nil {
+			known[a] = s
+		}
+	}
+	return known
+}
+
+func compareHashes(name string, expected, actual map[string]string) error {
+	for a, e := range expected {
+		if s, ok := actual[a]; ok && s != e {
+			return fmt.Errorf("%s has %s %s instead of %s: %w", name, a, s, e, ErrCorrupted)
+		}
+	}
+	return nil
+}
+
+// VerifyReport lists the results of Verify
+type VerifyReport struct {
+	// Verified is the number of files that match their hashes
+	Verified int
+	// Corrupted are the files that do not match their hashes
+	Corrupted []string
+	// Unhashed are the files without recorded hashes or backend checksum
+	Unhashed []string
+	// Recorded is the number of files that got their hashes
+	Recorded int
+}
+
+// Verify reads the files in the folder name and its subfolders and compares their content with the hashes
+// recorded in their Attr and with the checksums kept by the backend. With record, the files without
+// recorded hashes get them, with the algorithms of the Hashed decorator of f or sha256
+func Verify(f FS, name string, record bool) (VerifyReport, error) {
+	var report VerifyReport
+	var files []string
+	err := Walk(f, name, 0, func(dir string, file fs.FileInfo) {
+		if !IsMeta(file.Name()) {
+			files = append(files, path.Join(dir, file.Name()))
+		}
+	})
+	if err != nil {
+		return report, err
+	}
+
+	var algorithms []string
+	if record {
+		algorithms = []string{HashSHA256}
+		if h, ok := Find[*Hashed](f); ok {
+			algorithms = h.Algorithms
+		}
+	}
+
+	var me *multierror.Error
+	for _, file := range files {
+		hashed, recorded, err := verifyFile(f, file, algorithms)
+		if recorded {
+			report.Recorded++
+		}
+		switch {
+		case errors.Is(err, ErrCorrupted):
+			report.Corrupted = append(report.Corrupted, file)
+		case err != nil:
+			me = multierror.Append(me, err)
+		case !hashed && recorded:
+			// there was nothing to compare with, the next verify will
+		case !hashed:
+			report.Unhashed = append(report.Unhashed, file)
+		default:
+			report.Verified++
+		}
+	}
+	sort.Strings(report.Corrupted)
+	sort.Strings(report.Unhashed)
+	return report, me.ErrorOrNil()
+}
+
+// verifyFile reads name once and compares it with all the hashes available for it. It returns false when
+// there is no hash to compare with. When name has no recorded hashes and record lists some algorithms,
+// their hashes are recorded unless the content does not match the backend checksum
+func verifyFile(f FS, name string, record []string) (hashed bool, recorded bool, err error) {
+	var attr Attr
+	_ = GetMeta(f, name, &attr)
+	expected := knownHashes(attr.Hashes)
+	if len(expected) > 0 {
+		record = nil
+	}
+
+	if n, found := nativeChecksum(f); found {
+		a, sum, err := n.Checksum(name)
+		switch {
+		case err == nil:
+			if _, err := NewHash(a); err == nil {
+				expected[a] = sum
+			}
+		case !errors.Is(err, ErrNotSupported):
+			return false, false, err
+		}
+	}
+	if len(expected) == 0 && len(record) == 0 {
+		return false, false, nil
+	}
+
+	algorithms := append([]string{}, record...)
+	for a := range expected {
+		algorithms = append(algorithms, a)
+	}
+	hs, err := NewHashSet(algorithms...)
+	if err != nil {
+		return true, false, err
+	}
+	if err := f.Pull(name, hs); err != nil {
+		return true, false, err
+	}
+	sums := hs.Sums()
+	if err := compareHashes(name, expected, sums); err != nil {
+		return true, false, err
+	}
+	if len(record) == 0 {
+		return true, false, nil
+	}
+
+	recordedSums := map[string]string{}
+	for _, a := range record {
+		recordedSums[a] = sums[a]
+	}
+	err = UpdateAttr(f, name, name, func(attr Attr) Attr {
+		attr.Hashes = recordedSums
+		attr.HashTime = time.Now()
+		return attr
+	})
+	return len(expected) > 0, err == nil, err
+}
@@ -0,0 +1,77 @@
+package store
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHashed(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "stg/test/hashed")
+	_ = os.RemoveAll(dir)
+	_ = os.MkdirAll(dir, 0755)
+	l := NewLocalMount(dir)
+
+	_, err := NewHashed(l, HashConfig{Algorithms: []string{"md4"}})
+	assert.ErrorIs(t, err, ErrNotSupported)
+
+	f, err := NewHashed(l, HashConfig{Algorithms: []string{HashSHA256, HashBLAKE3, HashCRC64}})
+	assert.NoError(t, err)
+
+	data := []byte("the content of a file")
+	assert.NoError(t, f.Push("a/x", bytes.NewReader(data)))
+	var attr Attr
+	assert.NoError(t, GetMeta(l, "a/x", &attr))
+	assert.Len(t, attr.Hashes, 3)
+	sum := sha256.Sum256(data)
+	assert.Equal(t, hex.EncodeToString(sum[:]), attr.Hashes[HashSHA256])
+
+	// the recorded crc64 stands for the content until the file changes
+	st, err := l.Stat("a/x")
+	assert.NoError(t, err)
+	crc, ok := attr.RecordedCRC64(st.ModTime())
+	assert.True(t, ok)
+	assert.Equal(t, CalculateCRC64(l, "a/x"), crc)
+	_, ok = attr.RecordedCRC64(attr.HashTime.Add(time.Second))
+	assert.False(t, ok)
+
+	buf := new(bytes.Buffer)
+	assert.NoError(t, f.Pull("a/x", buf))
+	assert.Equal(t, data, buf.Bytes())
+
+	// a file written outside is not hashed by a pull, only by verify with record
+	assert.NoError(t, l.Push("a/y", bytes.NewReader(data)))
+	report, err := Verify(f, "", false)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Verified)
+	assert.Equal(t, []string{"a/y"}, report.Unhashed)
+	assert.NoError(t, f.Pull("a/y", new(bytes.Buffer)))
+	attr = Attr{}
+	_ = GetMeta(l, "a/y", &attr)
+	assert.Empty(t, attr.Hashes)
+	ro, err := NewHashed(NewReadOnly(l), HashConfig{})
+	assert.NoError(t, err)
+	assert.NoError(t, ro.Pull("a/y", new(bytes.Buffer)))
+	report, err = Verify(f, "", true)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Verified)
+	assert.Equal(t, 1, report.Recorded)
+	assert.Empty(t, report.Unhashed)
+	assert.NoError(t, GetMeta(l, "a/y", &attr))
+	assert.Len(t, attr.Hashes, 3)
+
+	// bit rot
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "a/x"), []byte("the content of a fil3"), 0644))
+	assert.ErrorIs(t, f.Pull("a/x", new(bytes.Buffer)), ErrCorrupted)
+	report, err = Verify(f, "", true)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Verified)
+	assert.Equal(t, []string{"a/x"}, report.Corrupted)
+	assert.Zero(t, report.Recorded)
+	assert.Empty(t, report.Unhashed)
+}
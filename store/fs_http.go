@@ -19,8 +19,8 @@ import (
 type HTTPConfig struct {
 	Endpoint  string `json:"endpoint" yaml:"endpoint"`
 	AccessKey string `json:"accessKey" yaml:"accessKey"`
-	Secret    string `json:"secret" yaml:"secret"`
-	SignKey   string `json:"signKey" yaml:"signKey"`
+	Secret    string `json:"secret" yaml:"secret" secret:"true"`
+	SignKey   string `json:"signKey" yaml:"signKey" secret:"true"`
 }
 
 type HTTP struct {
@@ -55,6 +55,22 @@ type CustomClaims struct {
 	jwt.StandardClaims
 }
 
+// ParseBearer validates the bearer token in the Authorization header of req and returns its claims
+func ParseBearer(req *http.Request, signKey []byte) (*CustomClaims, error) {
+	bearer := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	claims := &CustomClaims{}
+	token, err := jwt.ParseWithClaims(bearer, claims, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
+		}
+		return signKey, nil
+	})
+	if err != nil || !token.Valid {
+		return nil, fmt.Errorf("invalid bearer: %v: %w", err, os.ErrPermission)
+	}
+	return claims, nil
+}
+
 func (h *HTTP) getBearer() (string, error) {
 	if time.Now().Before(h.exp) {
 		return h.bearer, nil
@@ -1,13 +1,18 @@
 package store
 
 import (
+	"errors"
 	"fmt"
+	"github.com/patrickmn/go-cache"
 	"io/fs"
 	"net/http"
 	"os"
 	"time"
 )
 
+// httpUserExpiration is how long the server keeps the store of a user after the last request
+const httpUserExpiration = 10 * time.Minute
+
 type HTTPServer struct {
 	Get    func(w http.ResponseWriter, r *http.Request)
 	Put    func(w http.ResponseWriter, r *http.Request)
@@ -18,8 +23,10 @@ type HTTPServer struct {
 func getFileInfo(f FS, ph string, w http.ResponseWriter) (fs.FileInfo, error) {
 	l, err := f.Stat(ph)
 	if err != nil {
-		if os.IsNotExist(err) {
+		if errors.Is(err, os.ErrNotExist) {
 			w.WriteHeader(http.StatusNotFound)
+		} else if errors.Is(err, os.ErrPermission) {
+			w.WriteHeader(http.StatusForbidden)
 		} else {
 			w.WriteHeader(http.StatusInternalServerError)
 		}
@@ -27,9 +34,50 @@ func getFileInfo(f FS, ph string, w http.ResponseWriter) (fs.FileInfo, error) {
 	return l, err
 }
 
+func writeError(w http.ResponseWriter, err error) {
+	if errors.Is(err, os.ErrPermission) {
+		w.WriteHeader(http.StatusForbidden)
+	} else {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	w.Write([]byte(err.Error()))
+}
+
 func NewHttpServer(f FS, prefix string) (HTTPServer, error) {
+	return newHttpServer(prefix, func(w http.ResponseWriter, r *http.Request) FS {
+		return f
+	}), nil
+}
+
+// NewHttpServerWithPolicy serves f to the users authenticated by a bearer token signed with signKey.
+// Each request runs with the permissions that the policy grants to its user. The stores of the users are
+// dropped after httpUserExpiration without requests
+func NewHttpServerWithPolicy(f FS, prefix string, policy *Policy, signKey []byte) (HTTPServer, error) {
+	users := cache.New(httpUserExpiration, 2*httpUserExpiration)
+	return newHttpServer(prefix, func(w http.ResponseWriter, r *http.Request) FS {
+		claims, err := ParseBearer(r, signKey)
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return nil
+		}
+		a, ok := users.Get(claims.Id)
+		if !ok {
+			a = NewAccess(f, policy, claims.Id, time.Minute)
+		}
+		users.SetDefault(claims.Id, a)
+		return a.(FS)
+	}), nil
+}
+
+// newHttpServer creates the handlers. fsFor returns the file storage for a request, or nil when it
+// already replied with an error
+func newHttpServer(prefix string, fsFor func(w http.ResponseWriter, r *http.Request) FS) HTTPServer {
 	return HTTPServer{
 		Get: func(w http.ResponseWriter, r *http.Request) {
+			f := fsFor(w, r)
+			if f == nil {
+				return
+			}
 			ph := r.URL.Path[len(prefix):]
 			l, err := getFileInfo(f, ph, w)
 			if err != nil {
@@ -62,6 +110,10 @@ func NewHttpServer(f FS, prefix string) (HTTPServer, error) {
 			}
 		},
 		Head: func(w http.ResponseWriter, r *http.Request) {
+			f := fsFor(w, r)
+			if f == nil {
+				return
+			}
 			ph := r.URL.Path[len(prefix):]
 			l, err := getFileInfo(f, ph, w)
 			if err != nil {
@@ -77,6 +129,10 @@ func NewHttpServer(f FS, prefix string) (HTTPServer, error) {
 			w.Header().Add("Last-Modified", l.ModTime().Format(time.RFC822))
 		},
 		Put: func(w http.ResponseWriter, r *http.Request) {
+			f := fsFor(w, r)
+			if f == nil {
+				return
+			}
 			ph := r.URL.Path[len(prefix):]
 			var err error
 			if r.URL.RawQuery == "dir" {
@@ -88,19 +144,21 @@ func NewHttpServer(f FS, prefix string) (HTTPServer, error) {
 			if err == nil {
 				w.WriteHeader(http.StatusOK)
 			} else {
-				w.WriteHeader(http.StatusInternalServerError)
-				w.Write([]byte(err.Error()))
+				writeError(w, err)
 			}
 		},
 		Delete: func(w http.ResponseWriter, r *http.Request) {
+			f := fsFor(w, r)
+			if f == nil {
+				return
+			}
 			ph := r.URL.Path[len(prefix):]
 			err := f.Remove(ph)
 			if err == nil {
 				w.WriteHeader(http.StatusOK)
 			} else {
-				w.WriteHeader(http.StatusInternalServerError)
-				w.Write([]byte(err.Error()))
+				writeError(w, err)
 			}
 		},
-	}, nil
+	}
 }
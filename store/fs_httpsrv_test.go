@@ -0,0 +1,58 @@
+package store
+
+import (
+	"bytes"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHttpServerWithPolicy(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "stg/test/httpsrv")
+	_ = os.RemoveAll(dir)
+	_ = os.MkdirAll(dir, 0755)
+	l := NewLocalMount(dir)
+
+	policy := &Policy{
+		Groups: map[Group][]string{"dev": {"alice", "bob"}},
+		Rules: []AccessRule{
+			{Path: "docs", Principals: []string{"@dev"}, Allow: []Permission{PermRead, PermWrite, PermList}},
+		},
+	}
+	signKey := []byte("secret")
+	srv, err := NewHttpServerWithPolicy(l, "/files/", policy, signKey)
+	assert.NoError(t, err)
+
+	do := func(handler func(http.ResponseWriter, *http.Request), method, url, user, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, bytes.NewBufferString(body))
+		if user != "" {
+			claims := &CustomClaims{Id: user, StandardClaims: jwt.StandardClaims{ExpiresAt: time.Now().Add(time.Hour).Unix()}}
+			bearer, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(signKey)
+			assert.NoError(t, err)
+			req.Header.Set("Authorization", "Bearer "+bearer)
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, do(srv.Put, "PUT", "/files/docs/a.txt", "", "a").Code)
+	assert.Equal(t, http.StatusOK, do(srv.Put, "PUT", "/files/docs/a.txt", "alice", "a").Code)
+	assert.Equal(t, http.StatusForbidden, do(srv.Put, "PUT", "/files/docs/b.txt", "carol", "b").Code)
+	assert.Equal(t, http.StatusForbidden, do(srv.Delete, "DELETE", "/files/docs/a.txt", "bob", "").Code)
+
+	rec := do(srv.Get, "GET", "/files/docs/a.txt", "bob", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "a", rec.Body.String())
+
+	assert.Equal(t, http.StatusOK, do(srv.Put, "PUT", "/files/docs/sub?dir", "bob", "").Code)
+	var attr Attr
+	assert.NoError(t, GetMeta(l, "docs/sub", &attr))
+	assert.Equal(t, Group("dev"), attr.Group)
+	assert.Equal(t, "bob", attr.ModifiedBy)
+}
@@ -37,9 +37,10 @@ type kafkaTopic struct {
 }
 
 type KafkaFS struct {
-	config KafkaConfig
-	c      *kafka.Conn
-	ch     *cache.Cache
+	config  KafkaConfig
+	c       *kafka.Conn
+	ch      *cache.Cache
+	pending *cache.Cache
 }
 
 func NewKafka(config KafkaConfig) (FS, error) {
@@ -48,7 +49,8 @@ func NewKafka(config KafkaConfig) (FS, error) {
 		return nil, err
 	}
 
-	f := &KafkaFS{config, c, cache.New(kafkaCacheExpiration, kafkaCacheExpiration)}
+	f := &KafkaFS{config: config, c: c, ch: cache.New(kafkaCacheExpiration, kafkaCacheExpiration),
+		pending: cache.New(kafkaCacheExpiration, kafkaCacheExpiration)}
 	if f.config.MaxLs == 0 {
 		f.config.MaxLs = 256
 	}
@@ -155,10 +157,17 @@ func (ka *KafkaFS) Push(name string, r io.Reader) error {
 				Value: buf.Bytes(),
 			})
 	} else {
+		// the meta written before the message goes in its headers
+		var headers []kafka.Header
+		if data, ok := ka.pending.Get(name); ok {
+			headers = append(headers, kafka.Header{Key: kafkaMetaHeader, Value: data.([]byte)})
+			ka.pending.Delete(name)
+		}
 		return v.w.WriteMessages(ctx,
 			kafka.Message{
-				Key:   []byte(key),
-				Value: buf.Bytes(),
+				Key:     []byte(key),
+				Value:   buf.Bytes(),
+				Headers: headers,
 			})
 	}
 }
@@ -349,12 +358,83 @@ func (ka *KafkaFS) Rename(old, new string) error {
 		return ErrNotSupported
 	}
 
-	buf := bytes.NewBuffer(nil)
-	err := ka.Pull(old, buf)
+	// the headers, and so the meta, move with the message
+	m, err := ka.message(old)
+	if err != nil {
+		return err
+	}
+	if err = ka.Pull(old, nil); err != nil {
+		return err
+	}
+	return ka.produce(new, m.Value, m.Headers)
+}
+
+// kafkaMetaHeader is the message header that keeps the meta
+const kafkaMetaHeader = "bbfs-meta"
+
+func (ka *KafkaFS) message(name string) (kafka.Message, error) {
+	topicName, key := path.Split(name)
+	v, err := ka.getKafkaTopic(topicName)
+	if err != nil {
+		return kafka.Message{}, err
+	}
+	_ = ka.fetchKafkaMessages(v)
+	m, ok := v.messages[key]
+	if !ok {
+		return kafka.Message{}, fs.ErrNotExist
+	}
+	return m, nil
+}
+
+func (ka *KafkaFS) produce(name string, value []byte, headers []kafka.Header) error {
+	topicName, key := path.Split(name)
+	v, err := ka.getKafkaTopic(topicName)
 	if err != nil {
 		return err
 	}
-	return ka.Push(new, buf)
+	return v.w.WriteMessages(context.Background(), kafka.Message{
+		Key:     []byte(key),
+		Value:   value,
+		Headers: headers,
+	})
+}
+
+// ReadMeta reads the meta from the headers of the message, without consuming it
+func (ka *KafkaFS) ReadMeta(name string) ([]byte, error) {
+	if data, ok := ka.pending.Get(name); ok {
+		return data.([]byte), nil
+	}
+	// a sidecar is written when the message was already produced, so it is newer than the headers
+	if _, err := ka.message(metaName(name)); err == nil {
+		return nil, os.ErrNotExist
+	}
+
+	m, err := ka.message(name)
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range m.Headers {
+		if h.Key == kafkaMetaHeader {
+			return h.Value, nil
+		}
+	}
+	return nil, os.ErrNotExist
+}
+
+// WriteMeta keeps the meta of a message that is not produced yet, and Push sends it in the headers when it
+// comes within kafkaCacheExpiration. Messages are immutable and producing one again would deliver the file
+// twice, so the meta of a message already produced is refused with ErrNotSupported and goes to the sidecar
+func (ka *KafkaFS) WriteMeta(name string, data []byte) error {
+	if _, err := ka.message(name); err == nil {
+		return ErrNotSupported
+	}
+
+	if data == nil {
+		ka.pending.Delete(name)
+	} else {
+		ka.pending.Set(name, data, kafkaCacheExpiration)
+	}
+	return nil
 }
 
 func (ka *KafkaFS) Close() error {
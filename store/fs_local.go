@@ -114,6 +114,49 @@ func (l *Local) Push(name string, r io.Reader) error {
 	return err
 }
 
+// localMetaAttr is the extended attribute that keeps the meta of a file
+const localMetaAttr = "user.bbfs.meta"
+
+// ReadMeta reads the meta from the extended attributes of the file
+func (l *Local) ReadMeta(name string) ([]byte, error) {
+	return getXattr(l.realPath(name), localMetaAttr)
+}
+
+// WriteMeta keeps the meta in the extended attributes of the file. Renames carry them along
+func (l *Local) WriteMeta(name string, data []byte) error {
+	return setXattr(l.realPath(name), localMetaAttr, data)
+}
+
+// Append adds a line at the end of name while holding an exclusive lock on the file
+func (l *Local) Append(name string, line func(last []byte) ([]byte, error)) error {
+	name = l.realPath(name)
+	_ = os.MkdirAll(filepath.Dir(name), 0755)
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_APPEND, l.Perm)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err = lockFile(f); err != nil {
+		return err
+	}
+	defer unlockFile(f)
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	last, err := lastLine(f, info.Size())
+	if err != nil {
+		return err
+	}
+	data, err := line(last)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
 func (l *Local) Remove(name string) error {
 	name = l.realPath(name)
 	return os.Remove(name)
@@ -161,6 +204,24 @@ func (l *Local) Touch(name string) error {
 	return os.Chtimes(name, currentTime, currentTime)
 }
 
+// Link replaces dest with a clone of src that shares its blocks (a reflink). The two files stay independent:
+// a write to one does not change the other, and dest keeps its own meta. It returns ErrNotSupported when
+// the file system cannot clone files
+func (l *Local) Link(src, dest string) error {
+	tmp := l.realPath(dest) + ".link~"
+	if err := reflink(l.realPath(src), tmp, l.Perm); err != nil {
+		return err
+	}
+	if data, err := getXattr(l.realPath(dest), localMetaAttr); err == nil {
+		_ = setXattr(tmp, localMetaAttr, data)
+	}
+	if err := os.Rename(tmp, l.realPath(dest)); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
 func (l *Local) Close() error {
 	return nil
 }
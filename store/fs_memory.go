@@ -173,6 +173,7 @@ func (m *Memory) Remove(name string) error {
 	if !ok {
 		return os.ErrNotExist
 	}
+	delete(m.files, name)
 	return nil
 }
 
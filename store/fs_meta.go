@@ -3,15 +3,72 @@ package store
 import (
 	"bytes"
 	"encoding/gob"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/hashicorp/go-multierror"
+	"io/fs"
+	"os"
 	"path"
 	"reflect"
 	"strings"
+	"sync"
 )
 
+// Meta sidecars are JSON documents with a format marker, a version and the metas by key:
+//
+//	{"format": "bbfs-meta", "version": 1, "meta": {"bbfs.attr": {"modifiedBy": "me", "group": "public"}}}
+//
+// Keys are assigned with RegisterMeta; types that are not registered use their Go type name.
+// Sidecars in the former gob format are read transparently and converted on the next change
+const (
+	MetaFormat  = "bbfs-meta"
+	MetaVersion = 1
+)
+
+// MetaBlob is the content of a gob sidecar, keyed by Go type names
 type MetaBlob map[string][]byte
 
+type metaDoc struct {
+	Format  string                     `json:"format"`
+	Version int                        `json:"version"`
+	Meta    map[string]json.RawMessage `json:"meta"`
+	// Legacy keeps the gob metas of a converted sidecar whose type is not registered
+	Legacy MetaBlob `json:"legacy,omitempty"`
+}
+
+var metaKeys = map[reflect.Type]string{}
+var metaLegacyTypes = map[string]reflect.Type{}
+var metaRegistryLock sync.RWMutex
+
+func metaType(meta interface{}) reflect.Type {
+	t := reflect.TypeOf(meta)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+// RegisterMeta assigns a key to the type of meta. Keys should be namespaced, e.g. myapp.owner
+func RegisterMeta(key string, meta interface{}) {
+	metaRegistryLock.Lock()
+	defer metaRegistryLock.Unlock()
+	t := metaType(meta)
+	metaKeys[t] = key
+	metaLegacyTypes[t.String()] = t
+}
+
+// metaKey returns the key of the type of meta and its Go type name, used by gob sidecars
+func metaKey(meta interface{}) (string, string) {
+	metaRegistryLock.RLock()
+	defer metaRegistryLock.RUnlock()
+	t := metaType(meta)
+	if key, ok := metaKeys[t]; ok {
+		return key, t.String()
+	}
+	return t.String(), t.String()
+}
+
 func metaName(name string) string {
 	dir, name := path.Split(name)
 	return path.Join(dir, fmt.Sprintf(".%s!.meta", name))
@@ -21,60 +78,269 @@ func IsMeta(name string) bool {
 	return strings.HasSuffix(name, "!.meta")
 }
 
-func SetMeta(f FS, name string, metas ...interface{}) error {
-	m := make(MetaBlob)
-	name = metaName(name)
+// metaTarget returns the file that the sidecar name describes
+func metaTarget(name string) string {
+	dir, base := path.Split(name)
+	return path.Join(dir, strings.TrimSuffix(strings.TrimPrefix(base, "."), "!.meta"))
+}
+
+// NativeMeta is implemented by file storages that keep the meta together with the file, e.g. as S3 user
+// metadata or extended attributes, in place of a sidecar. ReadMeta returns os.ErrNotExist when the file has
+// no meta. WriteMeta with nil data deletes the meta; it returns ErrNotSupported when the meta cannot be
+// stored natively, e.g. because it is too large, and the sidecar is used instead
+type NativeMeta interface {
+	ReadMeta(name string) ([]byte, error)
+	WriteMeta(name string, data []byte) error
+}
+
+// nativeMeta returns the native meta storage of f. Decorators that must see the changes to the meta,
+// e.g. for access control or accounting, implement NativeMeta with their checks on top of the inner store,
+// and so do Sub, which maps the name, and Encrypted, which encrypts the meta like the content
+func nativeMeta(f FS) (NativeMeta, bool) {
+	for f != nil {
+		if n, ok := f.(NativeMeta); ok {
+			return n, true
+		}
+		switch f.(type) {
+		case *Retry, *BWLimit, *Metrics, *Compressed, *Trash, *Versioned, *MetaAware, *Hashed:
+			f = Unwrap(f)
+		default:
+			return nil, false
+		}
+	}
+	return nil, false
+}
+
+func newMetaDoc() *metaDoc {
+	return &metaDoc{Format: MetaFormat, Version: MetaVersion, Meta: map[string]json.RawMessage{}, Legacy: MetaBlob{}}
+}
+
+// readMeta reads the sidecar name
+func readMeta(f FS, name string) (*metaDoc, error) {
 	bs := new(bytes.Buffer)
-	err := f.Pull(name, bs)
-	if err == nil {
-		_ = gob.NewDecoder(bs).Decode(&m)
+	if err := f.Pull(name, bs); err != nil {
+		return nil, err
 	}
+	return decodeMeta(bs.Bytes(), name)
+}
 
-	for _, meta := range metas {
-		t := strings.Trim(reflect.TypeOf(meta).String(), "*")
-		buf := new(bytes.Buffer)
-		err = gob.NewEncoder(buf).Encode(meta)
+// metaDecodeError reports a meta that exists but cannot be decoded
+type metaDecodeError struct {
+	name string
+	err  error
+}
+
+func (e *metaDecodeError) Error() string {
+	return fmt.Sprintf("cannot decode the meta of %s: %v", e.name, e.err)
+}
+
+func (e *metaDecodeError) Unwrap() error {
+	return e.err
+}
+
+// decodeMeta parses the meta of name, converting it when it is in the gob format
+func decodeMeta(data []byte, name string) (*metaDoc, error) {
+	doc, err := parseMeta(data, name)
+	if err != nil {
+		return nil, &metaDecodeError{name: name, err: err}
+	}
+	return doc, nil
+}
+
+func parseMeta(data []byte, name string) (*metaDoc, error) {
+	doc := newMetaDoc()
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		if err := json.Unmarshal(trimmed, doc); err != nil {
+			return nil, err
+		}
+		if doc.Format != MetaFormat || doc.Version > MetaVersion {
+			return nil, fmt.Errorf("unsupported meta %s version %d in %s: %w", doc.Format, doc.Version, name, ErrNotSupported)
+		}
+		if doc.Meta == nil {
+			doc.Meta = map[string]json.RawMessage{}
+		}
+		if doc.Legacy == nil {
+			doc.Legacy = MetaBlob{}
+		}
+		return doc, nil
+	}
+
+	m := make(MetaBlob)
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&m); err != nil {
+		return nil, err
+	}
+	metaRegistryLock.RLock()
+	defer metaRegistryLock.RUnlock()
+	for typeName, v := range m {
+		t, ok := metaLegacyTypes[typeName]
+		if !ok {
+			doc.Legacy[typeName] = v
+			continue
+		}
+		meta := reflect.New(t)
+		if err := gob.NewDecoder(bytes.NewBuffer(v)).Decode(meta.Interface()); err != nil {
+			return nil, err
+		}
+		data, err := json.Marshal(meta.Interface())
 		if err != nil {
+			return nil, err
+		}
+		doc.Meta[metaKeys[t]] = data
+	}
+	return doc, nil
+}
+
+// loadMeta reads the meta of the file name from the native storage, when available, or from the sidecar
+func loadMeta(f FS, name string) (*metaDoc, error) {
+	if n, ok := nativeMeta(f); ok {
+		data, err := n.ReadMeta(name)
+		if err == nil {
+			return decodeMeta(data, name)
+		}
+		if !os.IsNotExist(err) && !errors.Is(err, ErrNotSupported) {
+			return nil, err
+		}
+	}
+	return readMeta(f, metaName(name))
+}
+
+// storeMeta writes the meta of the file name in the native storage, when possible, or in the sidecar.
+// An empty doc deletes the meta
+func storeMeta(f FS, name string, doc *metaDoc) error {
+	empty := len(doc.Meta) == 0 && len(doc.Legacy) == 0
+	var data []byte
+	if !empty {
+		var err error
+		if data, err = json.Marshal(doc); err != nil {
 			return err
 		}
-		m[t] = buf.Bytes()
 	}
-	buf := new(bytes.Buffer)
-	err = gob.NewEncoder(buf).Encode(m)
-	if err != nil {
+
+	if n, ok := nativeMeta(f); ok {
+		err := n.WriteMeta(name, data)
+		if err == nil {
+			// the sidecar is obsolete once the meta is stored natively
+			if _, err := f.Stat(metaName(name)); err == nil {
+				return f.Remove(metaName(name))
+			}
+			return nil
+		}
+		if !errors.Is(err, ErrNotSupported) {
+			return err
+		}
+	}
+	if empty {
+		return f.Remove(metaName(name))
+	}
+	return f.Push(metaName(name), bytes.NewReader(data))
+}
+
+func SetMeta(f FS, name string, metas ...interface{}) error {
+	// backends do not agree on the error of a missing sidecar, so only a meta that
+	// exists but cannot be decoded is refused, as replacing it would lose its content
+	doc, err := loadMeta(f, name)
+	var decodeErr *metaDecodeError
+	if errors.As(err, &decodeErr) {
 		return err
+	} else if err != nil {
+		doc = newMetaDoc()
 	}
 
-	return f.Pull(name, buf)
+	for _, meta := range metas {
+		key, typeName := metaKey(meta)
+		data, err := json.Marshal(meta)
+		if err != nil {
+			return err
+		}
+		doc.Meta[key] = data
+		delete(doc.Legacy, typeName)
+	}
+	return storeMeta(f, name, doc)
 }
 
 func GetMeta(f FS, name string, metas ...interface{}) error {
-	m := make(MetaBlob)
-	name = metaName(name)
-
-	bs := new(bytes.Buffer)
-	err := f.Pull(name, bs)
+	doc, err := loadMeta(f, name)
 	if err != nil {
 		return err
 	}
 
-	err = gob.NewDecoder(bs).Decode(&m)
+	err = &multierror.Error{}
+	for _, meta := range metas {
+		key, typeName := metaKey(meta)
+		if v, ok := doc.Meta[key]; ok {
+			err = multierror.Append(err, json.Unmarshal(v, meta))
+		} else if v, ok := doc.Legacy[typeName]; ok {
+			err = multierror.Append(err, gob.NewDecoder(bytes.NewBuffer(v)).Decode(meta))
+		}
+	}
+
+	return err.(*multierror.Error).ErrorOrNil()
+}
+
+// ListMeta returns the meta of the file name in JSON by key. Legacy values of unregistered types are not included
+func ListMeta(f FS, name string) (map[string]json.RawMessage, error) {
+	doc, err := loadMeta(f, name)
+	if err != nil {
+		return nil, err
+	}
+	return doc.Meta, nil
+}
+
+// UnsetMeta removes the metas with the same type of the provided values. The sidecar file is deleted when empty
+func UnsetMeta(f FS, name string, metas ...interface{}) error {
+	doc, err := loadMeta(f, name)
 	if err != nil {
 		return err
 	}
-	err = &multierror.Error{}
+
 	for _, meta := range metas {
-		t := strings.Trim(reflect.TypeOf(meta).String(), "*")
-		if v, ok := m[t]; ok {
-			d := gob.NewDecoder(bytes.NewBuffer(v))
-			err = multierror.Append(err, d.Decode(meta))
+		key, typeName := metaKey(meta)
+		delete(doc.Meta, key)
+		delete(doc.Legacy, typeName)
+	}
+	return storeMeta(f, name, doc)
+}
+
+// MigrateMeta converts the gob sidecars in the folder name and its subfolders to the current format,
+// moving them to the native meta storage when available. It returns the number of converted sidecars
+func MigrateMeta(f FS, name string) (int, error) {
+	var sidecars []string
+	err := Walk(f, name, IncludeHiddenFiles, func(dir string, file fs.FileInfo) {
+		if IsMeta(file.Name()) {
+			sidecars = append(sidecars, path.Join(dir, file.Name()))
 		}
+	})
+	if err != nil {
+		return 0, err
 	}
 
-	return err.(*multierror.Error).ErrorOrNil()
+	cnt := 0
+	for _, s := range sidecars {
+		bs := new(bytes.Buffer)
+		if err = f.Pull(s, bs); err != nil {
+			return cnt, err
+		}
+		if data := bytes.TrimSpace(bs.Bytes()); len(data) > 0 && data[0] == '{' {
+			continue
+		}
+		doc, err := decodeMeta(bs.Bytes(), s)
+		if err != nil {
+			return cnt, fmt.Errorf("cannot convert %s: %w", s, err)
+		}
+		if err = storeMeta(f, metaTarget(s), doc); err != nil {
+			return cnt, err
+		}
+		cnt++
+	}
+	return cnt, nil
 }
 
 func RemoveMeta(f FS, name string) error {
+	if n, ok := nativeMeta(f); ok && n.WriteMeta(name, nil) == nil {
+		if _, err := f.Stat(metaName(name)); err != nil {
+			return nil
+		}
+	}
 	return f.Remove(metaName(name))
 }
 
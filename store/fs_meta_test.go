@@ -0,0 +1,177 @@
+package store
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMetaMigration(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "stg/test/meta")
+	_ = os.RemoveAll(dir)
+	_ = os.MkdirAll(dir, 0755)
+	l := NewLocalMount(dir)
+	assert.NoError(t, l.Push("a.txt", bytes.NewBufferString("a")))
+
+	// a sidecar in the gob format
+	blob := MetaBlob{}
+	for _, meta := range []interface{}{Attr{Group: "public"}, testMeta{"me"}} {
+		buf := new(bytes.Buffer)
+		assert.NoError(t, gob.NewEncoder(buf).Encode(meta))
+		blob[metaType(meta).String()] = buf.Bytes()
+	}
+	buf := new(bytes.Buffer)
+	assert.NoError(t, gob.NewEncoder(buf).Encode(blob))
+	assert.NoError(t, l.Push(metaName("a.txt"), buf))
+
+	var attr Attr
+	var tm testMeta
+	assert.NoError(t, GetMeta(l, "a.txt", &attr, &tm))
+	assert.Equal(t, Group("public"), attr.Group)
+	assert.Equal(t, "me", tm.Owner)
+
+	n, err := MigrateMeta(l, "")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	// the meta is now kept in the extended attributes of the file
+	data, err := l.(*Local).ReadMeta("a.txt")
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"bbfs.attr":{"modifiedBy":"","group":"public"`)
+	_, err = l.Stat(metaName("a.txt"))
+	assert.True(t, os.IsNotExist(err))
+
+	attr, tm = Attr{}, testMeta{}
+	assert.NoError(t, GetMeta(l, "a.txt", &attr, &tm))
+	assert.Equal(t, Group("public"), attr.Group)
+	assert.Equal(t, "me", tm.Owner)
+
+	assert.NoError(t, SetMeta(l, "a.txt", testMeta{"you"}))
+	assert.NoError(t, UnsetMeta(l, "a.txt", &attr))
+	assert.NoError(t, GetMeta(l, "a.txt", &tm))
+	assert.Equal(t, "you", tm.Owner)
+
+	// renames and copies carry the meta along
+	assert.NoError(t, l.Rename("a.txt", "b.txt"))
+	m := NewMemory(nil, 0)
+	assert.NoError(t, Copy(l, m, "b.txt", "c.txt", true, 0))
+	tm = testMeta{}
+	assert.NoError(t, GetMeta(m, "c.txt", &tm))
+	assert.Equal(t, "you", tm.Owner)
+}
+
+func TestSetMetaKeepsUnreadableMeta(t *testing.T) {
+	m := NewMemory(nil, 0)
+	assert.NoError(t, m.Push("a.txt", bytes.NewBufferString("a")))
+	assert.NoError(t, SetMeta(m, "a.txt", Attr{Group: "dev"}))
+
+	// a meta that cannot be read is not replaced with a new one
+	assert.NoError(t, m.Push(metaName("a.txt"), bytes.NewBufferString(`{"format": `)))
+	assert.Error(t, SetMeta(m, "a.txt", Attr{Group: "ops"}))
+	data, err := ReadFile(m, metaName("a.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, `{"format": `, string(data))
+}
+
+// notFoundFS fails the pulls of missing files with a backend error, like S3 or FTP do
+type notFoundFS struct {
+	FS
+}
+
+func (f *notFoundFS) Pull(name string, w io.Writer) error {
+	if _, err := f.FS.Stat(name); err != nil {
+		return fmt.Errorf("550 %s: no such file", name)
+	}
+	return f.FS.Pull(name, w)
+}
+
+func TestSetMetaWithoutMeta(t *testing.T) {
+	f := &notFoundFS{NewMemory(nil, 0)}
+	assert.NoError(t, f.Push("a.txt", bytes.NewBufferString("a")))
+
+	// the first meta of a file is set even when the backend does not report a missing sidecar as such
+	assert.NoError(t, SetMeta(f, "a.txt", Attr{Group: "dev"}))
+	var attr Attr
+	assert.NoError(t, GetMeta(f, "a.txt", &attr))
+	assert.Equal(t, Group("dev"), attr.Group)
+
+	assert.NoError(t, f.Push("b.txt", bytes.NewBufferString("b")))
+	assert.NoError(t, UpdateAttr(f, "b.txt", "b.txt", func(attr Attr) Attr {
+		attr.ModifiedBy = "me"
+		return attr
+	}))
+	assert.NoError(t, GetMeta(f, "b.txt", &attr))
+	assert.Equal(t, "me", attr.ModifiedBy)
+}
+
+func TestNativeMetaThroughDecorators(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "stg/test/nativemeta")
+	_ = os.RemoveAll(dir)
+	_ = os.MkdirAll(dir, 0755)
+	l := NewLocalMount(dir)
+	if _, ok := nativeMeta(l); !ok {
+		t.Skip("no native meta on this platform")
+	}
+	assert.NoError(t, WriteFile(l, "a.txt", []byte("a")))
+	assert.NoError(t, SetMeta(l, "a.txt", Attr{Group: "dev"}))
+
+	policy := &Policy{Rules: []AccessRule{{Path: "/", Principals: []string{"*"}, Allow: []Permission{PermRead}}}}
+	c, err := NewCache(l, CacheConfig{Dir: filepath.Join(dir, ".cache")})
+	assert.NoError(t, err)
+	defer c.Close()
+	a, err := NewAudit(l, nil, AuditConfig{Actor: "tester"})
+	assert.NoError(t, err)
+	for _, f := range []FS{NewReadOnly(l), NewWORM(l, WORMConfig{}), NewAccess(l, policy, "alice", time.Minute),
+		NewQuota(l, 0), c, a} {
+		var attr Attr
+		assert.NoError(t, GetMeta(f, "a.txt", &attr), f.String())
+		assert.Equal(t, Group("dev"), attr.Group, f.String())
+	}
+
+	// the decorators apply their checks to the native meta
+	assert.ErrorIs(t, SetMeta(NewReadOnly(l), "a.txt", Attr{Group: "ops"}), os.ErrPermission)
+	assert.ErrorIs(t, SetMeta(NewAccess(l, policy, "alice", time.Minute), "a.txt", Attr{Group: "ops"}), os.ErrPermission)
+
+	q := NewQuota(l, 0).(*QuotaFS)
+	assert.NoError(t, SetMeta(q, "a.txt", Attr{Group: "ops"}))
+	assert.Equal(t, int64(1), q.Accountant.Usage().Groups["ops"])
+	assert.Zero(t, q.Accountant.Usage().Groups["dev"])
+
+	assert.NoError(t, SetMeta(a, "a.txt", Attr{Group: "dev", ModifiedBy: "bob"}))
+	records, err := ReadAudit(l, ".audit", AuditFilter{Path: "a.txt"})
+	assert.NoError(t, err)
+	if assert.NotEmpty(t, records) {
+		assert.Equal(t, "meta", records[len(records)-1].Op)
+		assert.Equal(t, "bob", records[len(records)-1].Actor)
+	}
+	_, err = l.Stat(metaName("a.txt"))
+	assert.True(t, os.IsNotExist(err))
+
+	// a sub folder maps the name, and encryption keeps the native meta in clear only above the store
+	sub := &Sub{F: l, Dir: "sub"}
+	assert.NoError(t, WriteFile(sub, "b.txt", []byte("b")))
+	assert.NoError(t, SetMeta(sub, "b.txt", Attr{Group: "dev"}))
+	data, err := l.(*Local).ReadMeta("sub/b.txt")
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"group":"dev"`)
+
+	b, _ := NewAesCipher([]byte("Hello"))
+	e := NewEncrypted(l, b)
+	assert.NoError(t, WriteFile(e, "c.txt", []byte("c")))
+	assert.NoError(t, SetMeta(e, "c.txt", Attr{Group: "dev"}))
+	data, err = l.(*Local).ReadMeta("c.txt")
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "dev")
+	var attr Attr
+	assert.NoError(t, GetMeta(e, "c.txt", &attr))
+	assert.Equal(t, Group("dev"), attr.Group)
+	for _, name := range []string{"sub/b.txt", "c.txt"} {
+		_, err = l.Stat(metaName(name))
+		assert.True(t, os.IsNotExist(err), name)
+	}
+}
@@ -0,0 +1,194 @@
+package store
+
+import (
+	"fmt"
+	"github.com/hashicorp/go-multierror"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// metaTombstoneSuffix marks a sidecar set aside while its file is removed
+const metaTombstoneSuffix = "!.meta~"
+
+// MetaAware renames and removes each file together with its meta sidecar, undoing the change on the file
+// when the sidecar cannot follow
+type MetaAware struct {
+	F FS
+}
+
+func NewMetaAware(f FS) FS {
+	return &MetaAware{f}
+}
+
+func (m *MetaAware) hasSidecar(name string) bool {
+	_, err := m.F.Stat(metaName(name))
+	return err == nil
+}
+
+func (m *MetaAware) Props() Props {
+	return m.F.Props()
+}
+
+func (m *MetaAware) ReadDir(name string, opts ListOption) ([]fs.FileInfo, error) {
+	return m.F.ReadDir(name, opts)
+}
+
+func (m *MetaAware) Watch(name string) chan string {
+	return m.F.Watch(name)
+}
+
+func (m *MetaAware) Stat(name string) (fs.FileInfo, error) {
+	return m.F.Stat(name)
+}
+
+// Remove sets the sidecar aside before the file is removed, so that it can be put back on failure
+func (m *MetaAware) Remove(name string) error {
+	if IsMeta(name) || !m.hasSidecar(name) {
+		return m.F.Remove(name)
+	}
+
+	tombstone := strings.TrimSuffix(metaName(name), "!.meta") + metaTombstoneSuffix
+	if err := m.F.Rename(metaName(name), tombstone); err != nil {
+		return fmt.Errorf("cannot set aside the meta of %s: %w", name, err)
+	}
+	if err := m.F.Remove(name); err != nil {
+		if rerr := m.F.Rename(tombstone, metaName(name)); rerr != nil {
+			return multierror.Append(err, fmt.Errorf("cannot restore the meta of %s: %w", name, rerr))
+		}
+		return err
+	}
+	_ = m.F.Remove(tombstone)
+	return nil
+}
+
+func (m *MetaAware) Touch(name string) error {
+	return m.F.Touch(name)
+}
+
+// Rename moves the sidecar after the file and moves the file back when the sidecar cannot follow. The
+// sidecar of a replaced file is removed when the renamed file has none
+func (m *MetaAware) Rename(old, new string) error {
+	if IsMeta(old) {
+		return m.F.Rename(old, new)
+	}
+	if !m.hasSidecar(old) {
+		if err := m.F.Rename(old, new); err != nil {
+			return err
+		}
+		if path.Clean(old) != path.Clean(new) && m.hasSidecar(new) {
+			return m.F.Remove(metaName(new))
+		}
+		return nil
+	}
+
+	if err := m.F.Rename(old, new); err != nil {
+		return err
+	}
+	if err := m.F.Rename(metaName(old), metaName(new)); err != nil {
+		if rerr := m.F.Rename(new, old); rerr != nil {
+			return multierror.Append(err, fmt.Errorf("cannot move %s back to %s: %w", new, old, rerr))
+		}
+		return fmt.Errorf("cannot move the meta of %s: %w", old, err)
+	}
+	return nil
+}
+
+func (m *MetaAware) MkdirAll(name string) error {
+	return m.F.MkdirAll(name)
+}
+
+func (m *MetaAware) Pull(name string, w io.Writer) error {
+	return m.F.Pull(name, w)
+}
+
+func (m *MetaAware) Push(name string, r io.Reader) error {
+	return m.F.Push(name, r)
+}
+
+func (m *MetaAware) Close() error {
+	return m.F.Close()
+}
+
+func (m *MetaAware) String() string {
+	return fmt.Sprintf("%s#meta", m.F)
+}
+
+// FsckReport lists the inconsistencies between files and meta sidecars
+type FsckReport struct {
+	// Zombies are sidecars whose file does not exist
+	Zombies []string
+	// Tombstones are sidecars left aside by an interrupted remove. When the file still exists the
+	// tombstone is its meta and it is put back
+	Tombstones []string
+	// Corrupted are sidecars that cannot be decoded
+	Corrupted []string
+	// Repaired is the number of inconsistencies that have been fixed
+	Repaired int
+}
+
+// Fsck checks the sidecars in the folder name and its subfolders. With repair, zombies and the tombstones
+// of removed files are deleted, while the tombstones of existing files are renamed back to their sidecar.
+// Corrupted sidecars, and tombstones of files that have a new sidecar, are only reported, since they may
+// be recovered by hand
+func Fsck(f FS, name string, repair bool) (FsckReport, error) {
+	var report FsckReport
+	var sidecars, tombstones []string
+	err := Walk(f, name, IncludeHiddenFiles, func(dir string, file fs.FileInfo) {
+		switch n := file.Name(); {
+		case IsMeta(n):
+			sidecars = append(sidecars, path.Join(dir, n))
+		case strings.HasSuffix(n, metaTombstoneSuffix):
+			tombstones = append(tombstones, path.Join(dir, n))
+		}
+	})
+	if err != nil {
+		return report, err
+	}
+
+	var me *multierror.Error
+	fix := func(name string) {
+		if !repair {
+			return
+		}
+		if err := f.Remove(name); err != nil {
+			me = multierror.Append(me, err)
+		} else {
+			report.Repaired++
+		}
+	}
+
+	for _, s := range sidecars {
+		if _, err := f.Stat(metaTarget(s)); os.IsNotExist(err) {
+			report.Zombies = append(report.Zombies, s)
+			fix(s)
+			continue
+		}
+		if _, err := readMeta(f, s); err != nil {
+			report.Corrupted = append(report.Corrupted, s)
+		}
+	}
+	for _, t := range tombstones {
+		report.Tombstones = append(report.Tombstones, t)
+		sidecar := strings.TrimSuffix(t, "~")
+		if _, err := f.Stat(metaTarget(sidecar)); os.IsNotExist(err) {
+			fix(t)
+			continue
+		}
+		if _, err := f.Stat(sidecar); err == nil || !repair {
+			continue
+		}
+		if err := f.Rename(t, sidecar); err != nil {
+			me = multierror.Append(me, err)
+		} else {
+			report.Repaired++
+		}
+	}
+	sort.Strings(report.Zombies)
+	sort.Strings(report.Corrupted)
+	sort.Strings(report.Tombstones)
+	return report, me.ErrorOrNil()
+}
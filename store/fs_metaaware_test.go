@@ -0,0 +1,84 @@
+package store
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+// sidecarLockedFS refuses to rename meta sidecars
+type sidecarLockedFS struct {
+	FS
+}
+
+func (f *sidecarLockedFS) Rename(old, new string) error {
+	if IsMeta(old) {
+		return os.ErrPermission
+	}
+	return f.FS.Rename(old, new)
+}
+
+func TestMetaAware(t *testing.T) {
+	mem := NewMemory(nil, 0)
+	m := NewMetaAware(mem)
+
+	assert.NoError(t, m.Push("a.txt", bytes.NewBufferString("a")))
+	assert.NoError(t, SetMeta(m, "a.txt", testMeta{"me"}))
+
+	assert.NoError(t, m.Rename("a.txt", "b.txt"))
+	var tm testMeta
+	assert.NoError(t, GetMeta(m, "b.txt", &tm))
+	assert.Equal(t, "me", tm.Owner)
+	_, err := mem.Stat(metaName("a.txt"))
+	assert.True(t, os.IsNotExist(err))
+
+	// the file goes back when its sidecar cannot follow
+	locked := NewMetaAware(&sidecarLockedFS{mem})
+	assert.Error(t, locked.Rename("b.txt", "c.txt"))
+	_, err = mem.Stat("b.txt")
+	assert.NoError(t, err)
+	_, err = mem.Stat("c.txt")
+	assert.True(t, os.IsNotExist(err))
+
+	assert.NoError(t, m.Remove("b.txt"))
+	_, err = mem.Stat(metaName("b.txt"))
+	assert.True(t, os.IsNotExist(err))
+
+	// a zombie left by a backend without meta support
+	assert.NoError(t, mem.Push("d.txt", bytes.NewBufferString("d")))
+	assert.NoError(t, SetMeta(mem, "d.txt", testMeta{"me"}))
+	assert.NoError(t, mem.Remove("d.txt"))
+	report, err := Fsck(mem, "", false)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{metaName("d.txt")}, report.Zombies)
+	report, err = Fsck(mem, "", true)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Repaired)
+	report, _ = Fsck(mem, "", false)
+	assert.Empty(t, report.Zombies)
+
+	// an interrupted remove leaves the only meta of an existing file in a tombstone
+	assert.NoError(t, mem.Push("e.txt", bytes.NewBufferString("e")))
+	assert.NoError(t, SetMeta(mem, "e.txt", testMeta{"you"}))
+	assert.NoError(t, mem.Rename(metaName("e.txt"), metaName("e.txt")+"~"))
+	assert.NoError(t, mem.Push("f.txt", bytes.NewBufferString("f")))
+	assert.NoError(t, SetMeta(mem, "f.txt", testMeta{"you"}))
+	assert.NoError(t, mem.Rename(metaName("f.txt"), metaName("f.txt")+"~"))
+	assert.NoError(t, mem.Remove("f.txt"))
+	report, err = Fsck(mem, "", true)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{metaName("e.txt") + "~", metaName("f.txt") + "~"}, report.Tombstones)
+	assert.Equal(t, 2, report.Repaired)
+	tm = testMeta{}
+	assert.NoError(t, GetMeta(mem, "e.txt", &tm))
+	assert.Equal(t, "you", tm.Owner)
+	_, err = mem.Stat(metaName("f.txt") + "~")
+	assert.True(t, os.IsNotExist(err))
+
+	// the old sidecar of a replaced file does not stay with a file without meta
+	assert.NoError(t, mem.Push("g.txt", bytes.NewBufferString("g")))
+	assert.NoError(t, m.Rename("g.txt", "e.txt"))
+	_, err = mem.Stat(metaName("e.txt"))
+	assert.True(t, os.IsNotExist(err))
+}
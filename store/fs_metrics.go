@@ -0,0 +1,122 @@
+package store
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+)
+
+const (
+	metricOps      = "bbfs_store_operations_total"
+	metricErrors   = "bbfs_store_errors_total"
+	metricBytes    = "bbfs_store_bytes_total"
+	metricDuration = "bbfs_store_operation_duration_seconds"
+)
+
+// Metrics counts the operations, the transferred bytes, the errors and the latency of a file storage
+type Metrics struct {
+	F        FS
+	Name     string
+	Registry *Registry
+}
+
+// NewMetrics records the activity of f with the store label name in DefaultMetrics
+func NewMetrics(f FS, name string) FS {
+	if name == "" {
+		name = f.String()
+	}
+	return &Metrics{
+		F:        f,
+		Name:     name,
+		Registry: DefaultMetrics,
+	}
+}
+
+func (m *Metrics) record(op string, start time.Time, err error) {
+	m.Registry.Add(metricOps, "Operations executed on a store", 1, "store", m.Name, "op", op)
+	m.Registry.Observe(metricDuration, "Latency of the operations on a store", time.Since(start).Seconds(),
+		"store", m.Name, "op", op)
+	if err != nil && !os.IsNotExist(err) {
+		m.Registry.Add(metricErrors, "Operations failed on a store", 1, "store", m.Name, "op", op)
+	}
+}
+
+func (m *Metrics) Props() Props {
+	return m.F.Props()
+}
+
+func (m *Metrics) ReadDir(name string, opts ListOption) ([]fs.FileInfo, error) {
+	start := time.Now()
+	ls, err := m.F.ReadDir(name, opts)
+	m.record("readdir", start, err)
+	return ls, err
+}
+
+func (m *Metrics) Stat(name string) (fs.FileInfo, error) {
+	start := time.Now()
+	l, err := m.F.Stat(name)
+	m.record("stat", start, err)
+	return l, err
+}
+
+func (m *Metrics) Remove(name string) error {
+	start := time.Now()
+	err := m.F.Remove(name)
+	m.record("remove", start, err)
+	return err
+}
+
+func (m *Metrics) Touch(name string) error {
+	start := time.Now()
+	err := m.F.Touch(name)
+	m.record("touch", start, err)
+	return err
+}
+
+func (m *Metrics) Watch(name string) chan string {
+	return m.F.Watch(name)
+}
+
+func (m *Metrics) Rename(old, new string) error {
+	start := time.Now()
+	err := m.F.Rename(old, new)
+	m.record("rename", start, err)
+	return err
+}
+
+func (m *Metrics) MkdirAll(name string) error {
+	start := time.Now()
+	err := m.F.MkdirAll(name)
+	m.record("mkdir", start, err)
+	return err
+}
+
+func (m *Metrics) Pull(name string, w io.Writer) error {
+	start := time.Now()
+	cw := &countingWriter{W: w}
+	err := m.F.Pull(name, cw)
+	m.record("pull", start, err)
+	m.Registry.Add(metricBytes, "Bytes transferred from and to a store", float64(cw.Cnt),
+		"store", m.Name, "op", "pull")
+	return err
+}
+
+func (m *Metrics) Push(name string, r io.Reader) error {
+	start := time.Now()
+	cr := &CountingReader{R: r}
+	err := m.F.Push(name, cr)
+	m.record("push", start, err)
+	m.Registry.Add(metricBytes, "Bytes transferred from and to a store", float64(cr.Cnt),
+		"store", m.Name, "op", "push")
+	return err
+}
+
+func (m *Metrics) Close() error {
+	return m.F.Close()
+}
+
+func (m *Metrics) String() string {
+	return fmt.Sprintf("%s#metrics", m.F)
+}
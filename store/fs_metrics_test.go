@@ -0,0 +1,32 @@
+package store
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	m := NewMetrics(NewLocalMount(os.TempDir()), "local").(*Metrics)
+	m.Registry = NewRegistry()
+
+	name := "stg/test/metrics.txt"
+	assert.NoError(t, m.Push(name, bytes.NewBufferString("hello")))
+	assert.NoError(t, m.Pull(name, &bytes.Buffer{}))
+	_, err := m.Stat("stg/test/missing.txt")
+	assert.Error(t, err)
+	_ = m.Remove(name)
+
+	assert.Equal(t, 5.0, m.Registry.Value(metricBytes, "store", "local", "op", "push"))
+	assert.Equal(t, 1.0, m.Registry.Value(metricOps, "store", "local", "op", "stat"))
+	assert.Equal(t, 0.0, m.Registry.Value(metricErrors, "store", "local", "op", "stat"))
+
+	var sb strings.Builder
+	assert.NoError(t, m.Registry.WriteText(&sb))
+	text := sb.String()
+	assert.Contains(t, text, "# TYPE bbfs_store_operation_duration_seconds histogram")
+	assert.Contains(t, text, `bbfs_store_bytes_total{store="local",op="pull"} 5`)
+	assert.Contains(t, text, `bbfs_store_operation_duration_seconds_count{store="local",op="push"} 1`)
+}
@@ -2,9 +2,45 @@ package store
 
 import (
 	"github.com/gabriel-vasile/mimetype"
+	"strings"
 )
 
 func Mime(f FS, name string) *mimetype.MIME {
 	d, _ := Peek(f, name, 512)
-	return mimetype.Detect(d)
+	return DetectMime(d)
+}
+
+// DetectMime returns the mime type of the provided content head
+func DetectMime(head []byte) *mimetype.MIME {
+	return mimetype.Detect(head)
+}
+
+var compressedMimes = []string{
+	"application/zip", "application/gzip", "application/x-bzip2", "application/x-xz",
+	"application/zstd", "application/x-7z-compressed", "application/x-rar-compressed",
+	"application/vnd.rar", "application/x-lzip", "application/jar", "application/x-compress",
+	"application/epub+zip", "application/pdf", "image/jpeg", "image/png", "image/gif",
+	"image/webp", "image/heic", "image/avif", "audio/mpeg", "audio/ogg", "audio/aac",
+	"audio/mp4", "audio/x-flac",
+}
+
+var compressedMimePrefixes = []string{
+	"video/", "application/vnd.openxmlformats-officedocument.", "application/vnd.oasis.opendocument.",
+}
+
+// IsCompressedMime returns true when the content is already compressed and would not benefit from compression
+func IsCompressedMime(m *mimetype.MIME) bool {
+	for ; m != nil; m = m.Parent() {
+		for _, c := range compressedMimes {
+			if m.Is(c) {
+				return true
+			}
+		}
+		for _, p := range compressedMimePrefixes {
+			if strings.HasPrefix(m.String(), p) {
+				return true
+			}
+		}
+	}
+	return false
 }
@@ -0,0 +1,430 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/go-multierror"
+	"github.com/sirupsen/logrus"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrUnavailable is returned when no replica is healthy and up to date for a read
+var ErrUnavailable = errors.New("no replica available")
+
+type MirrorConfig struct {
+	// Replicas are the stores that receive every change
+	Replicas []Config `json:"replicas" yaml:"replicas"`
+	// WriteQuorum is the number of replicas that must complete a change. Default is the majority
+	WriteQuorum int `json:"writeQuorum" yaml:"writeQuorum"`
+	// RepairLog is a local file that keeps the changes missed by some replicas across restarts
+	RepairLog string `json:"repairLog" yaml:"repairLog"`
+}
+
+// MirrorMiss is a change that a replica did not receive. Source is a replica that completed it
+type MirrorMiss struct {
+	Replica int    `json:"replica"`
+	Source  int    `json:"source"`
+	Name    string `json:"name"`
+}
+
+// Mirror replicates changes to several file storages synchronously and reads from the fastest replica
+type Mirror struct {
+	Replicas  []FS
+	Quorum    int
+	RepairLog string
+	lock      sync.Mutex
+	latency   []time.Duration
+	missed    map[MirrorMiss]bool
+}
+
+func NewMirror(replicas []FS, quorum int, repairLog string) (FS, error) {
+	if len(replicas) == 0 {
+		return nil, fmt.Errorf("mirror without replicas: %w", os.ErrInvalid)
+	}
+	if quorum <= 0 {
+		quorum = len(replicas)/2 + 1
+	}
+	if quorum > len(replicas) {
+		return nil, fmt.Errorf("write quorum %d higher than replicas: %w", quorum, os.ErrInvalid)
+	}
+
+	m := &Mirror{
+		Replicas:  replicas,
+		Quorum:    quorum,
+		RepairLog: repairLog,
+		latency:   make([]time.Duration, len(replicas)),
+		missed:    map[MirrorMiss]bool{},
+	}
+	if repairLog != "" {
+		data, err := os.ReadFile(repairLog)
+		if err == nil {
+			var misses []MirrorMiss
+			if err = json.Unmarshal(data, &misses); err != nil {
+				return nil, fmt.Errorf("invalid repair log %s: %v", repairLog, err)
+			}
+			for _, miss := range misses {
+				m.missed[miss] = true
+			}
+		}
+	}
+	return m, nil
+}
+
+func newMirrorFromConfig(c MirrorConfig) (FS, error) {
+	var replicas []FS
+	for _, rc := range c.Replicas {
+		r, err := NewFS(rc)
+		if err != nil {
+			for _, r := range replicas {
+				_ = r.Close()
+			}
+			return nil, err
+		}
+		replicas = append(replicas, r)
+	}
+	return NewMirror(replicas, c.WriteQuorum, c.RepairLog)
+}
+
+// Missed returns the changes that some replicas did not receive
+func (m *Mirror) Missed() []MirrorMiss {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var misses []MirrorMiss
+	for miss := range m.missed {
+		misses = append(misses, miss)
+	}
+	sort.Slice(misses, func(i, j int) bool {
+		if misses[i].Name == misses[j].Name {
+			return misses[i].Replica < misses[j].Replica
+		}
+		return misses[i].Name < misses[j].Name
+	})
+	return misses
+}
+
+// saveRepairLog must be called with the lock held
+func (m *Mirror) saveRepairLog() {
+	if m.RepairLog == "" {
+		return
+	}
+	var misses []MirrorMiss
+	for miss := range m.missed {
+		misses = append(misses, miss)
+	}
+	data, _ := json.Marshal(misses)
+	if err := os.WriteFile(m.RepairLog, data, 0600); err != nil {
+		logrus.Errorf("cannot save mirror repair log %s: %v", m.RepairLog, err)
+	}
+}
+
+func (m *Mirror) recordMisses(names []string, errs []error) {
+	source := -1
+	for i, err := range errs {
+		if err == nil {
+			source = i
+			break
+		}
+	}
+	if source < 0 {
+		return
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		logrus.Warnf("replica %s missed a change on %s: %v", m.Replicas[i], strings.Join(names, ","), err)
+		for _, n := range names {
+			m.missed[MirrorMiss{Replica: i, Source: source, Name: n}] = true
+		}
+	}
+	m.saveRepairLog()
+}
+
+// Repair aligns the replicas that missed some changes with the replica that completed them
+func (m *Mirror) Repair() error {
+	var me *multierror.Error
+	for _, miss := range m.Missed() {
+		src, dest := m.Replicas[miss.Source], m.Replicas[miss.Replica]
+		var err error
+		l, statErr := src.Stat(miss.Name)
+		switch {
+		case os.IsNotExist(statErr):
+			err = dest.Remove(miss.Name)
+			if os.IsNotExist(err) {
+				err = nil
+			}
+		case statErr != nil:
+			err = statErr
+		case l.IsDir():
+			err = dest.MkdirAll(miss.Name)
+		default:
+			err = Copy(src, dest, miss.Name, miss.Name, true, 0)
+		}
+		if err != nil {
+			me = multierror.Append(me, fmt.Errorf("cannot repair %s on %s: %w", miss.Name, dest, err))
+			continue
+		}
+
+		m.lock.Lock()
+		delete(m.missed, miss)
+		m.saveRepairLog()
+		m.lock.Unlock()
+	}
+	return me.ErrorOrNil()
+}
+
+// fanOut runs op on all the healthy replicas in parallel and checks the write quorum.
+// skip, when not nil, is called for the replicas that are not healthy
+func (m *Mirror) fanOut(op func(i int, f FS) error, skip func(i int), names ...string) error {
+	errs := make([]error, len(m.Replicas))
+	var wg sync.WaitGroup
+	for i, r := range m.Replicas {
+		wg.Add(1)
+		go func(i int, r FS) {
+			defer wg.Done()
+			if errs[i] = Health(r); errs[i] == nil {
+				errs[i] = op(i, r)
+			} else if skip != nil {
+				skip(i)
+			}
+		}(i, r)
+	}
+	wg.Wait()
+
+	var me *multierror.Error
+	ok := 0
+	for _, err := range errs {
+		if err == nil {
+			ok++
+		} else {
+			me = multierror.Append(me, err)
+		}
+	}
+	if ok >= m.Quorum {
+		m.recordMisses(names, errs)
+		return nil
+	}
+	// the change is not committed, so Repair must not bring it to the other replicas
+	if ok > 0 {
+		logrus.Warnf("replicas of %s may differ on %s after a failed change", m, strings.Join(names, ","))
+	}
+	if ok == 0 && len(me.Errors) > 0 && os.IsNotExist(me.Errors[0]) {
+		return me.Errors[0]
+	}
+	return fmt.Errorf("write quorum not reached (%d/%d): %w", ok, m.Quorum, me.ErrorOrNil())
+}
+
+// stale returns true when the replica i missed a change on name or, with inDir, on a file in the folder name.
+// The lock must be held
+func (m *Mirror) stale(i int, name string, inDir bool) bool {
+	name = path.Clean(name)
+	for miss := range m.missed {
+		if miss.Replica == i && (path.Clean(miss.Name) == name || inDir && path.Dir(miss.Name) == name) {
+			return true
+		}
+	}
+	return false
+}
+
+// order returns the healthy replicas that did not miss changes on name sorted by latency, and
+// the reasons why the other replicas are skipped
+func (m *Mirror) order(name string, inDir bool) ([]int, []error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var idx []int
+	var skipped []error
+	for i, r := range m.Replicas {
+		if err := Health(r); err != nil {
+			skipped = append(skipped, fmt.Errorf("replica %s is unhealthy: %w", r, err))
+		} else if m.stale(i, name, inDir) {
+			skipped = append(skipped, fmt.Errorf("replica %s missed changes on %s", r, name))
+		} else {
+			idx = append(idx, i)
+		}
+	}
+	sort.SliceStable(idx, func(i, j int) bool {
+		return m.latency[idx[i]] < m.latency[idx[j]]
+	})
+	return idx, skipped
+}
+
+func (m *Mirror) measure(i int, start time.Time) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	d := time.Since(start)
+	if m.latency[i] == 0 {
+		m.latency[i] = d
+	} else {
+		m.latency[i] = (m.latency[i]*7 + d) / 8
+	}
+}
+
+// read runs op on the fastest replica that is up to date on name and moves to the next one on failure,
+// when op allows it. With inDir, name is a folder whose files must be up to date. When no replica
+// can be read, the error wraps ErrUnavailable and the reasons
+func (m *Mirror) read(name string, inDir bool, op func(f FS) error, canRetry func() bool) error {
+	idx, skipped := m.order(name, inDir)
+	if len(idx) == 0 {
+		return multierror.Append(fmt.Errorf("%w for %s", ErrUnavailable, name), skipped...)
+	}
+
+	var err error
+	for n, i := range idx {
+		if n > 0 && canRetry != nil && !canRetry() {
+			break
+		}
+		start := time.Now()
+		err = op(m.Replicas[i])
+		m.measure(i, start)
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func (m *Mirror) Props() Props {
+	return m.Replicas[0].Props()
+}
+
+// Healthy returns an error when the healthy replicas are not enough for the write quorum
+func (m *Mirror) Healthy() error {
+	var n int
+	for _, r := range m.Replicas {
+		if Health(r) == nil {
+			n++
+		}
+	}
+	if n < m.Quorum {
+		return fmt.Errorf("only %d replicas are healthy, write quorum is %d", n, m.Quorum)
+	}
+	return nil
+}
+
+func (m *Mirror) ReadDir(name string, opts ListOption) (ls []fs.FileInfo, err error) {
+	err = m.read(name, true, func(f FS) error {
+		ls, err = f.ReadDir(name, opts)
+		return err
+	}, nil)
+	return ls, err
+}
+
+func (m *Mirror) Stat(name string) (l fs.FileInfo, err error) {
+	err = m.read(name, false, func(f FS) error {
+		l, err = f.Stat(name)
+		return err
+	}, nil)
+	return l, err
+}
+
+func (m *Mirror) Remove(name string) error {
+	return m.fanOut(func(_ int, f FS) error {
+		return f.Remove(name)
+	}, nil, name)
+}
+
+func (m *Mirror) Touch(name string) error {
+	return m.fanOut(func(_ int, f FS) error {
+		return f.Touch(name)
+	}, nil, name)
+}
+
+func (m *Mirror) Watch(name string) chan string {
+	return m.Replicas[0].Watch(name)
+}
+
+func (m *Mirror) Rename(old, new string) error {
+	return m.fanOut(func(_ int, f FS) error {
+		return f.Rename(old, new)
+	}, nil, old, new)
+}
+
+func (m *Mirror) MkdirAll(name string) error {
+	return m.fanOut(func(_ int, f FS) error {
+		return f.MkdirAll(name)
+	}, nil, name)
+}
+
+// Pull moves to another replica only when nothing has been written to w yet
+func (m *Mirror) Pull(name string, w io.Writer) error {
+	cw := &countingWriter{W: w}
+	return m.read(name, false, func(f FS) error {
+		return f.Pull(name, cw)
+	}, func() bool {
+		return cw.Cnt == 0
+	})
+}
+
+// Push streams the content to all the replicas. A replica that fails is dropped without stopping the others
+func (m *Mirror) Push(name string, r io.Reader) error {
+	readers := make([]*io.PipeReader, len(m.Replicas))
+	writers := make([]*io.PipeWriter, len(m.Replicas))
+	for i := range m.Replicas {
+		readers[i], writers[i] = io.Pipe()
+	}
+
+	go func() {
+		alive := make([]bool, len(writers))
+		for i := range alive {
+			alive[i] = true
+		}
+		buf := make([]byte, 64*1024)
+		for {
+			n, err := r.Read(buf)
+			for i, w := range writers {
+				if alive[i] && n > 0 {
+					if _, werr := w.Write(buf[0:n]); werr != nil {
+						alive[i] = false
+					}
+				}
+			}
+			if err != nil {
+				if err == io.EOF {
+					err = nil
+				}
+				for _, w := range writers {
+					_ = w.CloseWithError(err)
+				}
+				return
+			}
+		}
+	}()
+
+	// closing a reader unblocks the fan out when a replica stops reading
+	return m.fanOut(func(i int, f FS) error {
+		err := f.Push(name, readers[i])
+		_ = readers[i].CloseWithError(io.ErrClosedPipe)
+		return err
+	}, func(i int) {
+		_ = readers[i].CloseWithError(io.ErrClosedPipe)
+	}, name)
+}
+
+func (m *Mirror) Close() error {
+	var me *multierror.Error
+	for _, r := range m.Replicas {
+		me = multierror.Append(me, r.Close())
+	}
+	return me.ErrorOrNil()
+}
+
+func (m *Mirror) String() string {
+	var names []string
+	for _, r := range m.Replicas {
+		names = append(names, r.String())
+	}
+	return fmt.Sprintf("mirror(%s)", strings.Join(names, "|"))
+}
@@ -0,0 +1,58 @@
+package store
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMirror(t *testing.T) {
+	a, b := NewMemory(nil, 0), NewMemory(nil, 0)
+	broken := &flakyFS{FS: NewMemory(nil, 0), Fails: 1}
+	f, err := NewMirror([]FS{a, b, broken}, 2, "")
+	assert.NoError(t, err)
+	m := f.(*Mirror)
+
+	name := "data/mirror.txt"
+	assert.NoError(t, broken.FS.Push(name, bytes.NewBufferString("stale")))
+	assert.NoError(t, m.Push(name, bytes.NewBufferString("replicated")))
+	for _, r := range []FS{a, b} {
+		data, err := ReadFile(r, name)
+		assert.NoError(t, err)
+		assert.Equal(t, "replicated", string(data))
+	}
+	assert.Equal(t, []MirrorMiss{{Replica: 2, Source: 0, Name: name}}, m.Missed())
+
+	// the replica that missed the change is not read even when it is the fastest
+	m.latency = []time.Duration{time.Second, time.Second, time.Millisecond}
+	data, err := ReadFile(m, name)
+	assert.NoError(t, err)
+	assert.Equal(t, "replicated", string(data))
+
+	assert.NoError(t, m.Repair())
+	assert.Empty(t, m.Missed())
+	data, _ = ReadFile(broken.FS, name)
+	assert.Equal(t, "replicated", string(data))
+
+	broken.Calls, broken.Fails = 0, 100
+	other := &flakyFS{FS: NewMemory(nil, 0), Fails: 100}
+	f, _ = NewMirror([]FS{a, broken, other}, 2, "")
+	assert.Error(t, f.Push(name, bytes.NewBufferString("lost")))
+	assert.Empty(t, f.(*Mirror).Missed())
+}
+
+func TestMirrorUnavailable(t *testing.T) {
+	f, err := NewMirror([]FS{NewMemory(nil, 0), NewMemory(nil, 0)}, 1, "")
+	assert.NoError(t, err)
+	m := f.(*Mirror)
+
+	name := "data/mirror.txt"
+	m.missed[MirrorMiss{Replica: 0, Source: 1, Name: name}] = true
+	m.missed[MirrorMiss{Replica: 1, Source: 0, Name: name}] = true
+	_, err = m.Stat(name)
+	assert.ErrorIs(t, err, ErrUnavailable)
+	assert.False(t, os.IsNotExist(err))
+	assert.Contains(t, err.Error(), "missed changes")
+}
@@ -1,76 +1,372 @@
 package store
 
 import (
+	"errors"
 	"fmt"
+	"github.com/sirupsen/logrus"
 	"io"
 	"io/fs"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 )
 
+// QuotaLimit is a limit on the used space. A write that would go over Hard fails with ErrOffQuota,
+// while going over Soft only raises a warning. Zero means no limit
+type QuotaLimit struct {
+	Soft int64 `json:"soft" yaml:"soft"`
+	Hard int64 `json:"hard" yaml:"hard"`
+}
+
+type QuotaConfig struct {
+	// QuotaLimit applies to the whole store
+	QuotaLimit `yaml:",inline"`
+	// Dirs are limits on the space used by the files in a folder and its subfolders
+	Dirs map[string]QuotaLimit `json:"dirs" yaml:"dirs"`
+	// Groups are limits on the space used by the files of a group, as set in their Attr
+	Groups map[Group]QuotaLimit `json:"groups" yaml:"groups"`
+	// Ledger is a file in the store that keeps the usage, so that it is not computed on every start
+	Ledger string `json:"ledger" yaml:"ledger"`
+}
+
+// QuotaUsage is the space used in the store, in its folders and by its groups
+type QuotaUsage struct {
+	Total  int64            `json:"total"`
+	Dirs   map[string]int64 `json:"dirs"`
+	Groups map[Group]int64  `json:"groups"`
+}
+
+// ErrQuotaConflict is returned when a store already has an accountant with a different config
+var ErrQuotaConflict = errors.New("conflicting quota config")
+
+// QuotaAccountant keeps the usage of a store. It is shared by all the QuotaFS on the same store instance
+type QuotaAccountant struct {
+	F      FS
+	Config QuotaConfig
+	lock   sync.Mutex
+	usage  QuotaUsage
+	warned map[string]bool
+}
+
+var quotaAccountants = map[FS]*QuotaAccountant{}
+var quotaAccountantsLock sync.Mutex
+
+// GetQuotaAccountant returns the accountant for the store f, creating it when needed. Stores are told
+// apart by identity, since different stores can have the same String. A config different from the one
+// of the existing accountant fails with ErrQuotaConflict. When no ledger is available the usage is
+// computed by walking the store
+func GetQuotaAccountant(f FS, config QuotaConfig) (*QuotaAccountant, error) {
+	quotaAccountantsLock.Lock()
+	defer quotaAccountantsLock.Unlock()
+
+	shared := reflect.TypeOf(f).Comparable()
+	if a, ok := quotaAccountants[f]; shared && ok {
+		if !reflect.DeepEqual(a.Config, config) {
+			return nil, fmt.Errorf("%w on %s", ErrQuotaConflict, f)
+		}
+		return a, nil
+	}
+
+	a := newQuotaAccountant(f, config)
+	if config.Ledger != "" {
+		err := ReadJSON(f, config.Ledger, &a.usage)
+		if err == nil {
+			if a.usage.Dirs == nil {
+				a.usage.Dirs = map[string]int64{}
+			}
+			if a.usage.Groups == nil {
+				a.usage.Groups = map[Group]int64{}
+			}
+			if shared {
+				quotaAccountants[f] = a
+			}
+			return a, nil
+		}
+		logrus.Infof("no usage ledger %s on %s, computing the usage: %v", config.Ledger, f, err)
+	}
+
+	entries, err := a.entries("")
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		a.usage.add(e.name, e.group, e.size)
+	}
+	a.save()
+	if shared {
+		quotaAccountants[f] = a
+	}
+	return a, nil
+}
+
+func newQuotaAccountant(f FS, config QuotaConfig) *QuotaAccountant {
+	return &QuotaAccountant{
+		F:      f,
+		Config: config,
+		usage:  QuotaUsage{Dirs: map[string]int64{}, Groups: map[Group]int64{}},
+		warned: map[string]bool{},
+	}
+}
+
+type quotaEntry struct {
+	name  string
+	group Group
+	size  int64
+}
+
+// entries returns the files under name, which can be a file or a folder
+func (a *QuotaAccountant) entries(name string) ([]quotaEntry, error) {
+	l, err := a.F.Stat(name)
+	if name != "" && err != nil {
+		return nil, err
+	}
+	if err == nil && !l.IsDir() {
+		return []quotaEntry{{path.Clean(name), a.group(name), l.Size()}}, nil
+	}
+
+	var entries []quotaEntry
+	err = Walk(a.F, name, IncludeHiddenFiles, func(dir string, file fs.FileInfo) {
+		n := path.Join(dir, file.Name())
+		if n != a.Config.Ledger {
+			entries = append(entries, quotaEntry{n, a.group(n), file.Size()})
+		}
+	})
+	return entries, err
+}
+
+// group returns the group in the Attr of name. Meta files do not belong to any group
+func (a *QuotaAccountant) group(name string) Group {
+	if IsMeta(name) {
+		return ""
+	}
+	var attr Attr
+	_ = GetMeta(a.F, name, &attr)
+	return attr.Group
+}
+
+func (u *QuotaUsage) add(name string, group Group, size int64) {
+	u.Total += size
+	for d := path.Dir(path.Clean(name)); d != "." && d != "/"; d = path.Dir(d) {
+		u.Dirs[d] += size
+	}
+	if group != "" {
+		u.Groups[group] += size
+	}
+}
+
+// save writes the ledger. It must be called with the lock held
+func (a *QuotaAccountant) save() {
+	if a.Config.Ledger == "" {
+		return
+	}
+	if err := WriteJSON(a.F, a.Config.Ledger, a.usage); err != nil {
+		logrus.Errorf("cannot save usage ledger %s on %s: %v", a.Config.Ledger, a.F, err)
+	}
+}
+
+// Usage returns a copy of the current usage
+func (a *QuotaAccountant) Usage() QuotaUsage {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	u := QuotaUsage{Total: a.usage.Total, Dirs: map[string]int64{}, Groups: map[Group]int64{}}
+	for d, s := range a.usage.Dirs {
+		u.Dirs[d] = s
+	}
+	for g, s := range a.usage.Groups {
+		u.Groups[g] = s
+	}
+	return u
+}
+
+// limits calls check for each limit that applies to name in the group
+func (a *QuotaAccountant) limits(name string, group Group, check func(label string, used int64, l QuotaLimit) bool) bool {
+	if !check("total", a.usage.Total, a.Config.QuotaLimit) {
+		return false
+	}
+	name = path.Clean(name)
+	for d, l := range a.Config.Dirs {
+		d = path.Clean(d)
+		if strings.HasPrefix(name, d+"/") && !check("dir "+d, a.usage.Dirs[d], l) {
+			return false
+		}
+	}
+	if l, ok := a.Config.Groups[group]; ok && group != "" {
+		return check("group "+string(group), a.usage.Groups[group], l)
+	}
+	return true
+}
+
+// reserve adds size to the usage of name when all the hard limits allow it
+func (a *QuotaAccountant) reserve(name string, group Group, size int64) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	ok := a.limits(name, group, func(label string, used int64, l QuotaLimit) bool {
+		return l.Hard <= 0 || used+size <= l.Hard
+	})
+	if !ok {
+		return ErrOffQuota
+	}
+	a.usage.add(name, group, size)
+	return nil
+}
+
+// update changes the usage by the given entries with a positive or negative sign and saves the ledger
+func (a *QuotaAccountant) update(entries []quotaEntry, sign int64) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	for _, e := range entries {
+		a.usage.add(e.name, e.group, sign*e.size)
+	}
+	a.warn()
+	a.save()
+}
+
+// warnings returns the soft limits that are exceeded by label. It must be called with the lock held
+func (a *QuotaAccountant) warnings() map[string]string {
+	ws := map[string]string{}
+	check := func(label string, used int64, l QuotaLimit) {
+		if l.Soft > 0 && used > l.Soft {
+			ws[label] = fmt.Sprintf("%s uses %d bytes over a soft quota of %d", label, used, l.Soft)
+		}
+	}
+	check("total", a.usage.Total, a.Config.QuotaLimit)
+	for d, l := range a.Config.Dirs {
+		check("dir "+path.Clean(d), a.usage.Dirs[path.Clean(d)], l)
+	}
+	for g, l := range a.Config.Groups {
+		check("group "+string(g), a.usage.Groups[g], l)
+	}
+	return ws
+}
+
+// warn logs the soft limits when they are exceeded for the first time. It must be called with the lock held
+func (a *QuotaAccountant) warn() {
+	current := map[string]bool{}
+	for label, w := range a.warnings() {
+		current[label] = true
+		if !a.warned[label] {
+			logrus.Warnf("%s: %s", a.F, w)
+		}
+	}
+	a.warned = current
+}
+
+// Warning returns a description of the soft limits that are exceeded, or an empty string
+func (a *QuotaAccountant) Warning() string {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	var ws []string
+	for _, w := range a.warnings() {
+		ws = append(ws, w)
+	}
+	sort.Strings(ws)
+	return strings.Join(ws, "; ")
+}
+
 type QuotaFS struct {
-	F       FS
-	Limit   int64
-	Current int64
+	F          FS
+	Accountant *QuotaAccountant
 }
 
+// NewQuota limits the space used in f to limit bytes
 func NewQuota(f FS, limit int64) FS {
-	var current int64 = 0
-	_ = Walk(f, "", IncludeHiddenFiles, func(dir string, file fs.FileInfo) {
-		current += file.Size()
-	})
+	config := QuotaConfig{QuotaLimit: QuotaLimit{Hard: limit}}
+	q, err := NewQuotaWithConfig(f, config)
+	if err != nil {
+		logrus.Errorf("cannot compute the usage of %s: %v", f, err)
+		return &QuotaFS{f, newQuotaAccountant(f, config)}
+	}
+	return q
+}
 
-	return QuotaFS{
-		F:       f,
-		Limit:   limit,
-		Current: current,
+func NewQuotaWithConfig(f FS, config QuotaConfig) (FS, error) {
+	a, err := GetQuotaAccountant(f, config)
+	if err != nil {
+		return nil, err
 	}
+	return &QuotaFS{f, a}, nil
 }
 
-func (q QuotaFS) Props() Props {
-	return Props{
-		Quota:       q.Limit,
-		Free:        q.Limit - q.Current,
-		MinFileSize: 0,
-		MaxFileSize: q.Limit,
+func (q *QuotaFS) Props() Props {
+	props := q.F.Props()
+	usage := q.Accountant.Usage()
+	if limit := q.Accountant.Config.Hard; limit > 0 {
+		props.Quota = limit
+		props.Free = limit - usage.Total
+		if props.Free < 0 {
+			props.Free = 0
+		}
+		props.MaxFileSize = limit
 	}
+	props.Warning = q.Accountant.Warning()
+	return props
 }
 
-func (q QuotaFS) MkdirAll(name string) error {
+func (q *QuotaFS) MkdirAll(name string) error {
 	return q.F.MkdirAll(name)
 }
 
-func (q QuotaFS) ReadDir(name string, opts ListOption) ([]fs.FileInfo, error) {
+func (q *QuotaFS) ReadDir(name string, opts ListOption) ([]fs.FileInfo, error) {
 	return q.F.ReadDir(name, opts)
 }
 
-func (q QuotaFS) Watch(name string) chan string {
+func (q *QuotaFS) Watch(name string) chan string {
 	return q.F.Watch(name)
 }
 
-func (q QuotaFS) Stat(name string) (fs.FileInfo, error) {
+func (q *QuotaFS) Stat(name string) (fs.FileInfo, error) {
 	return q.F.Stat(name)
 }
 
-func (q QuotaFS) Remove(name string) error {
-	l, err := q.F.Stat(name)
-
-	if err == nil {
-		err = q.F.Remove(name)
-		if err == nil {
-			q.Current -= l.Size()
-		}
+func (q *QuotaFS) Remove(name string) error {
+	entries, err := q.Accountant.entries(name)
+	if err != nil {
+		return err
+	}
+	if err = q.F.Remove(name); err == nil {
+		q.Accountant.update(entries, -1)
 	}
 	return err
 }
 
-func (q QuotaFS) Touch(name string) error {
+func (q *QuotaFS) Touch(name string) error {
 	return q.F.Touch(name)
 }
 
-func (q QuotaFS) Rename(old, new string) error {
-	return q.F.Rename(old, new)
+// Rename moves the usage between folders. The group of the files does not change. A file replaced by
+// the rename does not use space anymore
+func (q *QuotaFS) Rename(old, new string) error {
+	entries, err := q.Accountant.entries(old)
+	if err != nil {
+		return err
+	}
+	var replaced []quotaEntry
+	if l, err := q.F.Stat(new); err == nil && !l.IsDir() && path.Clean(new) != path.Clean(old) {
+		replaced, _ = q.Accountant.entries(new)
+	}
+	if err = q.F.Rename(old, new); err != nil {
+		return err
+	}
+	q.Accountant.update(replaced, -1)
+
+	old = path.Clean(old)
+	var moved []quotaEntry
+	for _, e := range entries {
+		moved = append(moved, quotaEntry{path.Join(new, strings.TrimPrefix(e.name, old)), e.group, e.size})
+	}
+	q.Accountant.update(entries, -1)
+	q.Accountant.update(moved, 1)
+	return nil
 }
 
-func (q QuotaFS) Pull(name string, w io.Writer) error {
+func (q *QuotaFS) Pull(name string, w io.Writer) error {
 	return q.F.Pull(name, w)
 }
 
@@ -85,24 +381,147 @@ func (r *CountingReader) Read(bs []byte) (int, error) {
 	return n, err
 }
 
-func (q QuotaFS) Push(name string, r io.Reader) error {
-	if q.Current > q.Limit {
-		return ErrOffQuota
+// quotaReader reserves the space while the data flows, so that the write stops as soon as a limit is hit.
+// The space of the replaced file is available to the new content
+type quotaReader struct {
+	R        io.Reader
+	a        *QuotaAccountant
+	name     string
+	group    Group
+	replaced int64
+	cnt      int64
+	reserved int64
+}
+
+func (r *quotaReader) Read(bs []byte) (int, error) {
+	n, err := r.R.Read(bs)
+	r.cnt += int64(n)
+	if excess := r.cnt - r.replaced; excess > r.reserved {
+		if rerr := r.a.reserve(r.name, r.group, excess-r.reserved); rerr != nil {
+			return 0, rerr
+		}
+		r.reserved = excess
 	}
-	var size int64
+	return n, err
+}
+
+// replace renames tmp over name and keeps the meta of name, where it is stored
+func (q *QuotaFS) replace(tmp, name string) error {
+	var native, sidecar []byte
+	n, hasNative := nativeMeta(q.F)
+	if hasNative {
+		native, _ = n.ReadMeta(name)
+	}
+	if !IsMeta(name) {
+		sidecar, _ = ReadFile(q.F, metaName(name))
+	}
+
+	err := q.F.Rename(tmp, name)
+	if err != nil {
+		// some storages do not rename over an existing file
+		if err = q.F.Remove(name); err == nil {
+			err = q.F.Rename(tmp, name)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if native != nil {
+		if err = n.WriteMeta(name, native); err != nil {
+			return err
+		}
+	}
+	if _, err = q.F.Stat(metaName(name)); sidecar != nil && err != nil {
+		return WriteFile(q.F, metaName(name), sidecar)
+	}
+	return nil
+}
+
+// Push writes an existing file under a temporary name and renames it over the file when the write is
+// complete, so that a write stopped by a limit, or failing for any other reason, leaves the former
+// content in place. The partial content of a new file is removed
+func (q *QuotaFS) Push(name string, r io.Reader) error {
+	target := name
+	if IsMeta(name) {
+		target = metaTarget(name)
+	}
+
+	var replaced int64
+	dest := name
 	if l, err := q.F.Stat(name); err == nil {
+		replaced = l.Size()
+		dir, base := path.Split(name)
+		dest = path.Join(dir, fmt.Sprintf(".%s.%d.quota~", base, time.Now().UnixNano()))
+	}
+	group := q.Accountant.group(name)
+	var oldGroup Group
+	var targetSize int64
+	if target != name {
+		oldGroup = q.Accountant.group(target)
+		if l, err := q.F.Stat(target); err == nil {
+			targetSize = l.Size()
+		}
+	}
+
+	qr := &quotaReader{R: r, a: q.Accountant, name: name, group: group, replaced: replaced}
+	err := q.F.Push(dest, qr)
+	if err == nil && dest != name {
+		err = q.replace(dest, name)
+	}
+	if err != nil {
+		_ = q.F.Remove(dest)
+		q.Accountant.update([]quotaEntry{{name, group, qr.reserved}}, -1)
+		return err
+	}
+
+	// the reservation covers only the growth beyond the replaced file
+	q.Accountant.update([]quotaEntry{{name, group, qr.cnt - replaced - qr.reserved}}, 1)
+
+	if target != name {
+		q.regroup(target, oldGroup, targetSize)
+	}
+	return nil
+}
+
+// regroup moves the usage of name when a change of meta moved it to another group than oldGroup
+func (q *QuotaFS) regroup(name string, oldGroup Group, size int64) {
+	if newGroup := q.Accountant.group(name); newGroup != oldGroup {
+		q.Accountant.update([]quotaEntry{{name, oldGroup, size}}, -1)
+		q.Accountant.update([]quotaEntry{{name, newGroup, size}}, 1)
+	}
+}
+
+// ReadMeta reads the native meta of the inner store, when available
+func (q *QuotaFS) ReadMeta(name string) ([]byte, error) {
+	n, ok := nativeMeta(q.F)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	return n.ReadMeta(name)
+}
+
+// WriteMeta writes the native meta of the inner store and moves the usage of name when its group changes
+func (q *QuotaFS) WriteMeta(name string, data []byte) error {
+	n, ok := nativeMeta(q.F)
+	if !ok {
+		return ErrNotSupported
+	}
+	oldGroup := q.Accountant.group(name)
+	var size int64
+	if l, err := q.F.Stat(name); err == nil && !l.IsDir() {
 		size = l.Size()
 	}
-	cr := CountingReader{r, 0}
-	err := q.F.Push(name, &cr)
-	q.Current += cr.Cnt - size
-	return err
+	if err := n.WriteMeta(name, data); err != nil {
+		return err
+	}
+	q.regroup(name, oldGroup, size)
+	return nil
 }
 
-func (q QuotaFS) Close() error {
+func (q *QuotaFS) Close() error {
 	return q.F.Close()
 }
 
-func (q QuotaFS) String() string {
-	return fmt.Sprintf("%s#quota%d", q.F, q.Limit)
+func (q *QuotaFS) String() string {
+	return fmt.Sprintf("%s#quota%d", q.F, q.Accountant.Config.Hard)
 }
@@ -0,0 +1,101 @@
+package store
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestQuota(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "stg/test/quota")
+	_ = os.RemoveAll(dir)
+	_ = os.MkdirAll(dir, 0755)
+	l := NewLocalMount(dir)
+
+	config := QuotaConfig{
+		QuotaLimit: QuotaLimit{Hard: 1000},
+		Dirs:       map[string]QuotaLimit{"a": {Soft: 100, Hard: 500}},
+		Groups:     map[Group]QuotaLimit{"g": {Hard: 450}},
+		Ledger:     ".quota.json",
+	}
+	f, err := NewQuotaWithConfig(l, config)
+	assert.NoError(t, err)
+	q := f.(*QuotaFS)
+
+	assert.NoError(t, q.Push("a/x", bytes.NewReader(make([]byte, 400))))
+	assert.Equal(t, int64(600), q.Props().Free)
+	assert.NotEmpty(t, q.Props().Warning)
+
+	err = q.Push("a/y", bytes.NewReader(make([]byte, 200)))
+	assert.ErrorIs(t, err, ErrOffQuota)
+	assert.Equal(t, int64(400), q.Accountant.Usage().Total)
+
+	assert.NoError(t, q.Rename("a/x", "b/x"))
+	usage := q.Accountant.Usage()
+	assert.Equal(t, int64(0), usage.Dirs["a"])
+	assert.Equal(t, int64(400), usage.Dirs["b"])
+	assert.Empty(t, q.Props().Warning)
+
+	assert.NoError(t, SetMeta(q, "b/x", Attr{Group: "g"}))
+	assert.Equal(t, int64(400), q.Accountant.Usage().Groups["g"])
+	// the file would go over the group limit
+	err = q.Push("b/x", bytes.NewReader(make([]byte, 500)))
+	assert.ErrorIs(t, err, ErrOffQuota)
+	// the former content is still there
+	st, err := l.Stat("b/x")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(400), st.Size())
+	assert.Equal(t, int64(400), q.Accountant.Usage().Groups["g"])
+	ls, _ := l.ReadDir("b", IncludeHiddenFiles)
+	for _, f := range ls {
+		assert.NotContains(t, f.Name(), "quota~")
+	}
+	// the meta stays with the replaced file
+	assert.NoError(t, q.Push("b/x", bytes.NewReader(make([]byte, 300))))
+	var attr Attr
+	assert.NoError(t, GetMeta(q, "b/x", &attr))
+	assert.Equal(t, Group("g"), attr.Group)
+	assert.Equal(t, int64(300), q.Accountant.Usage().Groups["g"])
+
+	total := q.Accountant.Usage().Total
+	assert.NoError(t, q.Push("b/y", bytes.NewReader(make([]byte, 1000-total))))
+	err = q.Push("c", bytes.NewReader(make([]byte, 1)))
+	assert.ErrorIs(t, err, ErrOffQuota)
+
+	assert.NoError(t, q.Remove("b/y"))
+	assert.Equal(t, total, q.Accountant.Usage().Total)
+
+	// a file replaced by a rename does not use space anymore
+	assert.NoError(t, q.Push("b/v", bytes.NewReader(make([]byte, 50))))
+	assert.NoError(t, q.Push("b/w", bytes.NewReader(make([]byte, 60))))
+	assert.NoError(t, q.Rename("b/w", "b/v"))
+	assert.Equal(t, total+60, q.Accountant.Usage().Total)
+	assert.NoError(t, q.Remove("b/v"))
+
+	// the usage is read back from the ledger
+	f, err = NewQuotaWithConfig(NewLocalMount(dir), config)
+	assert.NoError(t, err)
+	assert.Equal(t, total, f.(*QuotaFS).Accountant.Usage().Total)
+
+	// the same store shares the accountant, but not with other limits
+	f, err = NewQuotaWithConfig(l, config)
+	assert.NoError(t, err)
+	assert.Same(t, q.Accountant, f.(*QuotaFS).Accountant)
+	_, err = NewQuotaWithConfig(l, QuotaConfig{QuotaLimit: QuotaLimit{Hard: 10}})
+	assert.ErrorIs(t, err, ErrQuotaConflict)
+	assert.Equal(t, int64(1000), q.Accountant.Config.Hard)
+}
+
+func TestQuotaSeparateStores(t *testing.T) {
+	a, err := NewQuotaWithConfig(NewMemory(nil, 0), QuotaConfig{QuotaLimit: QuotaLimit{Hard: 100}})
+	assert.NoError(t, err)
+	b, err := NewQuotaWithConfig(NewMemory(nil, 0), QuotaConfig{QuotaLimit: QuotaLimit{Hard: 200}})
+	assert.NoError(t, err)
+
+	assert.NoError(t, a.Push("x", bytes.NewReader(make([]byte, 50))))
+	assert.Equal(t, int64(50), a.(*QuotaFS).Accountant.Usage().Total)
+	assert.Equal(t, int64(0), b.(*QuotaFS).Accountant.Usage().Total)
+	assert.Equal(t, int64(100), a.(*QuotaFS).Accountant.Config.Hard)
+}
@@ -0,0 +1,80 @@
+package store
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+)
+
+// ReadOnly refuses all the changes to the file storage with os.ErrPermission
+type ReadOnly struct {
+	F FS
+}
+
+func NewReadOnly(f FS) FS {
+	return &ReadOnly{f}
+}
+
+func (r *ReadOnly) Props() Props {
+	props := r.F.Props()
+	props.Free = 0
+	return props
+}
+
+func (r *ReadOnly) ReadDir(name string, opts ListOption) ([]fs.FileInfo, error) {
+	return r.F.ReadDir(name, opts)
+}
+
+func (r *ReadOnly) Watch(name string) chan string {
+	return r.F.Watch(name)
+}
+
+func (r *ReadOnly) Stat(name string) (fs.FileInfo, error) {
+	return r.F.Stat(name)
+}
+
+// ReadMeta reads the native meta of the inner store, when available
+func (r *ReadOnly) ReadMeta(name string) ([]byte, error) {
+	n, ok := nativeMeta(r.F)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	return n.ReadMeta(name)
+}
+
+func (r *ReadOnly) WriteMeta(name string, _ []byte) error {
+	return fmt.Errorf("cannot change the meta of %s on read-only store: %w", name, os.ErrPermission)
+}
+
+func (r *ReadOnly) Remove(name string) error {
+	return fmt.Errorf("cannot remove %s on read-only store: %w", name, os.ErrPermission)
+}
+
+func (r *ReadOnly) Touch(name string) error {
+	return fmt.Errorf("cannot touch %s on read-only store: %w", name, os.ErrPermission)
+}
+
+func (r *ReadOnly) Rename(old, new string) error {
+	return fmt.Errorf("cannot rename %s on read-only store: %w", old, os.ErrPermission)
+}
+
+func (r *ReadOnly) MkdirAll(name string) error {
+	return fmt.Errorf("cannot create %s on read-only store: %w", name, os.ErrPermission)
+}
+
+func (r *ReadOnly) Pull(name string, w io.Writer) error {
+	return r.F.Pull(name, w)
+}
+
+func (r *ReadOnly) Push(name string, _ io.Reader) error {
+	return fmt.Errorf("cannot write %s on read-only store: %w", name, os.ErrPermission)
+}
+
+func (r *ReadOnly) Close() error {
+	return r.F.Close()
+}
+
+func (r *ReadOnly) String() string {
+	return fmt.Sprintf("%s#ro", r.F)
+}
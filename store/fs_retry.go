@@ -0,0 +1,337 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"io/fs"
+	"math/rand"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Error classes used in RetryConfig.Retryable
+const (
+	RetryOnNetwork = "network"
+	RetryOnTimeout = "timeout"
+	RetryOnEOF     = "eof"
+	RetryOnServer  = "server"
+)
+
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+type RetryConfig struct {
+	// MaxAttempts is the number of times an operation is tried. Default is 3
+	MaxAttempts int `json:"maxAttempts" yaml:"maxAttempts"`
+	// InitialBackoff is the delay before the first retry. It doubles at every attempt
+	InitialBackoff time.Duration `json:"initialBackoff" yaml:"initialBackoff"`
+	// MaxBackoff caps the delay between attempts
+	MaxBackoff time.Duration `json:"maxBackoff" yaml:"maxBackoff"`
+	// Retryable lists the error classes worth a retry: network, timeout, eof, server
+	Retryable []string `json:"retryable" yaml:"retryable"`
+	// BufferLimit is the maximal size kept in memory to replay a Push from a reader that cannot seek
+	BufferLimit int64 `json:"bufferLimit" yaml:"bufferLimit"`
+	// BreakerThreshold is the number of consecutive failures that open the circuit breaker
+	BreakerThreshold int `json:"breakerThreshold" yaml:"breakerThreshold"`
+	// BreakerCooldown is how long the circuit stays open before a new attempt is allowed
+	BreakerCooldown time.Duration `json:"breakerCooldown" yaml:"breakerCooldown"`
+}
+
+// HealthChecker is implemented by file storages that track the health of the underlying service
+type HealthChecker interface {
+	Healthy() error
+}
+
+// Health returns an error when f, or a file storage wrapped by its decorators, reports the underlying
+// service as unhealthy
+func Health(f FS) error {
+	for ; f != nil; f = Unwrap(f) {
+		if h, ok := f.(HealthChecker); ok {
+			return h.Healthy()
+		}
+	}
+	return nil
+}
+
+// Retry repeats failed operations with an exponential backoff and stops calling
+// the file storage when too many consecutive operations fail
+type Retry struct {
+	F         FS
+	Config    RetryConfig
+	lock      sync.Mutex
+	failures  int
+	openUntil time.Time
+	lastErr   error
+}
+
+func NewRetry(f FS, config RetryConfig) FS {
+	if config.MaxAttempts == 0 {
+		config.MaxAttempts = 3
+	}
+	if config.InitialBackoff == 0 {
+		config.InitialBackoff = 200 * time.Millisecond
+	}
+	if config.MaxBackoff == 0 {
+		config.MaxBackoff = 10 * time.Second
+	}
+	if config.Retryable == nil {
+		config.Retryable = []string{RetryOnNetwork, RetryOnTimeout, RetryOnEOF}
+	}
+	if config.BufferLimit == 0 {
+		config.BufferLimit = 8 * 1024 * 1024
+	}
+	if config.BreakerThreshold == 0 {
+		config.BreakerThreshold = 5
+	}
+	if config.BreakerCooldown == 0 {
+		config.BreakerCooldown = time.Minute
+	}
+	return &Retry{
+		F:      f,
+		Config: config,
+	}
+}
+
+func errorClass(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, os.ErrNotExist), errors.Is(err, os.ErrPermission), errors.Is(err, os.ErrInvalid),
+		errors.Is(err, ErrNotSupported), errors.Is(err, ErrOffQuota):
+		return ""
+	case errors.As(err, &netErr) && netErr.Timeout(), errors.Is(err, os.ErrDeadlineExceeded):
+		return RetryOnTimeout
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return RetryOnEOF
+	case errors.As(err, &netErr), errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.ECONNREFUSED),
+		errors.Is(err, syscall.EPIPE), errors.Is(err, net.ErrClosed):
+		return RetryOnNetwork
+	case strings.Contains(err.Error(), "HTTP response: 5"):
+		return RetryOnServer
+	}
+	return ""
+}
+
+func (r *Retry) isRetryable(err error) bool {
+	class := errorClass(err)
+	for _, c := range r.Config.Retryable {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+// Healthy returns ErrCircuitOpen when the circuit breaker is open
+func (r *Retry) Healthy() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if time.Now().Before(r.openUntil) {
+		return fmt.Errorf("%w after %d failures: %v", ErrCircuitOpen, r.failures, r.lastErr)
+	}
+	return nil
+}
+
+func (r *Retry) record(err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if err == nil || !r.isRetryable(err) {
+		r.failures = 0
+		return
+	}
+	r.failures++
+	r.lastErr = err
+	if r.failures >= r.Config.BreakerThreshold {
+		r.openUntil = time.Now().Add(r.Config.BreakerCooldown)
+		logrus.Warnf("%s is unhealthy after %d failures: %v", r.F, r.failures, err)
+	}
+}
+
+func (r *Retry) backoff(attempt int) time.Duration {
+	d := r.Config.InitialBackoff << attempt
+	if d > r.Config.MaxBackoff || d <= 0 {
+		d = r.Config.MaxBackoff
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// do runs op until it succeeds, fails with an error that is not retryable or the attempts are over.
+// canRetry is called before each new attempt and can veto it
+func (r *Retry) do(op func() error, canRetry func() bool) error {
+	if err := r.Healthy(); err != nil {
+		return err
+	}
+
+	var err error
+	for attempt := 0; attempt < r.Config.MaxAttempts; attempt++ {
+		if attempt > 0 {
+			if canRetry != nil && !canRetry() {
+				break
+			}
+			d := r.backoff(attempt - 1)
+			logrus.Debugf("retry on %s in %s after error: %v", r.F, d, err)
+			time.Sleep(d)
+		}
+		err = op()
+		r.record(err)
+		if err == nil || !r.isRetryable(err) || r.Healthy() != nil {
+			break
+		}
+	}
+	return err
+}
+
+func (r *Retry) Props() Props {
+	return r.F.Props()
+}
+
+func (r *Retry) ReadDir(name string, opts ListOption) (ls []fs.FileInfo, err error) {
+	err = r.do(func() error {
+		ls, err = r.F.ReadDir(name, opts)
+		return err
+	}, nil)
+	return ls, err
+}
+
+func (r *Retry) Stat(name string) (l fs.FileInfo, err error) {
+	err = r.do(func() error {
+		l, err = r.F.Stat(name)
+		return err
+	}, nil)
+	return l, err
+}
+
+func (r *Retry) Remove(name string) error {
+	return r.do(func() error {
+		return r.F.Remove(name)
+	}, nil)
+}
+
+func (r *Retry) Touch(name string) error {
+	return r.do(func() error {
+		return r.F.Touch(name)
+	}, nil)
+}
+
+func (r *Retry) Watch(name string) chan string {
+	return r.F.Watch(name)
+}
+
+func (r *Retry) Rename(old, new string) error {
+	return r.do(func() error {
+		return r.F.Rename(old, new)
+	}, nil)
+}
+
+func (r *Retry) MkdirAll(name string) error {
+	return r.do(func() error {
+		return r.F.MkdirAll(name)
+	}, nil)
+}
+
+type truncater interface {
+	io.Seeker
+	Truncate(size int64) error
+}
+
+// retryWriter tracks what has been written during a Pull and the errors raised by the destination itself
+type retryWriter struct {
+	W   io.Writer
+	Cnt int64
+	Err error
+}
+
+func (w *retryWriter) Write(bs []byte) (int, error) {
+	n, err := w.W.Write(bs)
+	w.Cnt += int64(n)
+	if err != nil {
+		w.Err = err
+	}
+	return n, err
+}
+
+// writerError hides an error of the destination writer, which must not count as a failure of the storage
+type writerError struct {
+	err error
+}
+
+func (e writerError) Error() string {
+	return e.err.Error()
+}
+
+// Pull retries only when nothing was written to w yet or when w can be rewound, like a file
+func (r *Retry) Pull(name string, w io.Writer) error {
+	rw := &retryWriter{W: w}
+	err := r.do(func() error {
+		err := r.F.Pull(name, rw)
+		if rw.Err != nil {
+			return writerError{rw.Err}
+		}
+		return err
+	}, func() bool {
+		if rw.Cnt == 0 {
+			return true
+		}
+		if t, ok := w.(truncater); ok {
+			if pos, err := t.Seek(-rw.Cnt, io.SeekCurrent); err == nil && t.Truncate(pos) == nil {
+				rw.Cnt = 0
+				return true
+			}
+		}
+		return false
+	})
+	if we, ok := err.(writerError); ok {
+		return we.err
+	}
+	return err
+}
+
+// Push replays the content by seeking back on seekable readers or by buffering small streams in memory
+func (r *Retry) Push(name string, rd io.Reader) error {
+	if s, ok := rd.(io.ReadSeeker); ok {
+		start, err := s.Seek(0, io.SeekCurrent)
+		if err == nil {
+			return r.do(func() error {
+				return r.F.Push(name, s)
+			}, func() bool {
+				_, err := s.Seek(start, io.SeekStart)
+				return err == nil
+			})
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	_, err := io.CopyN(buf, rd, r.Config.BufferLimit+1)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if int64(buf.Len()) > r.Config.BufferLimit {
+		// too big to replay: a single attempt is possible
+		if err = r.Healthy(); err != nil {
+			return err
+		}
+		err = r.F.Push(name, io.MultiReader(buf, rd))
+		r.record(err)
+		return err
+	}
+
+	data := buf.Bytes()
+	return r.do(func() error {
+		return r.F.Push(name, bytes.NewReader(data))
+	}, nil)
+}
+
+func (r *Retry) Close() error {
+	return r.F.Close()
+}
+
+func (r *Retry) String() string {
+	return fmt.Sprintf("%s#retry", r.F)
+}
@@ -0,0 +1,62 @@
+package store
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// flakyFS fails the first Fails calls of Push and Pull with a network error
+type flakyFS struct {
+	FS
+	Fails int
+	Calls int
+}
+
+func (f *flakyFS) Push(name string, r io.Reader) error {
+	f.Calls++
+	if f.Calls <= f.Fails {
+		_, _ = io.CopyN(io.Discard, r, 4)
+		return &os.SyscallError{Syscall: "write", Err: syscall.ECONNRESET}
+	}
+	return f.FS.Push(name, r)
+}
+
+func (f *flakyFS) Pull(name string, w io.Writer) error {
+	f.Calls++
+	if f.Calls <= f.Fails {
+		return &os.SyscallError{Syscall: "read", Err: syscall.ECONNRESET}
+	}
+	return f.FS.Pull(name, w)
+}
+
+func TestRetry(t *testing.T) {
+	flaky := &flakyFS{FS: NewLocalMount(os.TempDir()), Fails: 2}
+	r := NewRetry(flaky, RetryConfig{
+		InitialBackoff:   time.Millisecond,
+		BreakerThreshold: 3,
+		BreakerCooldown:  time.Hour,
+	})
+
+	name := "stg/test/retry.txt"
+	assert.NoError(t, r.Push(name, bytes.NewBufferString("replayed content")))
+	assert.Equal(t, 3, flaky.Calls)
+
+	w := &ByteStream{}
+	flaky.Calls, flaky.Fails = 0, 1
+	assert.NoError(t, r.Pull(name, w))
+	assert.Equal(t, "replayed content", string(w.Data))
+
+	flaky.Calls, flaky.Fails = 0, 100
+	assert.Error(t, r.Pull(name, w))
+	assert.ErrorIs(t, Health(r), ErrCircuitOpen)
+	assert.ErrorIs(t, Health(NewReadOnly(r)), ErrCircuitOpen)
+	assert.ErrorIs(t, r.Pull(name, w), ErrCircuitOpen)
+	assert.Equal(t, 3, flaky.Calls)
+
+	_ = flaky.FS.Remove(name)
+}
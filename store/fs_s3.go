@@ -3,12 +3,14 @@ package store
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
 	"fmt"
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
 	"io"
 	"io/fs"
 	"math"
+	"os"
 	"path"
 	"strings"
 	"time"
@@ -19,7 +21,7 @@ type S3Config struct {
 	Bucket    string `json:"bucket" yaml:"bucket"`
 	Location  string `json:"location" yaml:"location"`
 	AccessKey string `json:"accessKey" yaml:"accessKey"`
-	Secret    string `json:"secret" yaml:"secret"`
+	Secret    string `json:"secret" yaml:"secret" secret:"true"`
 	UseSSL    bool   `json:"useSsl" yaml:"useSsl"`
 }
 
@@ -97,10 +99,102 @@ func (s3 *S3FS) Push(name string, r io.Reader) error {
 		return fmt.Errorf("file can not have / suffix")
 	}
 
-	_, err := s3.c.PutObject(ctx, s3.bucket, name, r, -1, minio.PutObjectOptions{})
+	// the meta survives an overwrite, as it does with a sidecar
+	var opts minio.PutObjectOptions
+	if info, err := s3.c.StatObject(ctx, s3.bucket, name, minio.StatObjectOptions{}); err == nil {
+		if v, ok := s3MetaValue(info.UserMetadata); ok {
+			opts.UserMetadata = map[string]string{s3MetaKey: v}
+		}
+	}
+	_, err := s3.c.PutObject(ctx, s3.bucket, name, r, -1, opts)
 	return err
 }
 
+// s3MetaKey is the user metadata that keeps the meta of an object, encoded in base64
+const s3MetaKey = "Bbfs-Meta"
+
+// s3MetaLimit is the space available for the meta in the 2KB of user metadata of an object
+const s3MetaLimit = 1800
+
+func s3MetaValue(userMetadata map[string]string) (string, bool) {
+	for k, v := range userMetadata {
+		if strings.EqualFold(k, s3MetaKey) {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// ReadMeta reads the meta from the user metadata of the object
+func (s3 *S3FS) ReadMeta(name string) ([]byte, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	info, err := s3.c.StatObject(ctx, s3.bucket, name, minio.StatObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	v, ok := s3MetaValue(info.UserMetadata)
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return base64.StdEncoding.DecodeString(v)
+}
+
+// s3CopyLimit is the largest object that can be copied on itself in a single request
+const s3CopyLimit = 5 << 30
+
+// WriteMeta replaces the user metadata of the object with a copy on itself. Objects over s3CopyLimit or
+// uploaded in many parts are not copied, since the copy would fail or change their ETag, and
+// ErrNotSupported is returned so that the sidecar is used
+func (s3 *S3FS) WriteMeta(name string, data []byte) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	v := base64.StdEncoding.EncodeToString(data)
+	if len(v) > s3MetaLimit {
+		return ErrNotSupported
+	}
+	info, err := s3.c.StatObject(ctx, s3.bucket, name, minio.StatObjectOptions{})
+	if err != nil {
+		return err
+	}
+	if info.Size > s3CopyLimit || strings.Contains(info.ETag, "-") {
+		return ErrNotSupported
+	}
+	userMetadata := map[string]string{}
+	for k, uv := range info.UserMetadata {
+		if !strings.EqualFold(k, s3MetaKey) {
+			userMetadata[k] = uv
+		}
+	}
+	if data != nil {
+		userMetadata[s3MetaKey] = v
+	}
+
+	_, err = s3.c.CopyObject(ctx,
+		minio.CopyDestOptions{Bucket: s3.bucket, Object: name, ReplaceMetadata: true, UserMetadata: userMetadata},
+		minio.CopySrcOptions{Bucket: s3.bucket, Object: name})
+	return err
+}
+
+// Checksum returns the ETag of the object, which is the MD5 of the content for objects uploaded in a single
+// part and not encrypted with KMS
+func (s3 *S3FS) Checksum(name string) (string, string, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	info, err := s3.c.StatObject(ctx, s3.bucket, name, minio.StatObjectOptions{})
+	if err != nil {
+		return "", "", err
+	}
+	etag := strings.Trim(info.ETag, "\"")
+	if len(etag) != 32 || strings.Contains(etag, "-") {
+		return "", "", ErrNotSupported
+	}
+	return HashMD5, strings.ToLower(etag), nil
+}
+
 func (s3 *S3FS) ReadDir(name string, opts ListOption) ([]fs.FileInfo, error) {
 	ctx := context.Background()
 	defer ctx.Done()
@@ -185,8 +279,9 @@ func (s3 *S3FS) Rename(old, new string) error {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	_, err := s3.c.CopyObject(ctx, minio.CopyDestOptions{Object: new},
-		minio.CopySrcOptions{Object: old})
+	// the copy keeps the user metadata, and so the meta
+	_, err := s3.c.CopyObject(ctx, minio.CopyDestOptions{Bucket: s3.bucket, Object: new},
+		minio.CopySrcOptions{Bucket: s3.bucket, Object: old})
 	if err != nil {
 		return err
 	}
@@ -17,17 +17,24 @@ import (
 type SFTPConfig struct {
 	Addr     string `json:"addr" yaml:"addr"`
 	Username string `json:"username" yaml:"username"`
-	Password string `json:"password" yaml:"password"`
+	Password string `json:"password" yaml:"password" secret:"true"`
 	KeyPath  string `json:"keyPath" yaml:"keyPath"`
 	Base     string `json:"base" yaml:"base"`
+	// PoolSize is the number of concurrent sessions to the server. Default is 1
+	PoolSize int `json:"poolSize" yaml:"poolSize"`
 }
 
 type SFTP struct {
-	c    *sftp.Client
+	pool *connPool
 	base string
 	url  string
 }
 
+type sftpSession struct {
+	ssh *ssh.Client
+	c   *sftp.Client
+}
+
 func NewSFTP(config SFTPConfig) (FS, error) {
 	addr := config.Addr
 	if !strings.ContainsRune(addr, ':') {
@@ -58,27 +65,48 @@ func NewSFTP(config SFTPConfig) (FS, error) {
 	}
 
 	cc := &ssh.ClientConfig{
-		User: config.Username,
-		Auth: []ssh.AuthMethod{
-			ssh.Password(config.Password),
-		},
+		User:            config.Username,
+		Auth:            auth,
 		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
 	}
 
-	client, err := ssh.Dial("tcp", addr, cc)
-	if err != nil {
-		return nil, fmt.Errorf("cannot connect to %s: %v", addr, err)
-	}
-	c, err := sftp.NewClient(client)
-	if err != nil {
-		return nil, fmt.Errorf("cannot create a sftp client for %s: %v", addr, err)
+	pool := newConnPool(url, config.PoolSize,
+		func() (interface{}, error) {
+			client, err := ssh.Dial("tcp", addr, cc)
+			if err != nil {
+				return nil, fmt.Errorf("cannot connect to %s: %w", addr, err)
+			}
+			c, err := sftp.NewClient(client)
+			if err != nil {
+				_ = client.Close()
+				return nil, fmt.Errorf("cannot create a sftp client for %s: %w", addr, err)
+			}
+			return &sftpSession{client, c}, nil
+		},
+		func(c interface{}) error {
+			_, err := c.(*sftpSession).c.Getwd()
+			return err
+		},
+		func(c interface{}) error {
+			_ = c.(*sftpSession).c.Close()
+			return c.(*sftpSession).ssh.Close()
+		})
+	if err := pool.connect(); err != nil {
+		return nil, err
 	}
 
 	base := config.Base
 	if base == "" {
 		base = "/"
 	}
-	return &SFTP{c, base, url}, nil
+	return &SFTP{pool, base, url}, nil
+}
+
+// with runs op on a session of the pool. See connPool.do for retry
+func (s *SFTP) with(op func(c *sftp.Client) error, retry bool) error {
+	return s.pool.do(func(c interface{}) error {
+		return op(c.(*sftpSession).c)
+	}, retry)
 }
 
 func (s *SFTP) Props() Props {
@@ -91,45 +119,55 @@ func (s *SFTP) Props() Props {
 }
 
 func (s *SFTP) MkdirAll(name string) error {
-	return s.c.MkdirAll(path.Join(s.base, name))
+	return s.with(func(c *sftp.Client) error {
+		return c.MkdirAll(path.Join(s.base, name))
+	}, true)
 }
 
-func (s *SFTP) mkParent(name string) error {
+func (s *SFTP) mkParent(c *sftp.Client, name string) error {
 	dir := path.Join(s.base, path.Dir(name))
-	_, err := s.c.Stat(dir)
+	_, err := c.Stat(dir)
 	if err == nil {
 		return nil
 	}
 
-	return s.c.MkdirAll(dir)
+	return c.MkdirAll(dir)
 }
 
 func (s SFTP) Pull(name string, w io.Writer) error {
-	r, err := s.c.Open(path.Join(s.base, name))
-	if err != nil {
-		return err
-	}
-	defer r.Close()
+	return s.with(func(c *sftp.Client) error {
+		r, err := c.Open(path.Join(s.base, name))
+		if err != nil {
+			return err
+		}
+		defer r.Close()
 
-	_, err = io.Copy(w, r)
-	return err
+		_, err = io.Copy(w, r)
+		return err
+	}, false)
 }
 
 func (s SFTP) Push(name string, r io.Reader) error {
-	err := s.mkParent(name)
+	return s.with(func(c *sftp.Client) error {
+		_ = s.mkParent(c, name)
 
-	w, err := s.c.Create(path.Join(s.base, name))
-	if err != nil {
-		return err
-	}
-	defer w.Close()
-	_, err = io.Copy(w, r)
+		w, err := c.Create(path.Join(s.base, name))
+		if err != nil {
+			return err
+		}
+		defer w.Close()
+		_, err = io.Copy(w, r)
 
-	return err
+		return err
+	}, false)
 }
 
 func (s *SFTP) ReadDir(name string, opts ListOption) ([]fs.FileInfo, error) {
-	entries, err := s.c.ReadDir(path.Join(s.base, name))
+	var entries []fs.FileInfo
+	err := s.with(func(c *sftp.Client) (err error) {
+		entries, err = c.ReadDir(path.Join(s.base, name))
+		return err
+	}, true)
 	if err != nil {
 		return nil, err
 	}
@@ -149,25 +187,35 @@ func (s *SFTP) Watch(name string) chan string {
 	return nil
 }
 
-func (s *SFTP) Stat(name string) (fs.FileInfo, error) {
-	return s.c.Stat(path.Join(s.base, name))
+func (s *SFTP) Stat(name string) (l fs.FileInfo, err error) {
+	err = s.with(func(c *sftp.Client) error {
+		l, err = c.Stat(path.Join(s.base, name))
+		return err
+	}, true)
+	return l, err
 }
 
 func (s *SFTP) Remove(name string) error {
-	return s.c.Remove(path.Join(s.base, name))
+	return s.with(func(c *sftp.Client) error {
+		return c.Remove(path.Join(s.base, name))
+	}, true)
 }
 
 func (s *SFTP) Touch(name string) error {
-	return s.c.Chtimes(path.Join(s.base, name), time.Now(), time.Now())
+	return s.with(func(c *sftp.Client) error {
+		return c.Chtimes(path.Join(s.base, name), time.Now(), time.Now())
+	}, true)
 }
 
 func (s *SFTP) Rename(old, new string) error {
-	_ = s.mkParent(new)
-	return s.c.Rename(path.Join(s.base, old), path.Join(s.base, new))
+	return s.with(func(c *sftp.Client) error {
+		_ = s.mkParent(c, new)
+		return c.Rename(path.Join(s.base, old), path.Join(s.base, new))
+	}, true)
 }
 
 func (s *SFTP) Close() error {
-	return s.c.Close()
+	return s.pool.closeAll()
 }
 
 func (s *SFTP) String() string {
@@ -0,0 +1,571 @@
+package store
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/go-multierror"
+	"github.com/sirupsen/logrus"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type ShardedConfig struct {
+	// Shards are the stores that hold the files
+	Shards []Config `json:"shards" yaml:"shards"`
+}
+
+// shardedIndexFile keeps the placement of the files. It is stored on the first shard
+const shardedIndexFile = ".sharded-index.json"
+
+// shardedVirtualNodes is the number of points on the ring of the shard with the most free space
+const shardedVirtualNodes = 128
+
+// shardedSaveDelay batches the saves of the index after consecutive changes. A placement lost in a crash
+// is recovered by looking for the file on all the shards
+const shardedSaveDelay = time.Second
+
+type shardedIndex struct {
+	Shards []string       `json:"shards"`
+	Files  map[string]int `json:"files"`
+}
+
+type ringPoint struct {
+	hash  uint64
+	shard int
+}
+
+// Sharded spreads files over several file storages with consistent hashing of the path.
+// The placement of each file is kept in an index, so that shards can be added without losing files
+type Sharded struct {
+	Shards      []FS
+	lock        sync.Mutex
+	ring        []ringPoint
+	index       shardedIndex
+	rebalancing sync.WaitGroup
+	saveTimer   *time.Timer
+}
+
+func NewSharded(shards ...FS) (FS, error) {
+	if len(shards) == 0 {
+		return nil, fmt.Errorf("sharded without shards: %w", os.ErrInvalid)
+	}
+	s := &Sharded{
+		Shards: shards,
+		index:  shardedIndex{Files: map[string]int{}},
+	}
+	err := ReadJSON(shards[0], shardedIndexFile, &s.index)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if s.index.Files == nil {
+		s.index.Files = map[string]int{}
+	}
+
+	// the shards may have changed order or a new shard may have been added
+	ids := map[string]int{}
+	for i, f := range shards {
+		ids[f.String()] = i
+	}
+	known := 0
+	files := map[string]int{}
+	for name, old := range s.index.Files {
+		if old < len(s.index.Shards) {
+			if i, ok := ids[s.index.Shards[old]]; ok {
+				files[name] = i
+			}
+		}
+	}
+	for _, id := range s.index.Shards {
+		if _, ok := ids[id]; ok {
+			known++
+		}
+	}
+	s.index.Files = files
+	s.index.Shards = nil
+	for _, f := range shards {
+		s.index.Shards = append(s.index.Shards, f.String())
+	}
+	s.buildRing()
+
+	if known > 0 && known < len(shards) {
+		s.rebalancing.Add(1)
+		go s.rebalance()
+	}
+	return s, nil
+}
+
+func newShardedFromConfig(c ShardedConfig) (FS, error) {
+	var shards []FS
+	for _, sc := range c.Shards {
+		f, err := NewFS(sc)
+		if err != nil {
+			for _, f := range shards {
+				_ = f.Close()
+			}
+			return nil, err
+		}
+		shards = append(shards, f)
+	}
+	return NewSharded(shards...)
+}
+
+func ringHash(s string) uint64 {
+	h := sha1.Sum([]byte(s))
+	return binary.BigEndian.Uint64(h[:8])
+}
+
+// buildRing places each shard on the ring with a number of points proportional to its free space
+func (s *Sharded) buildRing() {
+	var maxFree int64
+	free := make([]int64, len(s.Shards))
+	for i, f := range s.Shards {
+		free[i] = f.Props().Free
+		if free[i] > maxFree {
+			maxFree = free[i]
+		}
+	}
+
+	s.ring = nil
+	for i, f := range s.Shards {
+		nodes := shardedVirtualNodes
+		if maxFree > 0 {
+			nodes = int(float64(shardedVirtualNodes) * float64(free[i]) / float64(maxFree))
+		}
+		if nodes < 1 {
+			nodes = 1
+		}
+		for v := 0; v < nodes; v++ {
+			s.ring = append(s.ring, ringPoint{ringHash(fmt.Sprintf("%s#%d", f, v)), i})
+		}
+	}
+	sort.Slice(s.ring, func(i, j int) bool {
+		return s.ring[i].hash < s.ring[j].hash
+	})
+}
+
+// candidates returns the shards in the order of preference for name according to the ring
+func (s *Sharded) candidates(name string) []int {
+	h := ringHash(path.Clean(name))
+	start := sort.Search(len(s.ring), func(i int) bool {
+		return s.ring[i].hash >= h
+	})
+
+	seen := map[int]bool{}
+	var shards []int
+	for i := 0; i < len(s.ring) && len(shards) < len(s.Shards); i++ {
+		p := s.ring[(start+i)%len(s.ring)]
+		if !seen[p.shard] {
+			seen[p.shard] = true
+			shards = append(shards, p.shard)
+		}
+	}
+	return shards
+}
+
+// locate returns the shard that holds name, or the preferred shard for a new file
+func (s *Sharded) locate(name string) (int, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	name = path.Clean(name)
+	if i, ok := s.index.Files[name]; ok {
+		return i, true
+	}
+	return s.candidates(name)[0], false
+}
+
+// saveIndex must be called with the lock held
+func (s *Sharded) saveIndex() error {
+	return WriteJSON(s.Shards[0], shardedIndexFile, s.index)
+}
+
+// saveLater schedules a save of the index, so that many changes are saved at once. It must be called
+// with the lock held
+func (s *Sharded) saveLater() {
+	if s.saveTimer != nil {
+		return
+	}
+	s.saveTimer = time.AfterFunc(shardedSaveDelay, func() {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		if s.saveTimer == nil {
+			// already saved by Close
+			return
+		}
+		s.saveTimer = nil
+		if err := s.saveIndex(); err != nil {
+			logrus.Errorf("cannot save sharded index: %v", err)
+		}
+	})
+}
+
+func (s *Sharded) place(name string, shard int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.index.Files[path.Clean(name)] = shard
+	s.saveLater()
+}
+
+func (s *Sharded) forget(name string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	name = path.Clean(name)
+	for n := range s.index.Files {
+		if n == name || strings.HasPrefix(n, name+"/") {
+			delete(s.index.Files, n)
+		}
+	}
+	s.saveLater()
+}
+
+// shards returns a copy of the shards, since AddShard may change them at any time
+func (s *Sharded) shards() []FS {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]FS(nil), s.Shards...)
+}
+
+// find returns the shard that holds name. Files missing in the index are searched on all the shards
+func (s *Sharded) find(name string) (int, fs.FileInfo, error) {
+	i, indexed := s.locate(name)
+	shards := s.shards()
+	l, err := shards[i].Stat(name)
+	if err == nil || indexed && !os.IsNotExist(err) {
+		return i, l, err
+	}
+	for j, f := range shards {
+		if j == i {
+			continue
+		}
+		if l, err := f.Stat(name); err == nil {
+			if !l.IsDir() {
+				s.place(name, j)
+			}
+			return j, l, nil
+		}
+	}
+	return i, nil, os.ErrNotExist
+}
+
+// AddShard adds a new file storage and moves in the background the files that the ring now assigns to it
+func (s *Sharded) AddShard(f FS) {
+	s.lock.Lock()
+	s.Shards = append(s.Shards, f)
+	s.index.Shards = append(s.index.Shards, f.String())
+	s.buildRing()
+	s.lock.Unlock()
+
+	s.rebalancing.Add(1)
+	go s.rebalance()
+}
+
+// WaitRebalance blocks until the background rebalancing is complete
+func (s *Sharded) WaitRebalance() {
+	s.rebalancing.Wait()
+}
+
+func (s *Sharded) rebalance() {
+	defer s.rebalancing.Done()
+
+	s.lock.Lock()
+	moves := map[string][2]int{}
+	for name, from := range s.index.Files {
+		if to := s.candidates(name)[0]; to != from {
+			moves[name] = [2]int{from, to}
+		}
+	}
+	shards := append([]FS(nil), s.Shards...)
+	s.lock.Unlock()
+
+	moved := 0
+	for name, m := range moves {
+		from, to := shards[m[0]], shards[m[1]]
+		// sidecars are files in the index, so they are moved on their own
+		err := s.moveFile(from, to, name)
+		if err != nil {
+			logrus.Warnf("cannot move %s from %s to %s: %v", name, from, to, err)
+			continue
+		}
+
+		s.lock.Lock()
+		// the file may have changed during the copy
+		current, ok := s.index.Files[name]
+		if ok && current == m[0] {
+			s.index.Files[name] = m[1]
+		}
+		s.lock.Unlock()
+		if !ok || current != m[0] {
+			_ = to.Remove(name)
+			continue
+		}
+		_ = from.Remove(name)
+		moved++
+	}
+
+	s.lock.Lock()
+	err := s.saveIndex()
+	s.lock.Unlock()
+	if err != nil {
+		logrus.Errorf("cannot save sharded index: %v", err)
+	}
+	logrus.Infof("rebalance completed: %d files moved", moved)
+}
+
+// moveFile copies name to the shard to and checks that the copy has the size of the source before the
+// source is removed. A failed copy is removed
+func (s *Sharded) moveFile(from, to FS, name string) error {
+	src, err := from.Stat(name)
+	if err != nil {
+		return err
+	}
+	err = Copy(from, to, name, name, false, 0)
+	if err == nil {
+		var dest fs.FileInfo
+		if dest, err = to.Stat(name); err == nil && dest.Size() != src.Size() {
+			err = fmt.Errorf("copy of %s has %d bytes instead of %d", name, dest.Size(), src.Size())
+		}
+	}
+	if err != nil {
+		_ = to.Remove(name)
+	}
+	return err
+}
+
+func (s *Sharded) fanOut(op func(f FS) error) error {
+	var me *multierror.Error
+	missing := 0
+	shards := s.shards()
+	for _, f := range shards {
+		err := op(f)
+		switch {
+		case os.IsNotExist(err):
+			missing++
+		case err != nil:
+			me = multierror.Append(me, err)
+		}
+	}
+	if missing == len(shards) {
+		return os.ErrNotExist
+	}
+	return me.ErrorOrNil()
+}
+
+// Props sums the space of all the shards
+func (s *Sharded) Props() Props {
+	shards := s.shards()
+	props := shards[0].Props()
+	for _, f := range shards[1:] {
+		p := f.Props()
+		if props.Quota+p.Quota > props.Quota {
+			props.Quota += p.Quota
+		}
+		if props.Free+p.Free > props.Free {
+			props.Free += p.Free
+		}
+	}
+	return props
+}
+
+// ReadDir merges the listings of the shards. Files present on more shards, e.g. during a rebalance,
+// are taken from the shard in the index
+func (s *Sharded) ReadDir(name string, opts ListOption) ([]fs.FileInfo, error) {
+	entries := map[string]fs.FileInfo{}
+	var found bool
+	var lastErr error
+	for i, f := range s.shards() {
+		ls, err := f.ReadDir(name, opts)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		found = true
+		for _, l := range ls {
+			n := path.Join(name, l.Name())
+			if n == shardedIndexFile {
+				continue
+			}
+			if _, ok := entries[l.Name()]; ok && !l.IsDir() {
+				if shard, _ := s.locate(n); shard != i {
+					continue
+				}
+			}
+			if _, ok := entries[l.Name()]; !ok || !l.IsDir() {
+				entries[l.Name()] = l
+			}
+		}
+	}
+	if !found {
+		return nil, lastErr
+	}
+
+	var fis []fs.FileInfo
+	for _, l := range entries {
+		fis = append(fis, l)
+	}
+	sort.Slice(fis, func(i, j int) bool {
+		return fis[i].Name() < fis[j].Name()
+	})
+	return fis, nil
+}
+
+func (s *Sharded) Stat(name string) (fs.FileInfo, error) {
+	_, l, err := s.find(name)
+	return l, err
+}
+
+func (s *Sharded) Remove(name string) error {
+	i, l, err := s.find(name)
+	if err != nil {
+		return err
+	}
+	if l.IsDir() {
+		err = s.fanOut(func(f FS) error {
+			return f.Remove(name)
+		})
+	} else {
+		err = s.shards()[i].Remove(name)
+	}
+	if err == nil {
+		s.forget(name)
+	}
+	return err
+}
+
+func (s *Sharded) Touch(name string) error {
+	i, _, err := s.find(name)
+	f := s.shards()[i]
+	if err == nil {
+		return f.Touch(name)
+	}
+	err = f.Touch(name)
+	if err == nil {
+		s.place(name, i)
+	}
+	return err
+}
+
+func (s *Sharded) Watch(name string) chan string {
+	return s.shards()[0].Watch(name)
+}
+
+// Rename keeps the file on its shard and updates the index
+func (s *Sharded) Rename(old, new string) error {
+	i, l, err := s.find(old)
+	if err != nil {
+		return err
+	}
+	if !l.IsDir() {
+		if err = s.shards()[i].Rename(old, new); err == nil {
+			s.forget(old)
+			s.place(new, i)
+		}
+		return err
+	}
+
+	err = s.fanOut(func(f FS) error {
+		return f.Rename(old, new)
+	})
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	old, new = path.Clean(old), path.Clean(new)
+	for n, shard := range s.index.Files {
+		if strings.HasPrefix(n, old+"/") {
+			delete(s.index.Files, n)
+			s.index.Files[new+n[len(old):]] = shard
+		}
+	}
+	return s.saveIndex()
+}
+
+func (s *Sharded) MkdirAll(name string) error {
+	return s.fanOut(func(f FS) error {
+		return f.MkdirAll(name)
+	})
+}
+
+func (s *Sharded) Pull(name string, w io.Writer) error {
+	i, _, err := s.find(name)
+	if err != nil {
+		return err
+	}
+	return s.shards()[i].Pull(name, w)
+}
+
+// Push writes new files on the shard chosen by the ring. When the shard is full, the file goes to
+// the next shard on the ring, provided that the content can be read again from where it started
+func (s *Sharded) Push(name string, r io.Reader) error {
+	i, indexed := s.locate(name)
+	if !indexed {
+		if j, l, err := s.find(name); err == nil && !l.IsDir() {
+			i = j
+		}
+	}
+
+	seeker, _ := r.(io.Seeker)
+	var start int64
+	if seeker != nil {
+		var err error
+		if start, err = seeker.Seek(0, io.SeekCurrent); err != nil {
+			seeker = nil
+		}
+	}
+
+	s.lock.Lock()
+	shards := append([]FS(nil), s.Shards...)
+	candidates := s.candidates(name)
+	s.lock.Unlock()
+	err := shards[i].Push(name, r)
+	if errors.Is(err, ErrOffQuota) && seeker != nil {
+		for _, j := range candidates {
+			if j == i {
+				continue
+			}
+			if _, serr := seeker.Seek(start, io.SeekStart); serr != nil {
+				break
+			}
+			if err = shards[j].Push(name, r); err == nil {
+				_ = shards[i].Remove(name)
+				i = j
+				break
+			}
+		}
+	}
+	if err == nil {
+		s.place(name, i)
+	}
+	return err
+}
+
+func (s *Sharded) Close() error {
+	s.WaitRebalance()
+	var me *multierror.Error
+	s.lock.Lock()
+	if s.saveTimer != nil {
+		s.saveTimer.Stop()
+		s.saveTimer = nil
+		me = multierror.Append(me, s.saveIndex())
+	}
+	s.lock.Unlock()
+	for _, f := range s.shards() {
+		me = multierror.Append(me, f.Close())
+	}
+	return me.ErrorOrNil()
+}
+
+func (s *Sharded) String() string {
+	var names []string
+	for _, f := range s.shards() {
+		names = append(names, f.String())
+	}
+	return fmt.Sprintf("sharded(%s)", strings.Join(names, "|"))
+}
@@ -0,0 +1,120 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestSharded(t *testing.T) {
+	var shards []FS
+	for i := 0; i < 3; i++ {
+		dir := filepath.Join(os.TempDir(), fmt.Sprintf("stg/test/shard%d", i))
+		_ = os.RemoveAll(dir)
+		_ = os.MkdirAll(dir, 0755)
+		shards = append(shards, NewLocalMount(dir))
+	}
+	f, err := NewSharded(shards[0:2]...)
+	assert.NoError(t, err)
+	s := f.(*Sharded)
+
+	for i := 0; i < 20; i++ {
+		assert.NoError(t, s.Push(fmt.Sprintf("data/f%d.txt", i), bytes.NewBufferString(fmt.Sprintf("%d", i))))
+	}
+	ls, err := s.ReadDir("data", 0)
+	assert.NoError(t, err)
+	assert.Len(t, ls, 20)
+	l0, _ := shards[0].ReadDir("data", 0)
+	l1, _ := shards[1].ReadDir("data", 0)
+	assert.Equal(t, 20, len(l0)+len(l1))
+	assert.NotEmpty(t, l0)
+	assert.NotEmpty(t, l1)
+
+	s.AddShard(shards[2])
+	s.WaitRebalance()
+	l2, _ := shards[2].ReadDir("data", 0)
+	assert.NotEmpty(t, l2)
+	for i := 0; i < 20; i++ {
+		data, err := ReadFile(s, fmt.Sprintf("data/f%d.txt", i))
+		assert.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("%d", i), string(data))
+	}
+	ls, _ = s.ReadDir("data", 0)
+	assert.Len(t, ls, 20)
+
+	assert.NoError(t, s.Rename("data/f1.txt", "data/g1.txt"))
+	assert.NoError(t, s.Remove("data/f2.txt"))
+	_, err = s.Stat("data/f2.txt")
+	assert.True(t, os.IsNotExist(err))
+
+	assert.NoError(t, s.Close())
+	f, err = NewSharded(shards...)
+	assert.NoError(t, err)
+	_, indexed := f.(*Sharded).index.Files["data/g1.txt"]
+	assert.True(t, indexed)
+	data, err := ReadFile(f, "data/g1.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "1", string(data))
+	_ = os.RemoveAll(filepath.Join(os.TempDir(), "stg/test/shard0"))
+}
+
+// fullFS reads part of the content and then fails as if it were out of space
+type fullFS struct {
+	FS
+}
+
+func (f *fullFS) Push(name string, r io.Reader) error {
+	_, _ = io.CopyN(io.Discard, r, 1)
+	return ErrOffQuota
+}
+
+func TestShardedPushOffQuota(t *testing.T) {
+	full := &fullFS{NewLocalMount(t.TempDir())}
+	f, err := NewSharded(full, NewLocalMount(t.TempDir()))
+	assert.NoError(t, err)
+	defer f.Close()
+
+	// the content is pushed again from where the reader was, not from its beginning
+	for i := 0; i < 10; i++ {
+		r := bytes.NewReader([]byte(fmt.Sprintf("--%d", i)))
+		_, _ = r.Seek(2, io.SeekStart)
+		assert.NoError(t, f.Push(fmt.Sprintf("f%d.txt", i), r))
+		data, err := ReadFile(f, fmt.Sprintf("f%d.txt", i))
+		assert.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("%d", i), string(data))
+	}
+}
+
+func TestShardedAddShardConcurrent(t *testing.T) {
+	f, err := NewSharded(NewLocalMount(t.TempDir()))
+	assert.NoError(t, err)
+	s := f.(*Sharded)
+	defer s.Close()
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, s.Push(fmt.Sprintf("f%d.txt", i), bytes.NewBufferString("a")))
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 4; i++ {
+			s.AddShard(NewLocalMount(t.TempDir()))
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		ls, err := s.ReadDir("", 0)
+		assert.NoError(t, err)
+		assert.Len(t, ls, 10)
+		_, err = s.Stat("f1.txt")
+		assert.NoError(t, err)
+	}
+	wg.Wait()
+	s.WaitRebalance()
+	assert.Len(t, s.shards(), 5)
+}
@@ -16,11 +16,11 @@ type SharepointConfig struct {
 	Site      string `json:"site" yaml:"site"`
 	AuthAsApp *struct {
 		ClientId     string `json:"clientId" yaml:"clientId"`
-		ClientSecret string `json:"clientSecret" yaml:"clientSecret"`
+		ClientSecret string `json:"clientSecret" yaml:"clientSecret" secret:"true"`
 	} `json:"authAsApp" yaml:"authAsApp"`
 	AuthAsSAML *struct {
 		Username string `json:"username" yaml:"username"`
-		Password string `json:"password" yaml:"password"`
+		Password string `json:"password" yaml:"password" secret:"true"`
 	} `json:"authAsSAML" yaml:"authAsSAML"`
 }
 
@@ -2,12 +2,14 @@ package store
 
 import (
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"github.com/hirochachacha/go-smb2"
 	"io"
 	"io/fs"
 	"math"
 	"net"
+	"os"
 	"path"
 	"strings"
 	"time"
@@ -16,31 +18,59 @@ import (
 type SMBConfig struct {
 	Addr     string `json:"addr" yaml:"addr"`
 	Username string `json:"username" yaml:"username"`
-	Password string `json:"password" yaml:"password"`
+	Password string `json:"password" yaml:"password" secret:"true"`
 	Hash     string `json:"hash" yaml:"hash"`
 	Share    string `json:"share" yaml:"share"`
+	// PoolSize is the number of concurrent sessions to the server. Default is 1
+	PoolSize int `json:"poolSize" yaml:"poolSize"`
 }
 
 type SMB struct {
-	s   *smb2.Session
-	sh  *smb2.Share
-	url string
+	pool *connPool
+	url  string
 }
 
-func NewSMB(config SMBConfig) (FS, error) {
-	s, err := getSession(config)
-	if err != nil {
-		return nil, err
+type smbSession struct {
+	conn net.Conn
+	s    *smb2.Session
+	sh   *smb2.Share
+}
+
+func (s *smbSession) close() error {
+	if s.sh != nil {
+		_ = s.sh.Umount()
 	}
+	_ = s.s.Logoff()
+	return s.conn.Close()
+}
 
-	share, err := s.Mount(config.Share)
-	if err != nil {
-		s.Logoff()
+func NewSMB(config SMBConfig) (FS, error) {
+	url := fmt.Sprintf("smb://%s@%s/%s", config.Username, config.Addr, config.Share)
+	pool := newConnPool(url, config.PoolSize,
+		func() (interface{}, error) {
+			s, err := getSession(config)
+			if err != nil {
+				return nil, err
+			}
+			s.sh, err = s.s.Mount(config.Share)
+			if err != nil {
+				_ = s.close()
+				return nil, err
+			}
+			return s, nil
+		},
+		func(c interface{}) error {
+			_, err := c.(*smbSession).sh.Stat("")
+			return err
+		},
+		func(c interface{}) error {
+			return c.(*smbSession).close()
+		})
+	if err := pool.connect(); err != nil {
 		return nil, err
 	}
 
-	url := fmt.Sprintf("smb://%s@%s/%s", config.Username, config.Addr, config.Share)
-	return &SMB{s, share, url}, nil
+	return &SMB{pool, url}, nil
 }
 
 func ListSMBShares(config SMBConfig) ([]string, error) {
@@ -48,24 +78,19 @@ func ListSMBShares(config SMBConfig) ([]string, error) {
 	if err != nil {
 		return nil, err
 	}
-	defer s.Logoff()
+	defer s.close()
 
-	return s.ListSharenames()
+	return s.s.ListSharenames()
 }
 
-func getSession(config SMBConfig) (*smb2.Session, error) {
+func getSession(config SMBConfig) (*smbSession, error) {
 	addr := config.Addr
 	if !strings.ContainsRune(addr, ':') {
 		addr = fmt.Sprintf("%s:445", addr)
 	}
 
-	conn, err := net.Dial("tcp", addr)
-	if err != nil {
-		return nil, err
-	}
-	defer conn.Close()
-
 	var hash []byte
+	var err error
 	if config.Hash != "" {
 		hash, err = base64.StdEncoding.DecodeString(config.Hash)
 		if err != nil {
@@ -73,6 +98,11 @@ func getSession(config SMBConfig) (*smb2.Session, error) {
 		}
 	}
 
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
 	d := &smb2.Dialer{
 		Initiator: &smb2.NTLMInitiator{
 			User:     config.Username,
@@ -81,7 +111,19 @@ func getSession(config SMBConfig) (*smb2.Session, error) {
 		},
 	}
 
-	return d.Dial(conn)
+	s, err := d.Dial(conn)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return &smbSession{conn: conn, s: s}, nil
+}
+
+// with runs op on a session of the pool. See connPool.do for retry
+func (s *SMB) with(op func(sh *smb2.Share) error, retry bool) error {
+	return s.pool.do(func(c interface{}) error {
+		return op(c.(*smbSession).sh)
+	}, retry)
 }
 
 func (s *SMB) Props() Props {
@@ -94,39 +136,49 @@ func (s *SMB) Props() Props {
 }
 
 func (s *SMB) MkdirAll(name string) error {
-	return s.sh.MkdirAll(name, 0755)
+	return s.with(func(sh *smb2.Share) error {
+		return sh.MkdirAll(name, 0755)
+	}, true)
 }
 
-func (s *SMB) mkParent(name string) error {
-	return s.sh.MkdirAll(path.Dir(name), 0755)
+func smbMkParent(sh *smb2.Share, name string) error {
+	return sh.MkdirAll(path.Dir(name), 0755)
 }
 
 func (s SMB) Pull(name string, w io.Writer) error {
-	r, err := s.sh.Open(name)
-	if err != nil {
-		return err
-	}
-	defer r.Close()
+	return s.with(func(sh *smb2.Share) error {
+		r, err := sh.Open(name)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
 
-	_, err = io.Copy(w, r)
-	return err
+		_, err = io.Copy(w, r)
+		return err
+	}, false)
 }
 
 func (s SMB) Push(name string, r io.Reader) error {
-	err := s.mkParent(name)
+	return s.with(func(sh *smb2.Share) error {
+		_ = smbMkParent(sh, name)
 
-	w, err := s.sh.Create(name)
-	if err != nil {
-		return err
-	}
-	defer w.Close()
-	_, err = io.Copy(w, r)
+		w, err := sh.Create(name)
+		if err != nil {
+			return err
+		}
+		defer w.Close()
+		_, err = io.Copy(w, r)
 
-	return err
+		return err
+	}, false)
 }
 
 func (s *SMB) ReadDir(name string, opts ListOption) ([]fs.FileInfo, error) {
-	entries, err := s.sh.ReadDir(name)
+	var entries []fs.FileInfo
+	err := s.with(func(sh *smb2.Share) (err error) {
+		entries, err = sh.ReadDir(name)
+		return err
+	}, true)
 	if err != nil {
 		return nil, err
 	}
@@ -146,29 +198,88 @@ func (s *SMB) Watch(_ string) chan string {
 	return nil
 }
 
-func (s *SMB) Stat(name string) (fs.FileInfo, error) {
-	return s.sh.Stat(name)
+func (s *SMB) Stat(name string) (l fs.FileInfo, err error) {
+	err = s.with(func(sh *smb2.Share) error {
+		l, err = sh.Stat(name)
+		return err
+	}, true)
+	return l, err
 }
 
 func (s *SMB) Remove(name string) error {
-	return s.sh.Remove(name)
+	return s.with(func(sh *smb2.Share) error {
+		return sh.Remove(name)
+	}, true)
 }
 
 func (s *SMB) Touch(name string) error {
-	return s.sh.Chtimes(name, time.Now(), time.Now())
+	return s.with(func(sh *smb2.Share) error {
+		return sh.Chtimes(name, time.Now(), time.Now())
+	}, true)
 }
 
 func (s *SMB) Rename(old, new string) error {
-	_ = s.mkParent(new)
-	return s.sh.Rename(old, new)
+	return s.with(func(sh *smb2.Share) error {
+		_ = smbMkParent(sh, new)
+		return sh.Rename(old, new)
+	}, true)
 }
 
-func (s *SMB) Close() error {
-	err := s.sh.Umount()
-	if err != nil {
-		return err
+// smbMetaStream is the alternate data stream that keeps the meta of a file. Windows keeps streams in NTFS
+// and Samba in the extended attributes of the file with vfs_streams_xattr; both move them on rename
+const smbMetaStream = ":bbfs.meta"
+
+// NTSTATUS codes of servers without alternate data streams
+const (
+	smbStatusInvalidParameter  = 0xC000000D
+	smbStatusObjectNameInvalid = 0xC0000033
+	smbStatusNotSupported      = 0xC00000BB
+)
+
+// smbStreamError returns ErrNotSupported when the server has no alternate data streams
+func smbStreamError(err error) error {
+	var re *smb2.ResponseError
+	if errors.As(err, &re) {
+		switch re.Code {
+		case smbStatusInvalidParameter, smbStatusObjectNameInvalid, smbStatusNotSupported:
+			return fmt.Errorf("alternate data streams on %s: %w", err, ErrNotSupported)
+		}
 	}
-	return s.s.Logoff()
+	return err
+}
+
+// ReadMeta reads the meta from the alternate data stream of the file
+func (s *SMB) ReadMeta(name string) ([]byte, error) {
+	var data []byte
+	err := s.with(func(sh *smb2.Share) (err error) {
+		if _, err = sh.Stat(name); err != nil {
+			return err
+		}
+		data, err = sh.ReadFile(name + smbMetaStream)
+		return smbStreamError(err)
+	}, true)
+	return data, err
+}
+
+// WriteMeta writes the meta in the alternate data stream of the file
+func (s *SMB) WriteMeta(name string, data []byte) error {
+	return s.with(func(sh *smb2.Share) error {
+		if _, err := sh.Stat(name); err != nil {
+			return err
+		}
+		if data == nil {
+			err := sh.Remove(name + smbMetaStream)
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return smbStreamError(err)
+		}
+		return smbStreamError(sh.WriteFile(name+smbMetaStream, data, 0644))
+	}, false)
+}
+
+func (s *SMB) Close() error {
+	return s.pool.closeAll()
 }
 
 func (s *SMB) String() string {
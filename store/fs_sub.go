@@ -78,6 +78,23 @@ func (s *Sub) Push(name string, r io.Reader) error {
 	return s.F.Push(name, r)
 }
 
+// ReadMeta reads the native meta of the inner store, when available
+func (s *Sub) ReadMeta(name string) ([]byte, error) {
+	n, ok := nativeMeta(s.F)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	return n.ReadMeta(path.Join(s.Dir, name))
+}
+
+func (s *Sub) WriteMeta(name string, data []byte) error {
+	n, ok := nativeMeta(s.F)
+	if !ok {
+		return ErrNotSupported
+	}
+	return n.WriteMeta(path.Join(s.Dir, name), data)
+}
+
 func (s *Sub) Close() error {
 	return nil
 }
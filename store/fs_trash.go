@@ -4,13 +4,46 @@ import (
 	"fmt"
 	"io"
 	"io/fs"
+	"os"
+	"os/user"
 	"path"
+	"sort"
 	"strings"
+	"time"
 )
 
+type TrashConfig struct {
+	// Folder is where removed files are moved. Default is .trash
+	Folder string `json:"folder" yaml:"folder"`
+	// User is recorded as the deleter of the files. Default is the current user
+	User string `json:"user" yaml:"user"`
+}
+
+// trashInfo is the manifest kept in the meta of a trashed item
+type trashInfo struct {
+	Origin    string
+	Deleted   time.Time
+	DeletedBy string
+}
+
+func init() {
+	RegisterMeta("bbfs.trash", trashInfo{})
+}
+
+// TrashItem is a file or a folder in the trash
+type TrashItem struct {
+	ID        string
+	Origin    string
+	Deleted   time.Time
+	DeletedBy string
+	Size      int64
+	IsDir     bool
+}
+
 type Trash struct {
 	F      FS
 	Folder string
+	User   string
 }
 
 func (t *Trash) Rename(old, new string) error {
@@ -18,7 +51,19 @@ func (t *Trash) Rename(old, new string) error {
 }
 
 func NewTrash(f FS, trashFolder string) FS {
-	return &Trash{f, trashFolder}
+	return NewTrashWithConfig(f, TrashConfig{Folder: trashFolder})
+}
+
+func NewTrashWithConfig(f FS, config TrashConfig) FS {
+	if config.Folder == "" {
+		config.Folder = ".trash"
+	}
+	if config.User == "" {
+		if u, err := user.Current(); err == nil {
+			config.User = u.Username
+		}
+	}
+	return &Trash{f, config.Folder, config.User}
 }
 
 func (t *Trash) Props() Props {
@@ -33,14 +78,116 @@ func (t *Trash) Push(name string, r io.Reader) error {
 	return t.F.Push(name, r)
 }
 
+func (t *Trash) isTrashArea(name string) bool {
+	name = path.Clean(name)
+	return name == t.Folder || strings.HasPrefix(name, t.Folder+"/")
+}
+
 func (t *Trash) Remove(name string) error {
-	if strings.HasPrefix(name, t.Folder+"/") {
+	if t.isTrashArea(name) || IsMeta(name) {
 		return t.F.Remove(name)
-	} else {
-		dest := path.Join(t.Folder, name)
-		t.F.Remove(dest)
-		return t.F.Rename(name, dest)
 	}
+
+	now := time.Now()
+	id := fmt.Sprintf("%s-%s", now.UTC().Format(versionLayout), path.Base(name))
+	dest := path.Join(t.Folder, id)
+	err := t.F.Rename(name, dest)
+	if err != nil {
+		return err
+	}
+	if _, err := t.F.Stat(metaName(name)); err == nil {
+		_ = t.F.Rename(metaName(name), metaName(dest))
+	}
+	return SetMeta(t.F, dest, trashInfo{Origin: name, Deleted: now, DeletedBy: t.User})
+}
+
+// ListTrash returns the items in the trash, the most recently deleted first
+func (t *Trash) ListTrash() ([]TrashItem, error) {
+	ls, err := t.F.ReadDir(t.Folder, IncludeHiddenFiles)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var items []TrashItem
+	for _, l := range ls {
+		if IsMeta(l.Name()) {
+			continue
+		}
+		var ti trashInfo
+		_ = GetMeta(t.F, path.Join(t.Folder, l.Name()), &ti)
+		if ti.Origin == "" {
+			// items trashed before the manifest was introduced
+			ti = trashInfo{Origin: l.Name(), Deleted: l.ModTime()}
+		}
+		items = append(items, TrashItem{
+			ID:        l.Name(),
+			Origin:    ti.Origin,
+			Deleted:   ti.Deleted,
+			DeletedBy: ti.DeletedBy,
+			Size:      l.Size(),
+			IsDir:     l.IsDir(),
+		})
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Deleted.After(items[j].Deleted)
+	})
+	return items, nil
+}
+
+// Restore moves the item id back to its original location or to dest when not empty.
+// It fails with os.ErrExist when the location is not free
+func (t *Trash) Restore(id, dest string) error {
+	src := path.Join(t.Folder, id)
+	var ti trashInfo
+	_ = GetMeta(t.F, src, &ti)
+	if dest == "" {
+		dest = ti.Origin
+	}
+	if dest == "" {
+		return fmt.Errorf("unknown origin of %s: %w", id, os.ErrInvalid)
+	}
+	if _, err := t.F.Stat(dest); err == nil {
+		return fmt.Errorf("cannot restore %s to %s: %w", id, dest, os.ErrExist)
+	}
+
+	err := t.F.Rename(src, dest)
+	if err != nil {
+		return err
+	}
+	if _, err := t.F.Stat(metaName(src)); err == nil {
+		_ = t.F.Rename(metaName(src), metaName(dest))
+	}
+	_ = UnsetMeta(t.F, dest, &trashInfo{})
+	return nil
+}
+
+// Empty deletes the items removed more than olderThan ago. Items still under the
+// retention of the file storage are preserved. It returns the number of deleted items
+func (t *Trash) Empty(olderThan time.Duration) (int, error) {
+	if r := retentionPeriod(t.F.Props()); r > olderThan {
+		olderThan = r
+	}
+	items, err := t.ListTrash()
+	if err != nil {
+		return 0, err
+	}
+
+	cnt := 0
+	for _, i := range items {
+		if time.Since(i.Deleted) < olderThan {
+			continue
+		}
+		name := path.Join(t.Folder, i.ID)
+		if err = t.F.Remove(name); err != nil {
+			return cnt, err
+		}
+		_ = RemoveMeta(t.F, name)
+		cnt++
+	}
+	return cnt, nil
 }
 
 func (t *Trash) MkdirAll(name string) error {
@@ -48,7 +195,18 @@ func (t *Trash) MkdirAll(name string) error {
 }
 
 func (t *Trash) ReadDir(name string, opts ListOption) ([]fs.FileInfo, error) {
-	return t.F.ReadDir(name, opts)
+	ls, err := t.F.ReadDir(name, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var fis []fs.FileInfo
+	for _, l := range ls {
+		if !t.isTrashArea(path.Join(name, l.Name())) {
+			fis = append(fis, l)
+		}
+	}
+	return fis, nil
 }
 
 func (t *Trash) Watch(name string) chan string {
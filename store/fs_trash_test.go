@@ -0,0 +1,46 @@
+package store
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestTrash(t *testing.T) {
+	l := NewLocalMount(os.TempDir())
+	tr := NewTrashWithConfig(l, TrashConfig{Folder: "stg/test/.trash", User: "tester"}).(*Trash)
+	_, _ = tr.Empty(0)
+
+	name := "stg/test/trashed.txt"
+	for _, s := range []string{"first", "second"} {
+		assert.NoError(t, tr.Push(name, bytes.NewBufferString(s)))
+		assert.NoError(t, tr.Remove(name))
+	}
+
+	items, err := tr.ListTrash()
+	assert.NoError(t, err)
+	assert.Len(t, items, 2)
+	assert.Equal(t, name, items[0].Origin)
+	assert.Equal(t, "tester", items[0].DeletedBy)
+
+	ls, _ := tr.ReadDir("stg/test", IncludeHiddenFiles)
+	for _, l := range ls {
+		assert.NotEqual(t, ".trash", l.Name())
+	}
+
+	assert.NoError(t, tr.Restore(items[1].ID, ""))
+	data, _ := ReadFile(tr, name)
+	assert.Equal(t, "first", string(data))
+	assert.ErrorIs(t, tr.Restore(items[0].ID, ""), os.ErrExist)
+
+	cnt, err := tr.Empty(time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, cnt)
+	cnt, err = tr.Empty(0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, cnt)
+
+	_ = l.Remove(name)
+}
@@ -0,0 +1,296 @@
+package store
+
+import (
+	"fmt"
+	"github.com/hashicorp/go-multierror"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+)
+
+type UnionConfig struct {
+	// Layers are the stores of the union. The first is the top layer, which receives all the changes
+	Layers []Config `json:"layers" yaml:"layers"`
+}
+
+// Union layers multiple file storages. Reads resolve top-down, changes go to the top layer and
+// deletes of files in lower layers leave a whiteout marker in the top layer
+type Union struct {
+	Layers []FS
+}
+
+func NewUnion(layers ...FS) (FS, error) {
+	if len(layers) == 0 {
+		return nil, fmt.Errorf("union without layers: %w", os.ErrInvalid)
+	}
+	return &Union{Layers: layers}, nil
+}
+
+func newUnionFromConfig(c UnionConfig) (FS, error) {
+	var layers []FS
+	for _, lc := range c.Layers {
+		l, err := NewFS(lc)
+		if err != nil {
+			for _, l := range layers {
+				_ = l.Close()
+			}
+			return nil, err
+		}
+		layers = append(layers, l)
+	}
+	return NewUnion(layers...)
+}
+
+func whiteoutName(name string) string {
+	dir, name := path.Split(name)
+	return path.Join(dir, fmt.Sprintf(".%s!.wh", name))
+}
+
+func IsWhiteout(name string) bool {
+	return strings.HasSuffix(name, "!.wh")
+}
+
+func (u *Union) top() FS {
+	return u.Layers[0]
+}
+
+// hidden returns true when name or one of its parents is whited out in a layer above the layer idx
+func (u *Union) hidden(idx int, name string) bool {
+	name = path.Clean(name)
+	for j := 0; j < idx; j++ {
+		for p := name; p != "." && p != "/" && p != ""; p = path.Dir(p) {
+			if _, err := u.Layers[j].Stat(whiteoutName(p)); err == nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// resolve returns the index of the topmost layer that contains name
+func (u *Union) resolve(name string) (int, fs.FileInfo, error) {
+	for i, l := range u.Layers {
+		if u.hidden(i, name) {
+			break
+		}
+		fi, err := l.Stat(name)
+		if err == nil {
+			return i, fi, nil
+		}
+		if !os.IsNotExist(err) {
+			return i, nil, err
+		}
+	}
+	return -1, nil, os.ErrNotExist
+}
+
+// inLower returns true when name is visible in a layer below the top
+func (u *Union) inLower(name string) bool {
+	for i, l := range u.Layers[1:] {
+		if u.hidden(i+1, name) {
+			return false
+		}
+		if _, err := l.Stat(name); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (u *Union) clearWhiteout(name string) {
+	_ = u.top().Remove(whiteoutName(name))
+}
+
+func (u *Union) whiteout(name string) error {
+	return u.top().Push(whiteoutName(name), strings.NewReader(""))
+}
+
+// hideMeta hides the sidecar of name in the lower layers, which would otherwise show through once the
+// file is changed or removed in the top layer
+func (u *Union) hideMeta(name string) error {
+	if IsMeta(name) || !u.inLower(metaName(name)) {
+		return nil
+	}
+	return u.whiteout(metaName(name))
+}
+
+// copyUp copies name in the top layer, together with its meta, when it is only available in a lower layer
+func (u *Union) copyUp(name string) error {
+	idx, fi, err := u.resolve(name)
+	if err != nil || idx == 0 {
+		return err
+	}
+	if !fi.IsDir() {
+		if err = Copy(u.Layers[idx], u.top(), name, name, true, 0); err != nil {
+			return err
+		}
+		return u.hideMeta(name)
+	}
+
+	if err = u.top().MkdirAll(name); err != nil {
+		return err
+	}
+	ls, err := u.ReadDir(name, IncludeHiddenFiles)
+	if err != nil {
+		return err
+	}
+	for _, l := range ls {
+		if err = u.copyUp(path.Join(name, l.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (u *Union) Props() Props {
+	return u.top().Props()
+}
+
+func (u *Union) ReadDir(name string, opts ListOption) ([]fs.FileInfo, error) {
+	seen := map[string]bool{}
+	whiteouts := map[string]bool{}
+	var fis []fs.FileInfo
+	var found bool
+	var lastErr error
+
+	for i, l := range u.Layers {
+		if u.hidden(i, name) {
+			break
+		}
+		ls, err := l.ReadDir(name, opts|IncludeHiddenFiles)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				lastErr = err
+			}
+			continue
+		}
+		found = true
+
+		for _, fi := range ls {
+			n := fi.Name()
+			hiddenFile := opts&IncludeHiddenFiles == 0 && strings.HasPrefix(n, ".")
+			if IsWhiteout(n) || seen[n] || whiteouts[n] || hiddenFile {
+				continue
+			}
+			seen[n] = true
+			fis = append(fis, fi)
+		}
+		// whiteouts hide the entries of the layers below
+		for _, fi := range ls {
+			if n := fi.Name(); IsWhiteout(n) {
+				whiteouts[n[1:len(n)-len("!.wh")]] = true
+			}
+		}
+	}
+
+	if !found {
+		if lastErr != nil {
+			return nil, lastErr
+		}
+		return nil, os.ErrNotExist
+	}
+	return fis, nil
+}
+
+func (u *Union) Stat(name string) (fs.FileInfo, error) {
+	_, fi, err := u.resolve(name)
+	return fi, err
+}
+
+func (u *Union) Remove(name string) error {
+	idx, _, err := u.resolve(name)
+	if err != nil {
+		return err
+	}
+	if idx == 0 {
+		if err = u.top().Remove(name); err != nil {
+			return err
+		}
+	}
+	if u.inLower(name) {
+		if err = u.hideMeta(name); err != nil {
+			return err
+		}
+		return u.whiteout(name)
+	}
+	return nil
+}
+
+func (u *Union) Touch(name string) error {
+	if err := u.copyUp(name); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	u.clearWhiteout(name)
+	return u.top().Touch(name)
+}
+
+func (u *Union) Watch(name string) chan string {
+	return u.top().Watch(name)
+}
+
+func (u *Union) Rename(old, new string) error {
+	if err := u.copyUp(old); err != nil {
+		return err
+	}
+	u.clearWhiteout(new)
+	if err := u.top().Rename(old, new); err != nil {
+		return err
+	}
+	if u.inLower(old) {
+		return u.whiteout(old)
+	}
+	return nil
+}
+
+func (u *Union) MkdirAll(name string) error {
+	u.clearWhiteout(name)
+	return u.top().MkdirAll(name)
+}
+
+func (u *Union) Pull(name string, w io.Writer) error {
+	idx, _, err := u.resolve(name)
+	if err != nil {
+		return err
+	}
+	return u.Layers[idx].Pull(name, w)
+}
+
+// Push writes name in the top layer. The meta of a file of a lower layer is copied up with the new content,
+// like copyUp does
+func (u *Union) Push(name string, r io.Reader) error {
+	idx, _, err := u.resolve(name)
+	u.clearWhiteout(name)
+	if err = u.top().Push(name, r); err != nil || idx <= 0 || IsMeta(name) {
+		return err
+	}
+	if doc, err := loadMeta(u.Layers[idx], name); err == nil {
+		// the meta set on write, e.g. by Hashed, is newer than the one of the lower layer
+		if current, err := loadMeta(u.top(), name); err == nil {
+			for k, v := range current.Meta {
+				doc.Meta[k] = v
+			}
+		}
+		if err = storeMeta(u.top(), name, doc); err != nil {
+			return err
+		}
+	}
+	return u.hideMeta(name)
+}
+
+func (u *Union) Close() error {
+	var me *multierror.Error
+	for _, l := range u.Layers {
+		me = multierror.Append(me, l.Close())
+	}
+	return me.ErrorOrNil()
+}
+
+func (u *Union) String() string {
+	var names []string
+	for _, l := range u.Layers {
+		names = append(names, l.String())
+	}
+	return fmt.Sprintf("union(%s)", strings.Join(names, "|"))
+}
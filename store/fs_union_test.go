@@ -0,0 +1,88 @@
+package store
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestUnion(t *testing.T) {
+	base := NewMemory(nil, 0)
+	top := NewMemory(nil, 0)
+	assert.NoError(t, WriteFile(base, "data/a.txt", []byte("base a")))
+	assert.NoError(t, WriteFile(base, "data/b.txt", []byte("base b")))
+
+	u, err := NewUnion(top, base)
+	assert.NoError(t, err)
+
+	data, err := ReadFile(u, "data/a.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "base a", string(data))
+
+	assert.NoError(t, u.Push("data/a.txt", bytes.NewBufferString("top a")))
+	data, _ = ReadFile(u, "data/a.txt")
+	assert.Equal(t, "top a", string(data))
+	data, _ = ReadFile(base, "data/a.txt")
+	assert.Equal(t, "base a", string(data))
+
+	assert.NoError(t, u.Remove("data/b.txt"))
+	_, err = u.Stat("data/b.txt")
+	assert.True(t, os.IsNotExist(err))
+	_, err = base.Stat("data/b.txt")
+	assert.NoError(t, err)
+
+	assert.NoError(t, u.Push("data/c.txt", bytes.NewBufferString("top c")))
+	assert.NoError(t, u.Rename("data/a.txt", "data/d.txt"))
+	ls, err := u.ReadDir("data", 0)
+	assert.NoError(t, err)
+	var names []string
+	for _, l := range ls {
+		names = append(names, l.Name())
+	}
+	assert.ElementsMatch(t, []string{"c.txt", "d.txt"}, names)
+
+	assert.NoError(t, u.Push("data/b.txt", bytes.NewBufferString("top b")))
+	data, _ = ReadFile(u, "data/b.txt")
+	assert.Equal(t, "top b", string(data))
+}
+
+func TestUnionMeta(t *testing.T) {
+	base := NewMemory(nil, 0)
+	top := NewMemory(nil, 0)
+	assert.NoError(t, WriteFile(base, "a.txt", []byte("base a")))
+	assert.NoError(t, SetMeta(base, "a.txt", Attr{Group: "dev"}))
+	assert.NoError(t, WriteFile(base, "b.txt", []byte("base b")))
+	assert.NoError(t, SetMeta(base, "b.txt", Attr{Group: "dev"}))
+	assert.NoError(t, WriteFile(base, "c.txt", []byte("base c")))
+	assert.NoError(t, SetMeta(base, "c.txt", Attr{Group: "dev"}))
+	u, err := NewUnion(top, base)
+	assert.NoError(t, err)
+
+	// a write keeps the meta of the lower layer, now in the top layer
+	assert.NoError(t, u.Push("a.txt", bytes.NewBufferString("top a")))
+	assert.NoError(t, SetMeta(u, "a.txt", Attr{Group: "ops"}))
+	var attr Attr
+	assert.NoError(t, GetMeta(u, "a.txt", &attr))
+	assert.Equal(t, Group("ops"), attr.Group)
+	assert.NoError(t, GetMeta(base, "a.txt", &attr))
+	assert.Equal(t, Group("dev"), attr.Group)
+	assert.NoError(t, u.Remove("a.txt"))
+	assert.NoError(t, u.Remove(metaName("a.txt")))
+	_, err = u.Stat(metaName("a.txt"))
+	assert.True(t, os.IsNotExist(err))
+
+	// a file copied up for a rename takes its meta along and the sidecar of the lower layer is hidden
+	assert.NoError(t, u.Rename("b.txt", "d.txt"))
+	attr = Attr{}
+	assert.NoError(t, GetMeta(top, "b.txt", &attr))
+	assert.Equal(t, Group("dev"), attr.Group)
+	assert.NoError(t, u.Remove(metaName("b.txt")))
+	_, err = u.Stat(metaName("b.txt"))
+	assert.True(t, os.IsNotExist(err))
+
+	// a removed file leaves no meta behind
+	assert.NoError(t, u.Remove("c.txt"))
+	_, err = u.Stat(metaName("c.txt"))
+	assert.True(t, os.IsNotExist(err))
+}
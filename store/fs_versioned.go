@@ -0,0 +1,294 @@
+package store
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// versionLayout is the format of version ids, which sort in chronological order
+const versionLayout = "20060102T150405.000000000"
+
+// RetentionPolicy defines which versions of a file are preserved. When neither KeepLast nor
+// the GFS fields are set, all the versions younger than KeepFor are preserved
+type RetentionPolicy struct {
+	// KeepLast preserves the most recent versions
+	KeepLast int `json:"keepLast" yaml:"keepLast"`
+	// KeepFor removes the versions older than the duration, unless KeepLast or the GFS fields preserve them
+	KeepFor time.Duration `json:"keepFor" yaml:"keepFor"`
+	// Daily, Weekly and Monthly preserve the last version of each of the most recent days, weeks and months
+	Daily   int `json:"daily" yaml:"daily"`
+	Weekly  int `json:"weekly" yaml:"weekly"`
+	Monthly int `json:"monthly" yaml:"monthly"`
+}
+
+type VersionedConfig struct {
+	// Folder is the hidden area where versions are kept. Default is .versions
+	Folder    string          `json:"folder" yaml:"folder"`
+	Retention RetentionPolicy `json:"retention" yaml:"retention"`
+}
+
+// Version is a prior content of a file
+type Version struct {
+	ID   string
+	Time time.Time
+	Size int64
+}
+
+// Versioned preserves the prior content of a file on every Push and Remove
+type Versioned struct {
+	F      FS
+	Config VersionedConfig
+}
+
+func NewVersioned(f FS, config VersionedConfig) FS {
+	if config.Folder == "" {
+		config.Folder = ".versions"
+	}
+	return &Versioned{
+		F:      f,
+		Config: config,
+	}
+}
+
+func (v *Versioned) isVersionArea(name string) bool {
+	name = path.Clean(name)
+	return name == v.Config.Folder || strings.HasPrefix(name, v.Config.Folder+"/")
+}
+
+func (v *Versioned) versionsDir(name string) string {
+	return path.Join(v.Config.Folder, name)
+}
+
+// preserve moves the current content of name in the versions area and returns the location. With keep,
+// the content is copied instead, so that name is readable while it is replaced
+func (v *Versioned) preserve(name string, keep bool) (string, error) {
+	l, err := v.F.Stat(name)
+	if os.IsNotExist(err) || err == nil && l.IsDir() {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	dest := path.Join(v.versionsDir(name), time.Now().UTC().Format(versionLayout))
+	if !keep {
+		return dest, v.F.Rename(name, dest)
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		_ = pw.CloseWithError(v.F.Pull(name, pw))
+	}()
+	err = v.F.Push(dest, pr)
+	_ = pr.Close()
+	if err != nil {
+		_ = v.F.Remove(dest)
+		return "", err
+	}
+	return dest, nil
+}
+
+// Versions returns the prior contents of name, the most recent first
+func (v *Versioned) Versions(name string) ([]Version, error) {
+	ls, err := v.F.ReadDir(v.versionsDir(name), IncludeHiddenFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []Version
+	for _, l := range ls {
+		tm, err := time.Parse(versionLayout, l.Name())
+		if l.IsDir() || err != nil {
+			continue
+		}
+		versions = append(versions, Version{ID: l.Name(), Time: tm, Size: l.Size()})
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].ID > versions[j].ID
+	})
+	return versions, nil
+}
+
+// PullVersion writes the content of the version id of name to w
+func (v *Versioned) PullVersion(name, id string, w io.Writer) error {
+	return v.F.Pull(path.Join(v.versionsDir(name), id), w)
+}
+
+// Restore replaces the content of name with the version id. The current content becomes a new version
+func (v *Versioned) Restore(name, id string) error {
+	pr, pw := io.Pipe()
+	go func() {
+		_ = pw.CloseWithError(v.PullVersion(name, id, pw))
+	}()
+	err := v.Push(name, pr)
+	_ = pr.Close()
+	return err
+}
+
+// expired returns the versions not preserved by the retention policy p at time now
+func (p RetentionPolicy) expired(versions []Version, now time.Time) []Version {
+	selective := p.KeepLast > 0 || p.Daily > 0 || p.Weekly > 0 || p.Monthly > 0
+	keep := map[string]bool{}
+	buckets := map[string]bool{}
+	counts := map[byte]int{}
+
+	for i, ver := range versions {
+		if i < p.KeepLast {
+			keep[ver.ID] = true
+		}
+		year, week := ver.Time.ISOWeek()
+		for _, b := range []struct {
+			limit int
+			key   string
+		}{
+			{p.Daily, "d" + ver.Time.Format("2006-01-02")},
+			{p.Weekly, fmt.Sprintf("w%d-%d", year, week)},
+			{p.Monthly, "m" + ver.Time.Format("2006-01")},
+		} {
+			if b.limit == 0 || buckets[b.key] || counts[b.key[0]] >= b.limit {
+				continue
+			}
+			buckets[b.key] = true
+			counts[b.key[0]]++
+			keep[ver.ID] = true
+		}
+	}
+
+	var expired []Version
+	for _, ver := range versions {
+		switch {
+		case keep[ver.ID]:
+		case p.KeepFor > 0 && now.Sub(ver.Time) > p.KeepFor:
+			expired = append(expired, ver)
+		case selective:
+			expired = append(expired, ver)
+		}
+	}
+	return expired
+}
+
+// Prune removes the versions of name that are not preserved by the retention policy
+func (v *Versioned) Prune(name string) error {
+	versions, err := v.Versions(name)
+	if err != nil {
+		return err
+	}
+	for _, ver := range v.Config.Retention.expired(versions, time.Now()) {
+		id := path.Join(v.versionsDir(name), ver.ID)
+		err = v.F.Remove(id)
+		if err != nil {
+			return err
+		}
+		if _, err := v.F.Stat(metaName(id)); err == nil {
+			_ = v.F.Remove(metaName(id))
+		}
+		logrus.Debugf("version %s of %s removed by retention policy", ver.ID, name)
+	}
+	return nil
+}
+
+func (v *Versioned) Props() Props {
+	return v.F.Props()
+}
+
+func (v *Versioned) ReadDir(name string, opts ListOption) ([]fs.FileInfo, error) {
+	ls, err := v.F.ReadDir(name, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var fis []fs.FileInfo
+	for _, l := range ls {
+		if !v.isVersionArea(path.Join(name, l.Name())) {
+			fis = append(fis, l)
+		}
+	}
+	return fis, nil
+}
+
+func (v *Versioned) Stat(name string) (fs.FileInfo, error) {
+	return v.F.Stat(name)
+}
+
+func (v *Versioned) Remove(name string) error {
+	if v.isVersionArea(name) || IsMeta(name) {
+		return v.F.Remove(name)
+	}
+	dest, err := v.preserve(name, false)
+	if err != nil || dest == "" {
+		// folders and missing files are not versioned
+		return v.F.Remove(name)
+	}
+	// the sidecar goes with the version, unless the inner store has already moved it
+	if _, err := v.F.Stat(metaName(name)); err == nil {
+		if err = v.F.Rename(metaName(name), metaName(dest)); err != nil {
+			return err
+		}
+	}
+	return v.Prune(name)
+}
+
+func (v *Versioned) Touch(name string) error {
+	return v.F.Touch(name)
+}
+
+func (v *Versioned) Watch(name string) chan string {
+	return v.F.Watch(name)
+}
+
+// Rename moves the versions together with the file
+func (v *Versioned) Rename(old, new string) error {
+	err := v.F.Rename(old, new)
+	if err != nil || v.isVersionArea(old) || IsMeta(old) {
+		return err
+	}
+	if _, err := v.F.Stat(v.versionsDir(old)); err == nil {
+		return v.F.Rename(v.versionsDir(old), v.versionsDir(new))
+	}
+	return nil
+}
+
+func (v *Versioned) MkdirAll(name string) error {
+	return v.F.MkdirAll(name)
+}
+
+func (v *Versioned) Pull(name string, w io.Writer) error {
+	return v.F.Pull(name, w)
+}
+
+func (v *Versioned) Push(name string, r io.Reader) error {
+	if v.isVersionArea(name) || IsMeta(name) {
+		return v.F.Push(name, r)
+	}
+	dest, err := v.preserve(name, true)
+	if err != nil {
+		return err
+	}
+	err = v.F.Push(name, r)
+	if err != nil {
+		if dest != "" {
+			// the failed push may have left a partial content
+			_ = v.F.Rename(dest, name)
+		}
+		return err
+	}
+	if dest == "" {
+		return nil
+	}
+	return v.Prune(name)
+}
+
+func (v *Versioned) Close() error {
+	return v.F.Close()
+}
+
+func (v *Versioned) String() string {
+	return fmt.Sprintf("%s#versioned", v.F)
+}
@@ -0,0 +1,104 @@
+package store
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestVersioned(t *testing.T) {
+	l := NewLocalMount(os.TempDir())
+	v := NewVersioned(l, VersionedConfig{Retention: RetentionPolicy{KeepLast: 2}}).(*Versioned)
+	_ = l.Remove(".versions/stg/test/versioned.txt")
+
+	name := "stg/test/versioned.txt"
+	for _, s := range []string{"first", "second", "third", "fourth"} {
+		assert.NoError(t, v.Push(name, bytes.NewBufferString(s)))
+	}
+	versions, err := v.Versions(name)
+	assert.NoError(t, err)
+	assert.Len(t, versions, 2)
+
+	assert.NoError(t, v.Restore(name, versions[1].ID))
+	data, err := ReadFile(v, name)
+	assert.NoError(t, err)
+	assert.Equal(t, "second", string(data))
+
+	assert.NoError(t, v.Remove(name))
+	_, err = v.Stat(name)
+	assert.True(t, os.IsNotExist(err))
+	versions, _ = v.Versions(name)
+	assert.Len(t, versions, 2)
+
+	ls, _ := v.ReadDir("", IncludeHiddenFiles)
+	for _, l := range ls {
+		assert.NotEqual(t, ".versions", l.Name())
+	}
+	_ = l.Remove(".versions/stg/test/versioned.txt")
+}
+
+type onRead func()
+
+func (o onRead) Read([]byte) (int, error) {
+	o()
+	return 0, io.EOF
+}
+
+func TestVersionedPushKeepsFile(t *testing.T) {
+	v := NewVersioned(NewMemory(nil, 0), VersionedConfig{})
+	name := "stg/test/versioned.txt"
+	assert.NoError(t, v.Push(name, bytes.NewBufferString("old")))
+
+	var during []byte
+	assert.NoError(t, v.Push(name, io.MultiReader(bytes.NewBufferString("new"), onRead(func() {
+		during, _ = ReadFile(v, name)
+	}))))
+	assert.Equal(t, "old", string(during))
+	data, _ := ReadFile(v, name)
+	assert.Equal(t, "new", string(data))
+	versions, _ := v.(*Versioned).Versions(name)
+	assert.Len(t, versions, 1)
+}
+
+func TestRetentionPolicy(t *testing.T) {
+	now := time.Date(2022, 3, 10, 12, 0, 0, 0, time.UTC)
+	var versions []Version
+	for i := 0; i < 40; i++ {
+		tm := now.Add(-time.Duration(i) * 12 * time.Hour)
+		versions = append(versions, Version{ID: tm.Format(versionLayout), Time: tm})
+	}
+
+	p := RetentionPolicy{Daily: 3, Weekly: 2}
+	expired := p.expired(versions, now)
+	assert.Len(t, expired, 40-4)
+
+	p = RetentionPolicy{KeepFor: 48 * time.Hour}
+	expired = p.expired(versions, now)
+	assert.Len(t, expired, 40-5)
+
+	p = RetentionPolicy{KeepLast: 8, KeepFor: 48 * time.Hour}
+	expired = p.expired(versions, now)
+	assert.Len(t, expired, 40-8)
+}
+
+func TestVersionedRemoveMovesMeta(t *testing.T) {
+	m := NewMemory(nil, 0)
+	v := NewVersioned(m, VersionedConfig{}).(*Versioned)
+	name := "stg/test/versioned.txt"
+	assert.NoError(t, v.Push(name, bytes.NewBufferString("content")))
+	assert.NoError(t, SetMeta(v, name, Attr{ModifiedBy: "someone"}))
+
+	assert.NoError(t, v.Remove(name))
+	_, err := m.Stat(metaName(name))
+	assert.True(t, os.IsNotExist(err))
+
+	versions, _ := v.Versions(name)
+	assert.Len(t, versions, 1)
+	var attr Attr
+	assert.NoError(t, GetMeta(m, path.Join(v.versionsDir(name), versions[0].ID), &attr))
+	assert.Equal(t, "someone", attr.ModifiedBy)
+}
@@ -0,0 +1,229 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"time"
+)
+
+type WORMConfig struct {
+	// Retention is how long a file cannot be changed or deleted after it is written
+	Retention time.Duration `json:"retention" yaml:"retention"`
+}
+
+// wormInfo is kept in the meta of each file written through WORM
+type wormInfo struct {
+	Written     time.Time
+	RetainUntil time.Time
+}
+
+func init() {
+	RegisterMeta("bbfs.worm", wormInfo{})
+}
+
+// WORM (write once, read many) allows new files but refuses to overwrite, rename or delete them
+// until their retention expires
+type WORM struct {
+	F         FS
+	Retention time.Duration
+}
+
+func NewWORM(f FS, config WORMConfig) FS {
+	return &WORM{f, config.Retention}
+}
+
+// RetainUntil returns the time when name can be changed. Files written outside WORM are retained
+// since their last modification
+func (w *WORM) RetainUntil(name string) (time.Time, error) {
+	l, err := w.F.Stat(name)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if l.IsDir() {
+		return time.Time{}, nil
+	}
+	var wi wormInfo
+	_ = GetMeta(w.F, name, &wi)
+	if wi.RetainUntil.IsZero() {
+		return l.ModTime().Add(w.Retention), nil
+	}
+	return wi.RetainUntil, nil
+}
+
+// locked returns os.ErrPermission when name, or a file inside it, is under retention.
+// The meta of a file is locked together with the file
+func (w *WORM) locked(name string) error {
+	if IsMeta(name) {
+		name = metaTarget(name)
+	}
+
+	l, err := w.F.Stat(name)
+	if err != nil {
+		return nil
+	}
+	names := []string{name}
+	if l.IsDir() {
+		names = nil
+		_ = Walk(w.F, name, IncludeHiddenFiles, func(dir string, file fs.FileInfo) {
+			if !IsMeta(file.Name()) {
+				names = append(names, path.Join(dir, file.Name()))
+			}
+		})
+	}
+
+	now := time.Now()
+	for _, n := range names {
+		until, err := w.RetainUntil(n)
+		if err == nil && now.Before(until) {
+			return fmt.Errorf("%s is retained until %s: %w", n, until.Format(time.RFC3339), os.ErrPermission)
+		}
+	}
+	return nil
+}
+
+func (w *WORM) Props() Props {
+	props := w.F.Props()
+	if retentionPeriod(props) < w.Retention {
+		props.Retention = time.Time{}.Add(w.Retention)
+	}
+	return props
+}
+
+func (w *WORM) ReadDir(name string, opts ListOption) ([]fs.FileInfo, error) {
+	return w.F.ReadDir(name, opts)
+}
+
+func (w *WORM) Watch(name string) chan string {
+	return w.F.Watch(name)
+}
+
+func (w *WORM) Stat(name string) (fs.FileInfo, error) {
+	return w.F.Stat(name)
+}
+
+func (w *WORM) Remove(name string) error {
+	if err := w.locked(name); err != nil {
+		return err
+	}
+	return w.F.Remove(name)
+}
+
+func (w *WORM) Touch(name string) error {
+	if err := w.locked(name); err != nil {
+		return err
+	}
+	return w.F.Touch(name)
+}
+
+func (w *WORM) Rename(old, new string) error {
+	if err := w.locked(old); err != nil {
+		return err
+	}
+	if err := w.locked(new); err != nil {
+		return err
+	}
+	return w.F.Rename(old, new)
+}
+
+func (w *WORM) MkdirAll(name string) error {
+	return w.F.MkdirAll(name)
+}
+
+func (w *WORM) Pull(name string, wr io.Writer) error {
+	return w.F.Pull(name, wr)
+}
+
+// keepsRetention returns os.ErrPermission when data, the new meta of the retained file name, drops or
+// shortens its retention. Other meta can change, e.g. when a sync records the attributes of the file
+func (w *WORM) keepsRetention(name string, data []byte) error {
+	until, err := w.RetainUntil(name)
+	if err != nil {
+		return err
+	}
+	doc := newMetaDoc()
+	if len(data) > 0 {
+		if doc, err = decodeMeta(data, name); err != nil {
+			return err
+		}
+	}
+	key, _ := metaKey(wormInfo{})
+	var wi wormInfo
+	if raw, ok := doc.Meta[key]; ok {
+		if err := json.Unmarshal(raw, &wi); err != nil {
+			return err
+		}
+	}
+
+	var current wormInfo
+	_ = GetMeta(w.F, name, &current)
+	if wi.RetainUntil.IsZero() && current.RetainUntil.IsZero() || !wi.RetainUntil.Before(until) {
+		return nil
+	}
+	return fmt.Errorf("%s is retained until %s: %w", name, until.Format(time.RFC3339), os.ErrPermission)
+}
+
+// Push records the retention of the new file in its meta. The meta of a retained file can be written
+// as long as its retention is kept
+func (w *WORM) Push(name string, r io.Reader) error {
+	if err := w.locked(name); err != nil {
+		if !IsMeta(name) || !errors.Is(err, os.ErrPermission) {
+			return err
+		}
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		if err := w.keepsRetention(metaTarget(name), data); err != nil {
+			return err
+		}
+		return w.F.Push(name, bytes.NewReader(data))
+	}
+	if err := w.F.Push(name, r); err != nil {
+		return err
+	}
+	if IsMeta(name) {
+		return nil
+	}
+	now := time.Now()
+	return SetMeta(w.F, name, wormInfo{Written: now, RetainUntil: now.Add(w.Retention)})
+}
+
+// ReadMeta reads the native meta of the inner store, when available
+func (w *WORM) ReadMeta(name string) ([]byte, error) {
+	n, ok := nativeMeta(w.F)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	return n.ReadMeta(name)
+}
+
+// WriteMeta writes the native meta of the inner store as long as the retention of name is kept
+func (w *WORM) WriteMeta(name string, data []byte) error {
+	n, ok := nativeMeta(w.F)
+	if !ok {
+		return ErrNotSupported
+	}
+	if err := w.locked(name); err != nil {
+		if !errors.Is(err, os.ErrPermission) {
+			return err
+		}
+		if err := w.keepsRetention(name, data); err != nil {
+			return err
+		}
+	}
+	return n.WriteMeta(name, data)
+}
+
+func (w *WORM) Close() error {
+	return w.F.Close()
+}
+
+func (w *WORM) String() string {
+	return fmt.Sprintf("%s#worm", w.F)
+}
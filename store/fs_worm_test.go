@@ -0,0 +1,53 @@
+package store
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWORM(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "stg/test/worm")
+	_ = os.RemoveAll(dir)
+	_ = os.MkdirAll(dir, 0755)
+	l := NewLocalMount(dir)
+
+	w := NewWORM(l, WORMConfig{Retention: time.Hour})
+	assert.Equal(t, time.Time{}.Add(time.Hour), w.Props().Retention)
+
+	assert.NoError(t, w.Push("archive/a.txt", bytes.NewBufferString("a")))
+	assert.ErrorIs(t, w.Push("archive/a.txt", bytes.NewBufferString("b")), os.ErrPermission)
+	assert.ErrorIs(t, w.Remove("archive/a.txt"), os.ErrPermission)
+	assert.ErrorIs(t, w.Remove("archive"), os.ErrPermission)
+	assert.ErrorIs(t, w.Rename("archive/a.txt", "archive/b.txt"), os.ErrPermission)
+	// other meta can change as long as the retention is kept
+	assert.NoError(t, SetMeta(w, "archive/a.txt", Attr{Group: "x"}))
+	var attr Attr
+	assert.NoError(t, GetMeta(l, "archive/a.txt", &attr))
+	assert.Equal(t, Group("x"), attr.Group)
+	assert.ErrorIs(t, SetMeta(w, "archive/a.txt", wormInfo{RetainUntil: time.Now()}), os.ErrPermission)
+	assert.NoError(t, SetMeta(w, "archive/a.txt", wormInfo{RetainUntil: time.Now().Add(2 * time.Hour)}))
+	until, err := w.(*WORM).RetainUntil("archive/a.txt")
+	assert.NoError(t, err)
+	assert.True(t, until.After(time.Now().Add(time.Hour)))
+	// without native meta the sidecar is checked in the same way
+	s := NewWORM(NewMemory(nil, 0), WORMConfig{Retention: time.Hour})
+	assert.NoError(t, s.Push("archive/s.txt", bytes.NewBufferString("s")))
+	assert.NoError(t, SetMeta(s, "archive/s.txt", Attr{Group: "y"}))
+	assert.ErrorIs(t, SetMeta(s, "archive/s.txt", wormInfo{RetainUntil: time.Now()}), os.ErrPermission)
+
+	// the retention has expired
+	assert.NoError(t, SetMeta(l, "archive/a.txt", wormInfo{RetainUntil: time.Now().Add(-time.Second)}))
+	assert.NoError(t, w.Rename("archive/a.txt", "archive/b.txt"))
+
+	r := NewReadOnly(l)
+	data, err := ReadFile(r, "archive/b.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "a", string(data))
+	assert.ErrorIs(t, r.Push("archive/c.txt", bytes.NewBufferString("c")), os.ErrPermission)
+	assert.ErrorIs(t, r.Remove("archive/b.txt"), os.ErrPermission)
+	assert.ErrorIs(t, r.MkdirAll("other"), os.ErrPermission)
+}
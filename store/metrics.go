@@ -0,0 +1,190 @@
+package store
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type metricKind string
+
+const (
+	counterKind   metricKind = "counter"
+	gaugeKind     metricKind = "gauge"
+	histogramKind metricKind = "histogram"
+)
+
+// LatencyBuckets are the upper bounds in seconds of the latency histograms
+var LatencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60}
+
+type metricSeries struct {
+	labels  string
+	value   float64
+	buckets []uint64
+	count   uint64
+}
+
+type metricFamily struct {
+	name   string
+	help   string
+	kind   metricKind
+	series map[string]*metricSeries
+}
+
+// Registry collects counters, gauges and histograms and exposes them in the Prometheus text format
+type Registry struct {
+	lock     sync.Mutex
+	families map[string]*metricFamily
+}
+
+// DefaultMetrics is the registry used by the Metrics decorator and the mesh
+var DefaultMetrics = NewRegistry()
+
+func NewRegistry() *Registry {
+	return &Registry{families: map[string]*metricFamily{}}
+}
+
+// formatLabels converts pairs of name and value in the Prometheus label notation
+func formatLabels(labels []string) string {
+	var parts []string
+	for i := 0; i+1 < len(labels); i += 2 {
+		v := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(labels[i+1])
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, labels[i], v))
+	}
+	return strings.Join(parts, ",")
+}
+
+func (r *Registry) get(name, help string, kind metricKind, labels []string) *metricSeries {
+	f, ok := r.families[name]
+	if !ok {
+		f = &metricFamily{name: name, help: help, kind: kind, series: map[string]*metricSeries{}}
+		r.families[name] = f
+	}
+	l := formatLabels(labels)
+	s, ok := f.series[l]
+	if !ok {
+		s = &metricSeries{labels: l}
+		if kind == histogramKind {
+			s.buckets = make([]uint64, len(LatencyBuckets))
+		}
+		f.series[l] = s
+	}
+	return s
+}
+
+// Add increases the counter name by v. Labels are pairs of name and value
+func (r *Registry) Add(name, help string, v float64, labels ...string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.get(name, help, counterKind, labels).value += v
+}
+
+// Set assigns v to the gauge name. Labels are pairs of name and value
+func (r *Registry) Set(name, help string, v float64, labels ...string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.get(name, help, gaugeKind, labels).value = v
+}
+
+// Observe adds the sample v to the histogram name. Labels are pairs of name and value
+func (r *Registry) Observe(name, help string, v float64, labels ...string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	s := r.get(name, help, histogramKind, labels)
+	s.value += v
+	s.count++
+	for i, b := range LatencyBuckets {
+		if v <= b {
+			s.buckets[i]++
+		}
+	}
+}
+
+// Value returns the current value of a counter or a gauge, or the sum of a histogram
+func (r *Registry) Value(name string, labels ...string) float64 {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if f, ok := r.families[name]; ok {
+		if s, ok := f.series[formatLabels(labels)]; ok {
+			return s.value
+		}
+	}
+	return 0
+}
+
+func withLabel(labels, extra string) string {
+	switch {
+	case labels == "":
+		return fmt.Sprintf("{%s}", extra)
+	case extra == "":
+		return fmt.Sprintf("{%s}", labels)
+	default:
+		return fmt.Sprintf("{%s,%s}", labels, extra)
+	}
+}
+
+func formatValue(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return fmt.Sprintf("%g", v)
+}
+
+// WriteText writes all the metrics in the Prometheus text exposition format
+func (r *Registry) WriteText(w io.Writer) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	var names []string
+	for n := range r.families {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	for _, n := range names {
+		f := r.families[n]
+		fmt.Fprintf(&sb, "# HELP %s %s\n# TYPE %s %s\n", f.name, f.help, f.name, f.kind)
+
+		var keys []string
+		for k := range f.series {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			s := f.series[k]
+			if f.kind != histogramKind {
+				if s.labels == "" {
+					fmt.Fprintf(&sb, "%s %s\n", f.name, formatValue(s.value))
+				} else {
+					fmt.Fprintf(&sb, "%s{%s} %s\n", f.name, s.labels, formatValue(s.value))
+				}
+				continue
+			}
+			for i, b := range LatencyBuckets {
+				fmt.Fprintf(&sb, "%s_bucket%s %d\n", f.name,
+					withLabel(s.labels, fmt.Sprintf(`le="%s"`, formatValue(b))), s.buckets[i])
+			}
+			fmt.Fprintf(&sb, "%s_bucket%s %d\n", f.name, withLabel(s.labels, `le="+Inf"`), s.count)
+			if s.labels == "" {
+				fmt.Fprintf(&sb, "%s_sum %s\n%s_count %d\n", f.name, formatValue(s.value), f.name, s.count)
+			} else {
+				fmt.Fprintf(&sb, "%s_sum{%s} %s\n%s_count{%s} %d\n", f.name, s.labels,
+					formatValue(s.value), f.name, s.labels, s.count)
+			}
+		}
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// ServeHTTP exposes the registry as a /metrics endpoint
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.WriteHeader(http.StatusOK)
+	_ = r.WriteText(w)
+}
@@ -0,0 +1,33 @@
+package store
+
+import (
+	"errors"
+	"golang.org/x/sys/unix"
+	"os"
+)
+
+// reflink creates dest as a clone of src that shares its blocks until one of them is written. It returns
+// ErrNotSupported when the file system cannot clone files
+func reflink(src, dest string, perm os.FileMode) error {
+	s, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	d, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	err = unix.IoctlFileClone(int(d.Fd()), int(s.Fd()))
+	if cerr := d.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(dest)
+	}
+	if errors.Is(err, unix.EOPNOTSUPP) || errors.Is(err, unix.EXDEV) || errors.Is(err, unix.EINVAL) ||
+		errors.Is(err, unix.ENOTTY) {
+		return ErrNotSupported
+	}
+	return err
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package store
+
+import "os"
+
+func reflink(src, dest string, perm os.FileMode) error {
+	return ErrNotSupported
+}
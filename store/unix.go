@@ -22,3 +22,12 @@ func GetFileOwner(path string) (string, error) {
 	}
 	return user.Name, nil
 }
+
+// lockFile waits for an exclusive lock on f, which is released by unlockFile or when f is closed
+func lockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+}
+
+func unlockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
@@ -2,25 +2,41 @@ package store
 
 import (
 	"context"
+	"fmt"
 	"io"
+	"os"
 	"time"
 )
 
+// Copy copies src in from to dest in to. With includeMeta the meta goes along, whether it is stored natively
+// or in a sidecar on either side. The meta that the destination sets on write, e.g. the retention of WORM,
+// is kept unless src has the same key
 func Copy(from, to FS, src, dest string, includeMeta bool, timeout time.Duration) error {
-	if includeMeta {
-		_, err := from.Stat(metaName(src))
-		if err == nil {
-			err := copyFile(from, to, metaName(src), metaName(dest), timeout)
-			if err == context.Canceled {
-				return err
+	_, statErr := to.Stat(dest)
+	err := copyFile(from, to, src, dest, timeout)
+	if err != nil || !includeMeta {
+		return err
+	}
+
+	doc, err := loadMeta(from, src)
+	if err != nil {
+		return nil
+	}
+	if current, err := loadMeta(to, dest); err == nil {
+		for k, v := range current.Meta {
+			if _, ok := doc.Meta[k]; !ok {
+				doc.Meta[k] = v
 			}
 		}
 	}
-	err := copyFile(from, to, src, dest, timeout)
-	if err != nil && includeMeta {
-		_ = to.Remove(metaName(dest))
+	if err = storeMeta(to, dest, doc); err != nil {
+		// a new file is undone when the meta cannot follow, while a replaced file is left in place
+		if os.IsNotExist(statErr) {
+			_ = to.Remove(dest)
+		}
+		return fmt.Errorf("cannot copy the meta of %s: %w", src, err)
 	}
-	return err
+	return nil
 }
 
 func copyFile(from, to FS, src, dest string, timeout time.Duration) error {
@@ -37,8 +53,8 @@ func copyFile(from, to FS, src, dest string, timeout time.Duration) error {
 	pr, pw := io.Pipe()
 
 	go func() {
-		_ = from.Pull(src, pw)
-		pw.Close()
+		// a failed read must fail the push, not end the content early
+		_ = pw.CloseWithError(from.Pull(src, pw))
 	}()
 	go func() {
 		defer pr.Close()
@@ -3,8 +3,10 @@ package store
 import (
 	"bytes"
 	"github.com/stretchr/testify/assert"
+	"io"
 	"os"
 	"testing"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -28,3 +30,41 @@ func TestFSClone(t *testing.T) {
 	assert.NoError(t, err)
 
 }
+
+// metaLockedFS refuses to write meta sidecars
+type metaLockedFS struct {
+	FS
+}
+
+func (f *metaLockedFS) Push(name string, r io.Reader) error {
+	if IsMeta(name) {
+		return os.ErrPermission
+	}
+	return f.FS.Push(name, r)
+}
+
+func TestCopyMeta(t *testing.T) {
+	from := NewMemory(nil, 0)
+	assert.NoError(t, from.Push("a.txt", bytes.NewBufferString("a")))
+	assert.NoError(t, SetMeta(from, "a.txt", Attr{Group: "dev"}))
+
+	// the retention set by WORM on write is kept with the copied meta
+	w := NewWORM(NewMemory(nil, 0), WORMConfig{Retention: time.Hour})
+	assert.NoError(t, Copy(from, w, "a.txt", "a.txt", true, 0))
+	var attr Attr
+	var wi wormInfo
+	assert.NoError(t, GetMeta(w, "a.txt", &attr, &wi))
+	assert.Equal(t, Group("dev"), attr.Group)
+	assert.False(t, wi.RetainUntil.IsZero())
+
+	// a new file is removed when its meta cannot follow, a replaced file is not
+	to := &metaLockedFS{NewMemory(nil, 0)}
+	assert.ErrorIs(t, Copy(from, to, "a.txt", "b.txt", true, 0), os.ErrPermission)
+	_, err := to.Stat("b.txt")
+	assert.True(t, os.IsNotExist(err))
+	assert.NoError(t, to.Push("c.txt", bytes.NewBufferString("old")))
+	assert.ErrorIs(t, Copy(from, to, "a.txt", "c.txt", true, 0), os.ErrPermission)
+	data, err := ReadFile(to, "c.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "a", string(data))
+}
@@ -0,0 +1,253 @@
+package store
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/hashicorp/go-multierror"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Linker is implemented by the backends that can make a file share the storage of another while the two
+// stay independent, e.g. with a reflink
+type Linker interface {
+	// Link replaces dest with a clone of src
+	Link(src, dest string) error
+}
+
+// linker returns the backend of f when it can link files and the decorators in between keep the paths
+// and store the same content for the same data
+func linker(f FS) (Linker, bool) {
+	for f != nil {
+		if l, ok := f.(Linker); ok {
+			return l, true
+		}
+		switch f.(type) {
+		case *Retry, *BWLimit, *Metrics, *MetaAware, *Hashed, *Compressed:
+			f = Unwrap(f)
+		default:
+			return nil, false
+		}
+	}
+	return nil, false
+}
+
+// DuplicateAction is what ResolveDuplicates does with the extra copies of a file in the same store
+type DuplicateAction string
+
+const (
+	// DuplicateDelete removes the extra copies
+	DuplicateDelete DuplicateAction = "delete"
+	// DuplicateLink replaces the extra copies with clones of the first copy that share its storage
+	DuplicateLink DuplicateAction = "link"
+)
+
+// StoreFolder is a folder of a named store
+type StoreFolder struct {
+	Name   string
+	F      FS
+	Folder string
+}
+
+// DuplicateFile is a copy of a duplicated file
+type DuplicateFile struct {
+	Store string
+	Path  string
+}
+
+// DuplicateSet lists the copies of the same content, in the order of the stores and then by path
+type DuplicateSet struct {
+	Size  int64
+	Hash  string
+	Files []DuplicateFile
+}
+
+// Wasted returns the space used by the copies after the first
+func (s DuplicateSet) Wasted() int64 {
+	return s.Size * int64(len(s.Files)-1)
+}
+
+// DuplicatesReport lists the duplicated files, the largest waste first
+type DuplicatesReport struct {
+	// Files is the number of files that have been compared
+	Files  int
+	Sets   []DuplicateSet
+	Wasted int64
+}
+
+type duplicateCandidate struct {
+	DuplicateFile
+	f    FS
+	info fs.FileInfo
+}
+
+// contentHash returns the sha256 of name from its Attr or, when not recorded, from its content. The
+// recorded hash may be stale, so it is only good to find candidates
+func contentHash(f FS, name string) (string, error) {
+	var attr Attr
+	_ = GetMeta(f, name, &attr)
+	if h, ok := attr.Hashes[HashSHA256]; ok {
+		return h, nil
+	}
+	return readHash(f, name)
+}
+
+// readHash returns the sha256 of the content of name
+func readHash(f FS, name string) (string, error) {
+	h, _ := NewHash(HashSHA256)
+	if err := f.Pull(name, h); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// FindDuplicates walks the folders and groups their files by size and then, for the sizes shared by many
+// files, by content hash. Files smaller than minSize are ignored. Hard links to the same file are counted once
+func FindDuplicates(folders []StoreFolder, minSize int64) (DuplicatesReport, error) {
+	var report DuplicatesReport
+	bySize := map[int64][]duplicateCandidate{}
+	for _, sf := range folders {
+		err := Walk(sf.F, sf.Folder, 0, func(dir string, info fs.FileInfo) {
+			if IsMeta(info.Name()) || info.Size() < minSize || info.Size() == 0 {
+				return
+			}
+			report.Files++
+			c := duplicateCandidate{DuplicateFile{sf.Name, path.Join(dir, info.Name())}, sf.F, info}
+			for _, o := range bySize[info.Size()] {
+				if o.f == c.f && os.SameFile(o.info, c.info) {
+					return
+				}
+			}
+			bySize[info.Size()] = append(bySize[info.Size()], c)
+		})
+		if err != nil {
+			return report, fmt.Errorf("cannot walk %s: %w", sf.Name, err)
+		}
+	}
+
+	var me *multierror.Error
+	for size, cs := range bySize {
+		if len(cs) < 2 {
+			continue
+		}
+		byHash := map[string][]DuplicateFile{}
+		for _, c := range cs {
+			h, err := contentHash(c.f, c.Path)
+			if err != nil {
+				me = multierror.Append(me, fmt.Errorf("cannot hash %s/%s: %w", c.Store, c.Path, err))
+				continue
+			}
+			byHash[h] = append(byHash[h], c.DuplicateFile)
+		}
+		for h, files := range byHash {
+			if len(files) > 1 {
+				set := DuplicateSet{Size: size, Hash: h, Files: files}
+				report.Sets = append(report.Sets, set)
+				report.Wasted += set.Wasted()
+			}
+		}
+	}
+
+	order := map[string]int{}
+	for i, sf := range folders {
+		order[sf.Name] = i
+	}
+	for _, s := range report.Sets {
+		sort.Slice(s.Files, func(i, j int) bool {
+			a, b := s.Files[i], s.Files[j]
+			if a.Store != b.Store {
+				return order[a.Store] < order[b.Store]
+			}
+			return a.Path < b.Path
+		})
+	}
+	sort.Slice(report.Sets, func(i, j int) bool {
+		if report.Sets[i].Wasted() != report.Sets[j].Wasted() {
+			return report.Sets[i].Wasted() > report.Sets[j].Wasted()
+		}
+		return report.Sets[i].Hash < report.Sets[j].Hash
+	})
+	return report, me.ErrorOrNil()
+}
+
+// storeOf returns the store of file among folders: the folder with its name that contains its path.
+// Folders of different stores with the same name, e.g. local paths, make the store ambiguous
+func storeOf(folders []StoreFolder, file DuplicateFile) (FS, error) {
+	var found FS
+	for _, sf := range folders {
+		folder := path.Clean(sf.Folder)
+		if sf.Name != file.Store || folder != "." && folder != "/" && file.Path != folder &&
+			!strings.HasPrefix(file.Path, folder+"/") {
+			continue
+		}
+		if found != nil && found != sf.F {
+			return nil, fmt.Errorf("%s is in more than one store named %s", file.Path, file.Store)
+		}
+		found = sf.F
+	}
+	return found, nil
+}
+
+// ResolveDuplicates deletes or links the extra copies in the report. Copies are only changed within a
+// store: the first copy in each store is kept, and copies in other stores are left as replicas. The
+// content of each copy is read again and compared with the kept copy before it is changed, since the
+// report may be stale. It returns the number of files that have been changed
+func ResolveDuplicates(folders []StoreFolder, report DuplicatesReport, action DuplicateAction) (int, error) {
+	var me *multierror.Error
+	var changed int
+	for _, s := range report.Sets {
+		type kept struct {
+			path string
+			hash string
+		}
+		first := map[FS]*kept{}
+		for _, file := range s.Files {
+			f, err := storeOf(folders, file)
+			if err != nil {
+				me = multierror.Append(me, err)
+				continue
+			}
+			if f == nil {
+				continue
+			}
+			keep, found := first[f]
+			if !found {
+				first[f] = &kept{path: file.Path}
+				continue
+			}
+
+			if keep.hash == "" {
+				if keep.hash, err = readHash(f, keep.path); err != nil {
+					me = multierror.Append(me, fmt.Errorf("cannot read %s/%s: %w", file.Store, keep.path, err))
+					continue
+				}
+			}
+			h, err := readHash(f, file.Path)
+			switch {
+			case err != nil:
+				err = fmt.Errorf("cannot read: %w", err)
+			case h != keep.hash:
+				err = fmt.Errorf("content differs from %s", keep.path)
+			case action == DuplicateDelete:
+				err = f.Remove(file.Path)
+			case action == DuplicateLink:
+				if l, ok := linker(f); ok {
+					err = l.Link(keep.path, file.Path)
+				} else {
+					err = ErrNotSupported
+				}
+			default:
+				err = fmt.Errorf("unknown action %s", action)
+			}
+			if err != nil {
+				me = multierror.Append(me, fmt.Errorf("cannot %s %s/%s: %w", action, file.Store, file.Path, err))
+			} else {
+				changed++
+			}
+		}
+	}
+	return changed, me.ErrorOrNil()
+}
@@ -0,0 +1,80 @@
+package store
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDuplicates(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "stg/test/duplicates")
+	_ = os.RemoveAll(dir)
+	_ = os.MkdirAll(dir, 0755)
+	l := NewLocalMount(dir)
+	m := NewMemory(nil, 0)
+
+	data := bytes.Repeat([]byte("duplicated"), 100)
+	other := bytes.Repeat([]byte("different!"), 100)
+	assert.NoError(t, l.Push("a/x", bytes.NewReader(data)))
+	assert.NoError(t, l.Push("b/y", bytes.NewReader(data)))
+	assert.NoError(t, l.Push("b/z", bytes.NewReader(other)))
+	assert.NoError(t, l.Push("small", bytes.NewReader([]byte("duplicated"))))
+	assert.NoError(t, m.Push("x", bytes.NewReader(data)))
+	assert.NoError(t, m.Push("y", bytes.NewReader(data)))
+
+	folders := []StoreFolder{{"local", l, ""}, {"mem", m, ""}}
+	report, err := FindDuplicates(folders, 100)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, report.Files)
+	assert.Len(t, report.Sets, 1)
+	assert.Equal(t, []DuplicateFile{{"local", "a/x"}, {"local", "b/y"}, {"mem", "x"}, {"mem", "y"}},
+		report.Sets[0].Files)
+	assert.Equal(t, int64(3000), report.Wasted)
+
+	changed, err := ResolveDuplicates(folders, report, DuplicateLink)
+	assert.ErrorIs(t, err, ErrNotSupported)
+	if changed == 1 {
+		// the clone is independent of the kept copy
+		assert.NoError(t, l.Push("b/y", bytes.NewReader(other)))
+		kept, err := ReadFile(l, "a/x")
+		assert.NoError(t, err)
+		assert.Equal(t, data, kept)
+		assert.NoError(t, l.Push("b/y", bytes.NewReader(data)))
+	}
+	changed, err = ResolveDuplicates(folders[1:], report, DuplicateDelete)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, changed)
+
+	// hard links are counted once
+	assert.NoError(t, os.Remove(filepath.Join(dir, "b/y")))
+	assert.NoError(t, os.Link(filepath.Join(dir, "a/x"), filepath.Join(dir, "b/y")))
+	report, err = FindDuplicates(folders, 100)
+	assert.NoError(t, err)
+	assert.Equal(t, []DuplicateFile{{"local", "a/x"}, {"mem", "x"}}, report.Sets[0].Files)
+
+	// a stale hash in the meta does not make a different file deleted
+	assert.NoError(t, l.Push("c/w", bytes.NewReader(other)))
+	sums, _ := NewHashSet(HashSHA256)
+	_, _ = sums.Write(data)
+	assert.NoError(t, SetMeta(l, "c/w", Attr{Hashes: sums.Sums()}))
+	report, err = FindDuplicates(folders[:1], 100)
+	assert.NoError(t, err)
+	assert.Equal(t, []DuplicateFile{{"local", "a/x"}, {"local", "c/w"}}, report.Sets[0].Files)
+	changed, err = ResolveDuplicates(folders[:1], report, DuplicateDelete)
+	assert.Error(t, err)
+	assert.Zero(t, changed)
+	_, err = l.Stat("c/w")
+	assert.NoError(t, err)
+
+	// stores with the same name are not mixed up
+	ambiguous := []StoreFolder{{".", l, ""}, {".", m, ""}}
+	report, err = FindDuplicates(ambiguous, 100)
+	assert.NoError(t, err)
+	changed, err = ResolveDuplicates(ambiguous, report, DuplicateDelete)
+	assert.Error(t, err)
+	assert.Zero(t, changed)
+	_, err = l.Stat("a/x")
+	assert.NoError(t, err)
+}
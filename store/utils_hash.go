@@ -1,11 +1,86 @@
 package store
 
 import (
+	"crypto/md5"
 	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc64"
 	"io"
+	"lukechampine.com/blake3"
 	"os"
+	"sync"
 )
 
+const (
+	HashSHA256 = "sha256"
+	HashBLAKE3 = "blake3"
+	HashCRC64  = "crc64"
+	// HashMD5 is only used to compare with the checksums kept by the backends
+	HashMD5 = "md5"
+)
+
+var hashes = map[string]func() hash.Hash{}
+var hashesLock sync.RWMutex
+
+// RegisterHash makes the hash algorithm available to Hashed and Verify under name
+func RegisterHash(name string, newHash func() hash.Hash) {
+	hashesLock.Lock()
+	defer hashesLock.Unlock()
+	hashes[name] = newHash
+}
+
+func init() {
+	RegisterHash(HashSHA256, sha256.New)
+	RegisterHash(HashBLAKE3, func() hash.Hash { return blake3.New(32, nil) })
+	RegisterHash(HashCRC64, func() hash.Hash { return crc64.New(crc64.MakeTable(crc64.ECMA)) })
+	RegisterHash(HashMD5, md5.New)
+}
+
+// NewHash returns a new hash for the algorithm name
+func NewHash(name string) (hash.Hash, error) {
+	hashesLock.RLock()
+	defer hashesLock.RUnlock()
+	newHash, ok := hashes[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown hash algorithm %s: %w", name, ErrNotSupported)
+	}
+	return newHash(), nil
+}
+
+// HashSet computes many hashes on the same data. It is a writer, so that it can be fed by an io.TeeReader
+// while the data is transferred
+type HashSet map[string]hash.Hash
+
+func NewHashSet(algorithms ...string) (HashSet, error) {
+	hs := HashSet{}
+	for _, a := range algorithms {
+		h, err := NewHash(a)
+		if err != nil {
+			return nil, err
+		}
+		hs[a] = h
+	}
+	return hs, nil
+}
+
+func (hs HashSet) Write(p []byte) (int, error) {
+	for _, h := range hs {
+		_, _ = h.Write(p)
+	}
+	return len(p), nil
+}
+
+// Sums returns the hex encoded hashes by algorithm
+func (hs HashSet) Sums() map[string]string {
+	sums := map[string]string{}
+	for a, h := range hs {
+		sums[a] = hex.EncodeToString(h.Sum(nil))
+	}
+	return sums
+}
+
 func GetHash(path string) ([]byte, error) {
 	info, err := os.Stat(path)
 	if err != nil {
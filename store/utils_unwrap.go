@@ -0,0 +1,33 @@
+package store
+
+import "reflect"
+
+// Unwrap returns the file storage wrapped by the decorator f, or nil when f is not a decorator.
+// Decorators keep the wrapped file storage in the field F
+func Unwrap(f FS) FS {
+	v := reflect.ValueOf(f)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	field := v.FieldByName("F")
+	if !field.IsValid() || !field.CanInterface() {
+		return nil
+	}
+	inner, _ := field.Interface().(FS)
+	return inner
+}
+
+// Find looks for a decorator of type T in the chain of decorators of f
+func Find[T FS](f FS) (T, bool) {
+	for f != nil {
+		if t, ok := f.(T); ok {
+			return t, true
+		}
+		f = Unwrap(f)
+	}
+	var zero T
+	return zero, false
+}
@@ -0,0 +1,266 @@
+package store
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// VaultPrefix marks a configuration value that must be read from the vault, e.g. vault:s3-secret
+const VaultPrefix = "vault:"
+
+// EnvPrefix marks a configuration value that must be read from an environment variable, e.g. env:S3_SECRET
+const EnvPrefix = "env:"
+
+const vaultSaltSize = 16
+
+var ErrVaultLocked = errors.New("vault is not available")
+var ErrVaultKey = errors.New("invalid vault passphrase or key file")
+
+// Vault is an encrypted collection of secrets that configurations can reference with vault:<id>
+type Vault struct {
+	secrets map[string]string
+	salt    []byte
+	key     []byte
+	lock    sync.Mutex
+}
+
+func deriveVaultKey(passphrase []byte, salt []byte) ([]byte, error) {
+	return scrypt.Key(passphrase, salt, 1<<15, 8, 1, 32)
+}
+
+// NewVault creates an empty vault protected by the provided passphrase
+func NewVault(passphrase []byte) (*Vault, error) {
+	salt := make([]byte, vaultSaltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	key, err := deriveVaultKey(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Vault{
+		secrets: map[string]string{},
+		salt:    salt,
+		key:     key,
+	}, nil
+}
+
+// OpenVault reads the vault stored in name. An empty vault is returned when the file does not exist
+func OpenVault(f FS, name string, passphrase []byte) (*Vault, error) {
+	var s ByteStream
+	err := f.Pull(name, &s)
+	if os.IsNotExist(err) {
+		return NewVault(passphrase)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(s.Data) < vaultSaltSize {
+		return nil, ErrVaultKey
+	}
+
+	salt := s.Data[0:vaultSaltSize]
+	key, err := deriveVaultKey(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	b, err := NewAesCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := DecryptBytes(b, s.Data[vaultSaltSize:])
+	if err != nil {
+		return nil, ErrVaultKey
+	}
+
+	v := &Vault{
+		secrets: map[string]string{},
+		salt:    salt,
+		key:     key,
+	}
+	if err = json.Unmarshal(data, &v.secrets); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Save encrypts the vault and writes it to name
+func (v *Vault) Save(f FS, name string) error {
+	v.lock.Lock()
+	data, err := json.Marshal(v.secrets)
+	v.lock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	b, err := NewAesCipher(v.key)
+	if err != nil {
+		return err
+	}
+	data, err = EncryptBytes(b, data)
+	if err != nil {
+		return err
+	}
+	return f.Push(name, &ByteStream{append(append([]byte{}, v.salt...), data...), 0})
+}
+
+// Get returns the secret with the given id
+func (v *Vault) Get(id string) (string, bool) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	s, ok := v.secrets[id]
+	return s, ok
+}
+
+// Set adds or replaces the secret with the given id
+func (v *Vault) Set(id string, secret string) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	v.secrets[id] = secret
+}
+
+// Delete removes the secret with the given id
+func (v *Vault) Delete(id string) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	delete(v.secrets, id)
+}
+
+// List returns the sorted ids of all the secrets in the vault
+func (v *Vault) List() []string {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	var ids []string
+	for id := range v.secrets {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// ResolveSecret returns the value s refers to when it starts with vault: or env:, otherwise s itself.
+// The vault v can be nil when no vault reference is expected
+func ResolveSecret(s string, v *Vault) (string, error) {
+	switch {
+	case strings.HasPrefix(s, VaultPrefix):
+		id := s[len(VaultPrefix):]
+		if v == nil {
+			return "", fmt.Errorf("cannot resolve %s: %w", s, ErrVaultLocked)
+		}
+		secret, ok := v.Get(id)
+		if !ok {
+			return "", fmt.Errorf("secret '%s' not found in vault", id)
+		}
+		return secret, nil
+	case strings.HasPrefix(s, EnvPrefix):
+		name := s[len(EnvPrefix):]
+		secret, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable '%s' not defined", name)
+		}
+		return secret, nil
+	default:
+		return s, nil
+	}
+}
+
+// ResolveSecrets replaces in place the credential fields of target (usually a *Config) that refer
+// to the vault or to environment variables. Credential fields are tagged with secret:"true"
+func ResolveSecrets(target interface{}, v *Vault) error {
+	return walkSecrets(reflect.ValueOf(target), "", false, func(_ string, s string) (string, error) {
+		return ResolveSecret(s, v)
+	})
+}
+
+// SealSecrets moves the plain values of the credential fields of target to the vault v and replaces
+// them with references. The ids start with prefix and follow the path of the field, e.g. prefix-s3-secret.
+// It returns the number of values moved. With a nil vault, the plain values are only counted
+func SealSecrets(target interface{}, v *Vault, prefix string) (int, error) {
+	var n int
+	err := walkSecrets(reflect.ValueOf(target), prefix, false, func(id string, s string) (string, error) {
+		if s == "" || strings.HasPrefix(s, VaultPrefix) || strings.HasPrefix(s, EnvPrefix) {
+			return s, nil
+		}
+		n++
+		if v == nil {
+			return s, nil
+		}
+		v.Set(id, s)
+		return VaultPrefix + id, nil
+	})
+	return n, err
+}
+
+// walkSecrets calls replace on the strings of r that are credentials, i.e. in a field tagged with
+// secret:"true", and sets them to the returned value. id is the path of r
+func walkSecrets(r reflect.Value, id string, secret bool, replace func(id string, s string) (string, error)) error {
+	join := func(k string) string {
+		if id == "" {
+			return k
+		}
+		return id + "-" + k
+	}
+
+	switch r.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if r.IsNil() {
+			return nil
+		}
+		return walkSecrets(r.Elem(), id, secret, replace)
+	case reflect.Struct:
+		for i := 0; i < r.NumField(); i++ {
+			field := r.Type().Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			name := strings.Split(field.Tag.Get("json"), ",")[0]
+			if name == "" || name == "-" {
+				name = strings.ToLower(field.Name)
+			}
+			err := walkSecrets(r.Field(i), join(name), field.Tag.Get("secret") == "true", replace)
+			if err != nil {
+				return err
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < r.Len(); i++ {
+			if err := walkSecrets(r.Index(i), join(fmt.Sprint(i)), secret, replace); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		for _, k := range r.MapKeys() {
+			e := reflect.New(r.Type().Elem()).Elem()
+			e.Set(r.MapIndex(k))
+			if err := walkSecrets(e, join(fmt.Sprint(k.Interface())), secret, replace); err != nil {
+				return err
+			}
+			r.SetMapIndex(k, e)
+		}
+	case reflect.String:
+		if !secret || !r.CanSet() {
+			return nil
+		}
+		s, err := replace(id, r.String())
+		if err != nil {
+			return err
+		}
+		r.SetString(s)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package store
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestVault(t *testing.T) {
+	l := NewLocalMount(os.TempDir())
+	name := "stg/test/vault.bin"
+
+	v, err := NewVault([]byte("passphrase"))
+	assert.NoError(t, err)
+	v.Set("s3-secret", "very secret")
+	assert.NoError(t, v.Save(l, name))
+
+	_, err = OpenVault(l, name, []byte("wrong"))
+	assert.ErrorIs(t, err, ErrVaultKey)
+
+	v, err = OpenVault(l, name, []byte("passphrase"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"s3-secret"}, v.List())
+
+	_ = os.Setenv("BBFS_TEST_PASSWORD", "from env")
+	c := Config{
+		Name: "test",
+		S3:   &S3Config{Secret: "vault:s3-secret", AccessKey: "plain", Bucket: "env:BBFS_TEST_PASSWORD"},
+		FTP:  &FTPConfig{Password: "env:BBFS_TEST_PASSWORD"},
+	}
+	assert.NoError(t, ResolveSecrets(&c, v))
+	assert.Equal(t, "very secret", c.S3.Secret)
+	assert.Equal(t, "plain", c.S3.AccessKey)
+	assert.Equal(t, "from env", c.FTP.Password)
+	// only the credential fields are resolved
+	assert.Equal(t, "env:BBFS_TEST_PASSWORD", c.S3.Bucket)
+
+	c.S3.Secret = "vault:s3-secret"
+	assert.ErrorIs(t, ResolveSecrets(&c, nil), ErrVaultLocked)
+
+	_ = l.Remove(name)
+}
+
+func TestSealSecrets(t *testing.T) {
+	v, err := NewVault([]byte("passphrase"))
+	assert.NoError(t, err)
+	c := Config{
+		Name:       "test",
+		S3:         &S3Config{Secret: "plain secret", AccessKey: "id"},
+		Encryption: &EncryptionConfig{Key: "env:BBFS_KEY"},
+	}
+	n, err := SealSecrets(&c, nil, "test")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, "plain secret", c.S3.Secret)
+
+	n, err = SealSecrets(&c, v, "test")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, "vault:test-s3-secret", c.S3.Secret)
+	assert.Equal(t, "id", c.S3.AccessKey)
+	assert.Equal(t, "env:BBFS_KEY", c.Encryption.Key)
+
+	secret, ok := v.Get("test-s3-secret")
+	assert.True(t, ok)
+	assert.Equal(t, "plain secret", secret)
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/hectane/go-acl/api"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/sys/windows"
+	"os"
 	"os/user"
 )
 
@@ -37,3 +38,12 @@ func GetFileOwner(path string) (string, error) {
 	logrus.Debugf("Owner of %s is %s (%s)", path, u.Name, owner)
 	return u.Name, nil
 }
+
+// lockFile waits for an exclusive lock on f, which is released by unlockFile or when f is closed
+func lockFile(f *os.File) error {
+	return windows.LockFileEx(windows.Handle(f.Fd()), windows.LOCKFILE_EXCLUSIVE_LOCK, 0, 1, 0, &windows.Overlapped{})
+}
+
+func unlockFile(f *os.File) error {
+	return windows.UnlockFileEx(windows.Handle(f.Fd()), 0, 1, 0, &windows.Overlapped{})
+}
@@ -0,0 +1,12 @@
+//go:build !darwin && !linux
+// +build !darwin,!linux
+
+package store
+
+func getXattr(p, attr string) ([]byte, error) {
+	return nil, ErrNotSupported
+}
+
+func setXattr(p, attr string, data []byte) error {
+	return ErrNotSupported
+}
@@ -0,0 +1,54 @@
+//go:build darwin || linux
+// +build darwin linux
+
+package store
+
+import (
+	"errors"
+	"golang.org/x/sys/unix"
+	"os"
+)
+
+func getXattr(p, attr string) ([]byte, error) {
+	for {
+		sz, err := unix.Getxattr(p, attr, nil)
+		if err != nil {
+			return nil, xattrError(err)
+		}
+		data := make([]byte, sz)
+		n, err := unix.Getxattr(p, attr, data)
+		if errors.Is(err, unix.ERANGE) {
+			// the attribute grew between the calls
+			continue
+		}
+		if err != nil {
+			return nil, xattrError(err)
+		}
+		return data[0:n], nil
+	}
+}
+
+func setXattr(p, attr string, data []byte) error {
+	if data == nil {
+		err := unix.Removexattr(p, attr)
+		if err != nil && !os.IsNotExist(xattrError(err)) {
+			return xattrError(err)
+		}
+		return nil
+	}
+	return xattrError(unix.Setxattr(p, attr, data, 0))
+}
+
+func xattrError(err error) error {
+	var errno unix.Errno
+	if !errors.As(err, &errno) {
+		return err
+	}
+	switch errno {
+	case errNoAttr:
+		return os.ErrNotExist
+	case unix.ENOTSUP, unix.E2BIG, unix.ERANGE, unix.ENOSPC:
+		return ErrNotSupported
+	}
+	return err
+}